@@ -0,0 +1,89 @@
+package alexmatchen
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Networks allowed to call the /admin/ endpoints without credentials, parsed
+// from the ADMIN_IPS setting.
+var adminNets = parseNets("ADMIN_IPS", setting("ADMIN_IPS", ""))
+
+// Wraps an /admin/ handler so it's only called for requests passing
+// isAdmin. Other requests get a 403 and are logged.
+func adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			newContext(r).Warningf("Denied admin request for %s from %s", r.URL.Path, r.RemoteAddr)
+			jsonError(w, http.StatusForbidden, errForbidden, "admin access required")
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+// Reports whether a request passes any of the configured admin guards: the
+// admin token in the X-Admin-Token header, a remote address in ADMIN_IPS, or
+// HTTP basic auth matching ADMIN_USER and ADMIN_PASSWORD. Guards whose
+// settings are unset never pass. The token is never taken from the URL, where
+// it would end up in logs, browser history and Referer headers.
+func isAdmin(r *http.Request) bool {
+	if adminToken != "" && secureEqual(r.Header.Get("X-Admin-Token"), adminToken) {
+		return true
+	}
+
+	if ip := remoteIP(r); ip != nil {
+		for _, n := range adminNets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	if user, password, ok := r.BasicAuth(); ok && adminUser != "" && adminPassword != "" {
+		return secureEqual(user, adminUser) && secureEqual(password, adminPassword)
+	}
+
+	return false
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// Returns the IP of the client, with or without a port in RemoteAddr.
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return net.ParseIP(host)
+}
+
+// Parses a comma separated list of IPs and CIDR networks.
+func parseNets(name, v string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, item := range splitList(v) {
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			panic(fmt.Sprintf("invalid setting %s=%q: %v", name, v, err))
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets
+}
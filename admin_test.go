@@ -0,0 +1,47 @@
+package alexmatchen
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestIsAdmin(t *testing.T) {
+	prevToken, prevUser, prevPassword, prevNets := adminToken, adminUser, adminPassword, adminNets
+	defer func() { adminToken, adminUser, adminPassword, adminNets = prevToken, prevUser, prevPassword, prevNets }()
+	adminToken, adminUser, adminPassword, adminNets = "s3cret", "ops", "hunter2", parseNets("ADMIN_IPS", "10.0.0.0/8")
+
+	for _, tc := range []struct {
+		name   string
+		url    string
+		header http.Header
+		remote string
+		basic  []string
+		admin  bool
+	}{
+		{"token header", "/admin/config", http.Header{"X-Admin-Token": {"s3cret"}}, "", nil, true},
+		{"wrong token", "/admin/config", http.Header{"X-Admin-Token": {"guess"}}, "", nil, false},
+		{"token parameter", "/admin/config?token=s3cret", nil, "", nil, false},
+		{"admin network", "/admin/config", nil, "10.1.2.3:4567", nil, true},
+		{"basic auth", "/admin/config", nil, "", []string{"ops", "hunter2"}, true},
+		{"wrong password", "/admin/config", nil, "", []string{"ops", "hunter3"}, false},
+		{"nothing", "/admin/config", nil, "192.0.2.1:4567", nil, false},
+	} {
+		r, err := http.NewRequest("GET", tc.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for name, values := range tc.header {
+			r.Header[name] = values
+		}
+
+		r.RemoteAddr = tc.remote
+		if tc.basic != nil {
+			r.SetBasicAuth(tc.basic[0], tc.basic[1])
+		}
+
+		if got := isAdmin(r); got != tc.admin {
+			t.Errorf("%s: isAdmin = %v, want %v", tc.name, got, tc.admin)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package alexmatchen
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Runs of characters other than lowercase letters and digits, which league
+// identifiers replace with a dash.
+var nonSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
+type (
+	// The schedule in /api/v2/schedule: matches by date as YYYY-MM-DD, each
+	// day in kickoff order.
+	apiSchedule struct {
+		Days map[string][]*apiMatch `json:"days"`
+
+		// When the schedule was last refreshed, in RFC 3339.
+		LastRefresh string `json:"lastRefresh,omitempty"`
+	}
+
+	// A channel of a match in /api/v2/schedule, see channelInfo.
+	apiChannel struct {
+		Name string `json:"name"`
+		Logo string `json:"logo,omitempty"`
+		Link string `json:"link,omitempty"`
+	}
+
+	// A match in /api/v2/schedule. Unlike /schedule.json it carries no
+	// display strings, only fields meant for programs, with lowercase names.
+	apiMatch struct {
+		// Stable across refreshes, see matchID.
+		ID string `json:"id"`
+
+		// In RFC 3339 with the Stockholm offset. Omitted when upstream gave
+		// no usable time, leaving only the date the match is listed under.
+		Kickoff string `json:"kickoff,omitempty"`
+
+		Name  string `json:"name"`
+		Home  string `json:"home,omitempty"`
+		Away  string `json:"away,omitempty"`
+		Sport string `json:"sport,omitempty"`
+
+		// The league as listed, and a lowercase identifier of it without
+		// accents or punctuation, e.g. "premier-league".
+		League   string `json:"league,omitempty"`
+		LeagueID string `json:"leagueId,omitempty"`
+
+		Round    string   `json:"round,omitempty"`
+		Channels []string `json:"channels"`
+
+		// Logos and links of the channels, where upstream has them.
+		ChannelInfo []*apiChannel `json:"channelInfo,omitempty"`
+
+		Status string `json:"status"`
+		Source string `json:"source"`
+	}
+)
+
+func init() {
+	// The filters apply as to /schedule.json, but display parameters don't,
+	// as nothing here is meant for display
+	handle("/api/v2/schedule", func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseFilters(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		if err := refreshScheduleIfNeeded(w, r); err != nil {
+			jsonRefreshError(w, err)
+			return
+		}
+
+		s := f.apply(scheduleAt(cachedSchedule(), now()))
+		out := &apiSchedule{Days: make(map[string][]*apiMatch, len(s))}
+		for date, matches := range s {
+			out.Days[date] = apiMatches(matches)
+		}
+
+		if last, _ := refreshStatus(); !last.IsZero() {
+			out.LastRefresh = last.Format(time.RFC3339)
+		}
+
+		writeJSON(w, r, out)
+	})
+}
+
+// Returns the matches of a day as served by /api/v2/schedule, in kickoff
+// order.
+func apiMatches(matches []*match) []*apiMatch {
+	sorted := append([]*match(nil), matches...)
+	sort.Stable(byKickoff(sorted))
+
+	out := make([]*apiMatch, len(sorted))
+	for i, m := range sorted {
+		a := &apiMatch{
+			ID:       m.ID,
+			Name:     m.Name,
+			Home:     m.Home,
+			Away:     m.Away,
+			Sport:    m.Sport,
+			League:   m.League,
+			LeagueID: leagueID(m.League),
+			Round:    m.Round,
+			Channels: m.Channels,
+			Status:   m.Status,
+			Source:   m.Source,
+		}
+
+		if m.TimeKnown {
+			a.Kickoff = m.Kickoff.Format(time.RFC3339)
+		}
+
+		if a.Channels == nil {
+			a.Channels = []string{}
+		}
+
+		for _, info := range m.ChannelInfo {
+			a.ChannelInfo = append(a.ChannelInfo, &apiChannel{Name: info.Name, Logo: info.Logo, Link: info.Link})
+		}
+
+		out[i] = a
+	}
+
+	return out
+}
+
+// Returns the identifier of a league in /api/v2/schedule: lowercase, without
+// accents, and with anything but letters and digits turned into dashes.
+func leagueID(league string) string {
+	folded := accentFolder.Replace(strings.ToLower(normalizeText(league)))
+	return strings.Trim(nonSlug.ReplaceAllString(folded, "-"), "-")
+}
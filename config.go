@@ -0,0 +1,278 @@
+package alexmatchen
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Operator settings, read from the environment at startup. On App Engine they
+// are set under env_variables in app.yaml.
+var (
+	// Number of days scraped, stored and shown, and the ceiling of ?days=.
+	// Raise it if upstream lists more days. Can be overridden on
+	// /admin/config, see shownDays.
+	daysToShow = settingPositiveInt("DAYS_TO_SHOW", 10)
+
+	// A refresh yielding fewer than this fraction of the previous refresh's
+	// matches is logged as a suspected partial scrape.
+	dropThreshold = settingFloat("DROP_THRESHOLD", 0.5)
+
+	// Keep the previous schedule instead of a suspected partial scrape.
+	keepOnDrop = settingBool("KEEP_ON_DROP", false)
+
+	// Refresh an expired schedule within the request that finds it expired,
+	// making that visitor wait on the scrape. By default refreshing is left
+	// to cron calling /tasks/refresh: requests are served the last known
+	// schedule, flagged stale once it's older than cacheDuration, and
+	// content endpoints answer 503 until the first refresh. Instances pick
+	// up the schedule cron stored, see restoreSchedule.
+	refreshOnRequest = settingBool("REFRESH_ON_REQUEST", false)
+
+	// Content endpoints answer 503 rather than serve a schedule older than
+	// this, after refreshes kept failing.
+	maxDataAge = settingDuration("MAX_DATA_AGE", 48*time.Hour)
+
+	// Where the schedule is fetched from: primaryURL, possibly a cached mirror,
+	// and originURL when that fails or yields no matches.
+	primaryURL = setting("PRIMARY_URL", tvmatchenUrl)
+	originURL  = setting("ORIGIN_URL", tvmatchenUrl)
+
+	// Most pages fetched per refresh when upstream paginates the schedule.
+	maxPages = settingInt("MAX_PAGES", 3)
+
+	// Most sources fetched at once during a refresh. 1 fetches them one
+	// after the other.
+	fetchConcurrency = settingPositiveInt("FETCH_CONCURRENCY", 2)
+
+	// How long a fetch of a tvmatchen.nu page or another listing may take,
+	// and how many times a failed fetch is tried again, as upstream is
+	// occasionally flaky.
+	fetchTimeout = settingDuration("FETCH_TIMEOUT", 10*time.Second)
+	fetchRetries = settingInt("FETCH_RETRIES", 2)
+
+	// Sent with every request to tvmatchen.nu.
+	userAgent = setting("USER_AGENT", "MatchingApp/1.0 (+https://alex-matchen.appspot.com/)")
+
+	// Selectors the schedule is parsed with, see pageSelectors. The defaults
+	// match the tvmatchen.nu markup.
+	selectors = &pageSelectors{
+		Day:      setting("SELECTOR_DAY", "h2.day-name"),
+		DayID:    setting("SELECTOR_DAY_ID", "span.day-name-inner"),
+		Row:      setting("SELECTOR_ROW", ".match"),
+		Name:     setting("SELECTOR_NAME", ".match-name"),
+		League:   setting("SELECTOR_LEAGUE", ".league"),
+		Channel:  setting("SELECTOR_CHANNEL", ".channel .channel-item"),
+		Time:     setting("SELECTOR_TIME", ".time .field-content"),
+		NextPage: setting("SELECTOR_NEXT_PAGE", `a[rel="next"], .pager-next a`),
+	}
+
+	// Sports to scrape, as upstream "sport-name-*" class suffixes mapped to
+	// display names.
+	sports = settingMap("SPORTS", "fotboll=Fotboll")
+
+	// Sports shown when a request doesn't choose its own with ?sports=, by
+	// display name in SPORTS.
+	defaultSports = splitList(setting("DEFAULT_SPORTS", "Fotboll"))
+
+	// Typical durations of sports by display name, used for match end times,
+	// e.g. "Ishockey=2h30m". Other sports last matchDuration.
+	sportDurations = settingDurations("SPORT_DURATIONS", "")
+
+	// Display names of channels by upstream title, e.g. "TV4 Fotboll
+	// HD=TV4 Fotboll". Channels are filtered and shown by display name.
+	channelRenames = settingMap("CHANNEL_RENAMES", "")
+
+	// Channels available in each region or subscription bundle, as
+	// "region=Channel|Channel" pairs, e.g. "SE-basic=SVT1|SVT2|TV4".
+	channelRegions = settingLists("CHANNEL_REGIONS", "")
+
+	// Marquee fixtures and teams whose matches are highlighted, as a comma
+	// separated list of "Home|Away" pairs or single team names, e.g.
+	// "Arsenal|Tottenham,Hammarby".
+	marquee = splitList(setting("MARQUEE", ""))
+
+	// Leagues never stored, matched like the leagues filter, e.g.
+	// "U19,U21,Dam". They are dropped while scraping, so no request can show
+	// them, not even for INTEREST_TEAMS.
+	leagueBlocklist = splitList(setting("LEAGUE_BLOCKLIST", ""))
+
+	// Teams whose matches are shown along with the default leagues, whatever
+	// league they play in, e.g. "Hammarby,Malmö FF". Every league not in
+	// LEAGUE_BLOCKLIST is scraped and cached, so this only widens the default
+	// view.
+	interestTeams = splitList(setting("INTEREST_TEAMS", ""))
+
+	// Other names of teams, as "alias=Canonical name" pairs, e.g.
+	// "Spurs=Tottenham,Malmö=Malmö FF". Both sides are folded by foldTeam.
+	teamAliases = foldAliases(settingMap("TEAM_ALIASES", ""))
+
+	// Tags shown in front of team names with ?tags=true, such as flags, as
+	// "Team=tag" pairs keyed by canonical team name, e.g. "Arsenal=🏴".
+	teamTags = foldTags(settingMap("TEAM_TAGS", ""))
+
+	// Time zones linked from the HTML page to show kickoff times in, see
+	// ?tz=. Any IANA time zone can still be asked for by hand.
+	timeZones = splitList(setting("TIME_ZONES", "Europe/Stockholm,Europe/London,America/New_York,Asia/Bangkok"))
+
+	// Webhooks /tasks/notify posts today's matches to, as a comma separated
+	// list of URLs. Slack, Discord and Telegram sendMessage URLs, with the
+	// chat as ?chat_id=, get a chat message, and any other URL the digest
+	// as JSON, see /digest.json.
+	notifyWebhooks = splitList(setting("NOTIFY_WEBHOOKS", ""))
+
+	// Filters of the matches notified, as query parameters of the HTML
+	// page, e.g. "leagues=Allsvenskan&teams=Hammarby". Unset notifies the
+	// default leagues.
+	notifyFilters = setting("NOTIFY_FILTERS", "")
+
+	// Origins whose pages may read the responses, e.g.
+	// "https://example.com", or * for any. Unset sends no CORS headers,
+	// while JSONP with ?callback= works regardless.
+	corsOrigins = splitList(setting("CORS_ORIGINS", ""))
+
+	// Credentials for the /admin/ endpoints, see isAdmin. The endpoints are
+	// disabled while none are set.
+	adminToken    = setting("ADMIN_TOKEN", "")
+	adminUser     = setting("ADMIN_USER", "")
+	adminPassword = setting("ADMIN_PASSWORD", "")
+)
+
+// Returns the named setting, or def if it isn't set.
+func setting(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+
+	return def
+}
+
+// Returns a setting in the "key=value,key=value" form as a map.
+func settingMap(name, def string) map[string]string {
+	v := setting(name, def)
+	m := make(map[string]string)
+	for _, pair := range splitList(v) {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			panic(fmt.Sprintf("invalid setting %s=%q: %q is not key=value", name, v, pair))
+		}
+
+		m[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return m
+}
+
+func foldAliases(aliases map[string]string) map[string]string {
+	folded := make(map[string]string, len(aliases))
+	for alias, canonical := range aliases {
+		folded[foldTeam(alias)] = foldTeam(canonical)
+	}
+
+	return folded
+}
+
+func foldTags(tags map[string]string) map[string]string {
+	folded := make(map[string]string, len(tags))
+	for team, tag := range tags {
+		folded[teamKey(team)] = tag
+	}
+
+	return folded
+}
+
+// Returns a setting in the "key=a|b,key=c" form as a map of lists.
+func settingLists(name, def string) map[string][]string {
+	lists := make(map[string][]string)
+	for key, v := range settingMap(name, def) {
+		for _, item := range strings.Split(v, "|") {
+			if item = normalizeText(item); item != "" {
+				lists[key] = append(lists[key], item)
+			}
+		}
+	}
+
+	return lists
+}
+
+// Returns a setting in the "key=duration,key=duration" form as a map.
+func settingDurations(name, def string) map[string]time.Duration {
+	durations := make(map[string]time.Duration)
+	for key, v := range settingMap(name, def) {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			panic(fmt.Sprintf("invalid setting %s: %q is not a positive duration", name, v))
+		}
+
+		durations[key] = d
+	}
+
+	return durations
+}
+
+func settingFloat(name string, def float64) float64 {
+	v := setting(name, "")
+	if v == "" {
+		return def
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		panic(fmt.Sprintf("invalid setting %s=%q: %v", name, v, err))
+	}
+
+	return f
+}
+
+func settingInt(name string, def int) int {
+	v := setting(name, "")
+	if v == "" {
+		return def
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		panic(fmt.Sprintf("invalid setting %s=%q: %v", name, v, err))
+	}
+
+	return i
+}
+
+func settingDuration(name string, def time.Duration) time.Duration {
+	v := setting(name, "")
+	if v == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		panic(fmt.Sprintf("invalid setting %s=%q: not a positive duration", name, v))
+	}
+
+	return d
+}
+
+func settingPositiveInt(name string, def int) int {
+	i := settingInt(name, def)
+	if i < 1 {
+		panic(fmt.Sprintf("invalid setting %s=%d: must be positive", name, i))
+	}
+
+	return i
+}
+
+func settingBool(name string, def bool) bool {
+	v := setting(name, "")
+	if v == "" {
+		return def
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		panic(fmt.Sprintf("invalid setting %s=%q: %v", name, v, err))
+	}
+
+	return b
+}
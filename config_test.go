@@ -0,0 +1,41 @@
+package alexmatchen
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Reports whether reading a setting panics, as invalid settings do.
+func panics(f func()) (panicked bool) {
+	defer func() { panicked = recover() != nil }()
+	f()
+	return false
+}
+
+func TestSettingDurations(t *testing.T) {
+	const name = "TEST_SPORT_DURATIONS"
+	defer os.Unsetenv(name)
+
+	for _, tc := range []struct {
+		v     string
+		want  map[string]time.Duration
+		fails bool
+	}{
+		{"", map[string]time.Duration{}, false},
+		{"Ishockey=2h30m", map[string]time.Duration{"Ishockey": 2*time.Hour + 30*time.Minute}, false},
+		{" Ishockey = 2h30m , Handboll=1h45m", map[string]time.Duration{"Ishockey": 2*time.Hour + 30*time.Minute, "Handboll": time.Hour + 45*time.Minute}, false},
+		{"Ishockey=150", nil, true},
+		{"Ishockey=-1h", nil, true},
+		{"Ishockey", nil, true},
+	} {
+		os.Setenv(name, tc.v)
+		var got map[string]time.Duration
+		if failed := panics(func() { got = settingDurations(name, "") }); failed != tc.fails {
+			t.Errorf("%s=%q panicked %v, want %v", name, tc.v, failed, tc.fails)
+		} else if !tc.fails && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s=%q = %v, want %v", name, tc.v, got, tc.want)
+		}
+	}
+}
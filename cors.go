@@ -0,0 +1,56 @@
+package alexmatchen
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Lets pages on the sites of CORS_ORIGINS read responses, and answers their
+// preflight requests, which browsers send before conditional requests with
+// If-None-Match among others. Requests from other origins are served as
+// usual, only without the headers allowing their pages to read them.
+func withCORS(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allowed := allowedOrigin(origin)
+		if allowed != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowed)
+			w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Last-Refresh, X-Request-ID, X-Data-Stale, Warning")
+		}
+
+		// The allowed origin depends on the origin asking unless any is
+		if len(corsOrigins) > 0 && allowed != "*" {
+			w.Header().Add("Vary", "Origin")
+		}
+
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			if allowed != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD")
+				w.Header().Set("Access-Control-Allow-Headers", "If-None-Match, If-Modified-Since, "+requestIDHeader)
+				w.Header().Set("Access-Control-Max-Age", "86400")
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+// Returns the Access-Control-Allow-Origin for a request from origin: "*"
+// when CORS_ORIGINS allows any, the origin itself when listed, or "" when
+// not allowed.
+func allowedOrigin(origin string) string {
+	for _, allowed := range corsOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+
+		if origin != "" && strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,43 @@
+package alexmatchen
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, map[string][]*match{"2014-05-18": {
+		testMatch("2014-05-18", "18:00", "Liverpool - Newcastle", "Viasat Fotboll"),
+	}})()
+
+	prev := corsOrigins
+	defer func() { corsOrigins = prev }()
+	corsOrigins = []string{"https://widget.example"}
+
+	// Pages on the site can read the freshness headers too, such as whether
+	// a stale schedule is served
+	w := serve(t, inst, "/schedule.json", http.Header{"Origin": {"https://widget.example"}})
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://widget.example" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the origin", got)
+	}
+
+	exposed := map[string]bool{}
+	for _, name := range strings.Split(w.Header().Get("Access-Control-Expose-Headers"), ",") {
+		exposed[strings.TrimSpace(name)] = true
+	}
+
+	for _, name := range []string{"ETag", "X-Last-Refresh", "X-Request-ID", "X-Data-Stale", "Warning"} {
+		if !exposed[name] {
+			t.Errorf("Access-Control-Expose-Headers = %q, missing %s", w.Header().Get("Access-Control-Expose-Headers"), name)
+		}
+	}
+
+	// Other origins are served without them
+	w = serve(t, inst, "/schedule.json", http.Header{"Origin": {"https://elsewhere.example"}})
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" || w.Header().Get("Access-Control-Expose-Headers") != "" {
+		t.Errorf("GET /schedule.json from another origin = %d with CORS headers %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,27 @@
+package alexmatchen
+
+import "net/http"
+
+// Body of /count.json.
+type matchCount struct {
+	Count int
+}
+
+func init() {
+	// Number of matches passing the filters, for clients that only show a
+	// total such as a badge
+	handle("/count.json", func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseFilters(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		if err := refreshScheduleIfNeeded(w, r); err != nil {
+			jsonRefreshError(w, err)
+			return
+		}
+
+		writeJSON(w, r, &matchCount{Count: countMatches(f.apply(scheduleAt(cachedSchedule(), now())))})
+	})
+}
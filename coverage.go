@@ -0,0 +1,76 @@
+package alexmatchen
+
+import (
+	"net/http"
+	"sort"
+)
+
+// Number of the filtered matches a channel carries, see
+// /channel-coverage.json.
+type channelCoverage struct {
+	Channel string
+	Matches int
+}
+
+// Sorts channels by coverage, most matches first and ties by name.
+type byCoverage []*channelCoverage
+
+func (c byCoverage) Len() int      { return len(c) }
+func (c byCoverage) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c byCoverage) Less(i, j int) bool {
+	if c[i].Matches != c[j].Matches {
+		return c[i].Matches > c[j].Matches
+	}
+
+	return c[i].Channel < c[j].Channel
+}
+
+func init() {
+	handle("/channel-coverage.json", func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseFilters(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		d, err := parseDisplay(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		if err := refreshScheduleIfNeeded(w, r); err != nil {
+			jsonRefreshError(w, err)
+			return
+		}
+
+		writeJSON(w, r, coverageOf(flatten(f.apply(d.format(scheduleAt(cachedSchedule(), now()))))))
+	})
+}
+
+// Counts the matches each channel carries, to help pick the one subscription
+// covering the most games. A match counts once for every channel it airs on,
+// even if the channel is listed twice, and matches without channels aren't
+// counted.
+func coverageOf(matches []*match) []*channelCoverage {
+	coverage := []*channelCoverage{}
+	for channel, carried := range groupBy(matches, distinctChannels) {
+		coverage = append(coverage, &channelCoverage{Channel: channel, Matches: len(carried)})
+	}
+
+	sort.Sort(byCoverage(coverage))
+	return coverage
+}
+
+func distinctChannels(m *match) []string {
+	seen := make(map[string]bool, len(m.Channels))
+	channels := []string{}
+	for _, channel := range m.Channels {
+		if !seen[channel] {
+			seen[channel] = true
+			channels = append(channels, channel)
+		}
+	}
+
+	return channels
+}
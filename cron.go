@@ -0,0 +1,69 @@
+package alexmatchen
+
+import (
+	"net/http"
+	"time"
+)
+
+// Result of a cron triggered refresh.
+type refreshSummary struct {
+	Days        int
+	Matches     int
+	LastRefresh string
+
+	// Set when no refresh was due, so the schedule was left as it is.
+	Skipped bool `json:",omitempty"`
+}
+
+func init() {
+	// Cron calls /tasks/refresh. /refresh is the same task for admins
+	// refreshing by hand, and /cron/refresh is kept for deployments whose
+	// cron.yaml still points there
+	handle("/tasks/refresh", refreshTask)
+	handle("/refresh", refreshTask)
+	handle("/cron/refresh", refreshTask)
+}
+
+// Reports whether a request comes from App Engine cron, which strips the
+// header from external requests so only cron can send it, or from an admin
+// running a task by hand.
+func cronOrAdmin(r *http.Request) bool {
+	return r.Header.Get("X-Appengine-Cron") == "true" || isAdmin(r)
+}
+
+// Refreshes the schedule out of band, for cron. Cron calls every few
+// minutes, so the schedule is only refreshed once due, see nextRefresh, and
+// failed refreshes are retried after their backoff rather than at the next
+// interval. Until a schedule is loaded every call refreshes, and admins
+// running the task by hand always do.
+func refreshTask(w http.ResponseWriter, r *http.Request) {
+	if !cronOrAdmin(r) {
+		jsonError(w, http.StatusForbidden, errForbidden, "only callable by App Engine cron or admins")
+		return
+	}
+
+	// Another instance may have refreshed since this one last looked
+	if storeCheckDue() {
+		restoreSchedule(r)
+	}
+
+	byHand := r.Header.Get("X-Appengine-Cron") != "true"
+	skipped := !byHand && cachedSchedule() != nil && !now().After(nextRefresh())
+	if !skipped {
+		if err := refreshSchedule(r); err != nil {
+			jsonRefreshError(w, err)
+			return
+		}
+	}
+
+	mu.RLock()
+	summary := &refreshSummary{
+		Days:        len(schedule),
+		Matches:     countMatches(schedule),
+		LastRefresh: lastRefresh.Format(time.RFC3339),
+		Skipped:     skipped,
+	}
+	mu.RUnlock()
+
+	writeJSON(w, r, summary)
+}
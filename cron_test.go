@@ -0,0 +1,131 @@
+package alexmatchen
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRefreshTask(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, nil)()
+	defer useTransport(pageTransport(func(r *http.Request) string { return selftestFixture }))()
+
+	for _, path := range []string{"/refresh", "/tasks/refresh", "/cron/refresh"} {
+		if w := serve(t, inst, path, nil); w.Code != http.StatusForbidden {
+			t.Errorf("GET %s by a visitor = %d, want 403", path, w.Code)
+		}
+
+		if w := serve(t, inst, path, http.Header{"X-Appengine-Cron": {"true"}}); w.Code != http.StatusOK {
+			t.Errorf("GET %s by cron = %d %s, want 200", path, w.Code, w.Body)
+		}
+	}
+
+	if got := countMatches(cachedSchedule()); got != selftestMatches {
+		t.Errorf("cached %d matches after refreshing, want %d", got, selftestMatches)
+	}
+}
+
+// Content endpoints never scrape, unless REFRESH_ON_REQUEST is set.
+func TestNoRefreshOnRequest(t *testing.T) {
+	if refreshOnRequest {
+		t.Skip("REFRESH_ON_REQUEST is set")
+	}
+
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, nil)()
+
+	var fetches int32
+	defer useTransport(pageTransport(func(r *http.Request) string {
+		atomic.AddInt32(&fetches, 1)
+		return selftestFixture
+	}))()
+
+	// Until cron has refreshed, there's nothing to serve
+	if w := serve(t, inst, "/schedule.json", nil); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /schedule.json before the first refresh = %d, want 503", w.Code)
+	}
+
+	if w := serve(t, inst, "/", nil); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET / before the first refresh = %d, want 503", w.Code)
+	}
+
+	serve(t, inst, "/tasks/refresh", http.Header{"X-Appengine-Cron": {"true"}})
+	atomic.StoreInt32(&fetches, 0)
+
+	// Long after the refresh expired, the last refresh is still served
+	now = func() time.Time { return testNow.Add(cacheDuration + time.Hour) }
+	w := serve(t, inst, "/schedule.json?leagues=Premier+League", nil)
+	if w.Code != http.StatusOK || w.Header().Get("X-Data-Stale") != "true" {
+		t.Errorf("GET /schedule.json after the refresh expired = %d, stale %q, want 200 flagged stale", w.Code, w.Header().Get("X-Data-Stale"))
+	}
+
+	if w.Header().Get("X-Last-Refresh") != testNow.Format(time.RFC3339) {
+		t.Errorf("X-Last-Refresh = %q, want the refresh by cron at %s", w.Header().Get("X-Last-Refresh"), testNow.Format(time.RFC3339))
+	}
+
+	if n := atomic.LoadInt32(&fetches); n > 0 {
+		t.Errorf("serving the expired schedule fetched upstream %d times", n)
+	}
+}
+
+// Cron calls often, but only refreshes once due or after a failure's backoff.
+func TestRefreshTaskWhenDue(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, map[string][]*match{"2014-05-17": {
+		testMatch("2014-05-17", "21:00", "Arsenal - Hull"),
+	}})()
+
+	prevDelay, prevRetries := fetchRetryDelay, fetchRetries
+	defer func() { fetchRetryDelay, fetchRetries = prevDelay, prevRetries }()
+	fetchRetryDelay, fetchRetries = time.Millisecond, 1
+
+	var fetches int32
+	up := true
+	defer useTransport(pageTransport(func(r *http.Request) string {
+		atomic.AddInt32(&fetches, 1)
+		if !up {
+			return "<html><body></body></html>"
+		}
+
+		return selftestFixture
+	}))()
+
+	// An empty page fails the refresh as a suspected partial scrape
+	prevThreshold, prevKeep := dropThreshold, keepOnDrop
+	defer func() { dropThreshold, keepOnDrop = prevThreshold, prevKeep }()
+	dropThreshold, keepOnDrop = 0.5, true
+
+	due := testNow.Add(refreshInterval() + time.Minute)
+	for _, tc := range []struct {
+		name    string
+		at      time.Time
+		up      bool
+		fetched bool
+		ok      bool
+	}{
+		{"just refreshed", testNow.Add(time.Minute), true, false, true},
+		{"interval over", due, false, true, false},
+		{"within the backoff", due.Add(retryDelay - time.Minute), true, false, true},
+		{"backoff over", due.Add(retryDelay + time.Minute), true, true, true},
+		{"refreshed again", due.Add(retryDelay + 2*time.Minute), true, false, true},
+	} {
+		at := tc.at
+		now = func() time.Time { return at }
+		up = tc.up
+		atomic.StoreInt32(&fetches, 0)
+		w := serve(t, inst, "/tasks/refresh", http.Header{"X-Appengine-Cron": {"true"}})
+
+		if fetched := atomic.LoadInt32(&fetches) > 0; fetched != tc.fetched {
+			t.Errorf("%s: fetched upstream %v, want %v", tc.name, fetched, tc.fetched)
+		}
+
+		if ok := w.Code == http.StatusOK; ok != tc.ok {
+			t.Errorf("%s: GET /tasks/refresh = %d %s, want success %v", tc.name, w.Code, w.Body, tc.ok)
+		}
+	}
+}
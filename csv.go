@@ -0,0 +1,58 @@
+package alexmatchen
+
+import (
+	"encoding/csv"
+	"io"
+	"net/http"
+)
+
+// Columns of /schedule.csv.
+var csvHeader = []string{"date", "time", "home", "away", "league", "channel"}
+
+func init() {
+	handle("/schedule.csv", func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseFilters(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := refreshScheduleIfNeeded(w, r); err != nil {
+			http.Error(w, err.Error(), refreshErrorStatus(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="matcher.csv"`)
+
+		// A byte order mark makes spreadsheets read the file as UTF-8
+		// rather than mangle å, ä and ö
+		w.Write([]byte("\ufeff"))
+		writeCSV(w, orderedDays(f.apply(scheduleAt(cachedSchedule(), now()))))
+	})
+}
+
+// Writes one row per match of the days, after a header row. Matches
+// without a known kickoff have an empty time, and those whose name couldn't
+// be split into teams have it all as the home team.
+func writeCSV(w io.Writer, days []*day) {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, d := range days {
+		for _, m := range d.Matches {
+			clock := m.Time
+			if !m.TimeKnown {
+				clock = ""
+			}
+
+			home, away := m.Home, m.Away
+			if home == "" && away == "" {
+				home = m.Name
+			}
+
+			cw.Write([]string{d.Date, clock, home, away, m.League, m.Channel})
+		}
+	}
+
+	cw.Flush()
+}
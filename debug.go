@@ -0,0 +1,183 @@
+package alexmatchen
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Reasons a day or match row is skipped by parseSchedule.
+const (
+	skipDayLimit       = "day_limit"
+	skipDayIDMissing   = "day_id_missing"
+	skipDayIDMalformed = "day_id_malformed"
+	skipOtherSport     = "other_sport"
+	skipBlockedLeague  = "blocked_league"
+)
+
+// Local hours from which and until which hardly any match is broadcast live.
+// Kickoffs in between are suspect, while late evening and just after
+// midnight ones aren't.
+const (
+	suspectFrom = 3
+	suspectTo   = 9
+)
+
+// Diagnostics of one parse, shown by /schedule.json?debug=true to admins.
+type parseStats struct {
+	// Days found in the page, and match rows in the days within daysToShow,
+	// before skipping any.
+	DaysSeen int
+	RowsSeen int
+
+	DaysParsed int
+	RowsParsed int
+
+	// Skipped days and rows by reason.
+	Skipped map[string]int
+
+	// Matches parsed with a suspect kickoff, see suspectKickoff.
+	Suspect int
+
+	// Number of elements matched by each selector used.
+	SelectorHits map[string]int
+}
+
+func newParseStats() *parseStats {
+	return &parseStats{Skipped: make(map[string]int), SelectorHits: make(map[string]int)}
+}
+
+// Adds the stats of another page to s.
+func (s *parseStats) add(o *parseStats) {
+	s.DaysSeen += o.DaysSeen
+	s.RowsSeen += o.RowsSeen
+	s.DaysParsed += o.DaysParsed
+	s.RowsParsed += o.RowsParsed
+	s.Suspect += o.Suspect
+	for reason, n := range o.Skipped {
+		s.Skipped[reason] += n
+	}
+
+	for selector, n := range o.SelectorHits {
+		s.SelectorHits[selector] += n
+	}
+}
+
+// Reports whether a kickoff is at a local hour hardly any match is broadcast,
+// from suspectFrom until suspectTo. It's a hint for diagnostics rather than a
+// filter. Unknown kickoffs are never suspect.
+func suspectKickoff(kickoff time.Time) bool {
+	if kickoff.IsZero() {
+		return false
+	}
+
+	h := kickoff.In(stockholm).Hour()
+	return h >= suspectFrom && h < suspectTo
+}
+
+// Flags the matches of a schedule with a suspect kickoff. The schedule must
+// be a copy such as those returned by scheduleAt.
+func flagSuspects(s map[string][]*match) {
+	for _, matches := range s {
+		for _, m := range matches {
+			m.Suspect = suspectKickoff(m.Kickoff)
+		}
+	}
+}
+
+// Stats of the parse the current schedule came from, nil until the first
+// successful refresh. Guarded by mu.
+var lastParseStats *parseStats
+
+// Reports whether a request asks for diagnostics with ?debug=true and may see
+// them. Diagnostics are only for admins, and silently left out for others.
+func wantsDebug(r *http.Request) (bool, error) {
+	debug, err := parseBoolParam(r, "debug")
+	if err != nil {
+		return false, err
+	}
+
+	return debug && isAdmin(r), nil
+}
+
+// Prefix of the provenance of fields from another listing than tvmatchen.nu,
+// followed by the name of the listing, e.g. "listing:streaming.example".
+const listingProvenance = "listing:"
+
+// Sets the provenance of every match in a schedule, which must be a copy
+// such as those returned by scheduleAt. Fields of scraped matches are
+// attributed to the selectors they were scraped with, and those of matches
+// from other listings to their listing. Channels merged into a scraped match
+// are attributed to their listings as well.
+func addProvenance(s map[string][]*match) map[string][]*match {
+	scraped := map[string]string{
+		"Name":       selectors.Name,
+		"RawName":    selectors.Name,
+		"Home":       selectors.Name,
+		"Away":       selectors.Name,
+		"Sport":      sportSelector(),
+		"League":     selectors.League,
+		"Round":      selectors.League,
+		"Channel":    selectors.Channel,
+		"Channels":   selectors.Channel,
+		"RawChannel": selectors.Channel,
+		"Time":       selectors.Time,
+		"RawTime":    selectors.Time,
+		"Kickoff":    selectors.Time,
+	}
+
+	for _, matches := range s {
+		for _, m := range matches {
+			switch {
+			case m.Source != sourceName:
+				m.Provenance = make(map[string]string, len(scraped))
+				for field := range scraped {
+					m.Provenance[field] = listingProvenance + m.Source
+				}
+			case len(m.ChannelSources) > 0:
+				m.Provenance = make(map[string]string, len(scraped))
+				for field, selector := range scraped {
+					m.Provenance[field] = selector
+				}
+
+				channels := selectors.Channel + " + " + channelListings(m.ChannelSources)
+				m.Provenance["Channel"], m.Provenance["Channels"] = channels, channels
+			default:
+				m.Provenance = scraped
+			}
+		}
+	}
+
+	return s
+}
+
+// Returns the listings channels were merged from, in provenance form and
+// alphabetical order, e.g. "listing:a.example, listing:b.example".
+func channelListings(sources map[string]string) string {
+	seen := make(map[string]bool, len(sources))
+	var names []string
+	for _, source := range sources {
+		if !seen[source] {
+			seen[source] = true
+			names = append(names, listingProvenance+source)
+		}
+	}
+
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Returns a schedule with the diagnostics of the last parse added under the
+// "_debug" key.
+func withDebug(s map[string][]*match) map[string]interface{} {
+	out := make(map[string]interface{}, len(s)+1)
+	for date, matches := range s {
+		out[date] = matches
+	}
+
+	mu.RLock()
+	out["_debug"] = lastParseStats
+	mu.RUnlock()
+	return out
+}
@@ -0,0 +1,44 @@
+package alexmatchen
+
+import "testing"
+
+func TestAddProvenance(t *testing.T) {
+	scraped := testMatch("2014-05-17", "19:00", "Chelsea - Everton", "C More Sport")
+
+	listed := testMatch("2014-05-17", "21:00", "Arsenal - Hull", "Viaplay")
+	listed.Source = "streaming.example"
+
+	merged := testMatch("2014-05-18", "18:00", "Liverpool - Newcastle", "TV4", "Viaplay", "Ruutu")
+	merged.ChannelSources = map[string]string{"Viaplay": "streaming.example", "Ruutu": "a.example"}
+
+	addProvenance(map[string][]*match{
+		"2014-05-17": {scraped, listed},
+		"2014-05-18": {merged},
+	})
+
+	for _, tc := range []struct {
+		m     *match
+		field string
+		want  string
+	}{
+		{scraped, "Name", selectors.Name},
+		{scraped, "Channels", selectors.Channel},
+		{scraped, "Kickoff", selectors.Time},
+		{listed, "Name", "listing:streaming.example"},
+		{listed, "Channels", "listing:streaming.example"},
+		{listed, "Kickoff", "listing:streaming.example"},
+		{merged, "Name", selectors.Name},
+		{merged, "RawChannel", selectors.Channel},
+		{merged, "Channels", selectors.Channel + " + listing:a.example, listing:streaming.example"},
+		{merged, "Channel", selectors.Channel + " + listing:a.example, listing:streaming.example"},
+	} {
+		if got := tc.m.Provenance[tc.field]; got != tc.want {
+			t.Errorf("provenance of %s of %s = %q, want %q", tc.field, tc.m.Name, got, tc.want)
+		}
+	}
+
+	// Merged channels don't leak into the provenance of other matches
+	if got := scraped.Provenance["Channels"]; got != selectors.Channel {
+		t.Errorf("provenance of Channels of %s = %q after merged ones, want %q", scraped.Name, got, selectors.Channel)
+	}
+}
@@ -0,0 +1,57 @@
+package alexmatchen
+
+import "net/http"
+
+// Changes between the previous and current schedule, by match ID.
+type scheduleDiff struct {
+	Added   []*match
+	Removed []*match
+
+	// Matches in both whose channels or time changed, as they are now.
+	Changed []*match
+}
+
+func init() {
+	handle("/diff.json", func(w http.ResponseWriter, r *http.Request) {
+		if err := refreshScheduleIfNeeded(w, r); err != nil {
+			jsonRefreshError(w, err)
+			return
+		}
+
+		mu.RLock()
+		prev, cur := previousSchedule, schedule
+		mu.RUnlock()
+
+		writeJSON(w, r, diffSchedules(prev, cur))
+	})
+}
+
+// Compares two schedules. All lists are empty rather than nil when nothing
+// changed, and sorted by kickoff.
+func diffSchedules(prev, cur map[string][]*match) *scheduleDiff {
+	before := make(map[string]*match)
+	for _, m := range flatten(prev) {
+		before[m.ID] = m
+	}
+
+	diff := &scheduleDiff{Added: []*match{}, Removed: []*match{}, Changed: []*match{}}
+	seen := make(map[string]bool)
+	for _, m := range flatten(cur) {
+		seen[m.ID] = true
+		old, ok := before[m.ID]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, m)
+		case old.Channel != m.Channel || old.Time != m.Time:
+			diff.Changed = append(diff.Changed, m)
+		}
+	}
+
+	for _, m := range flatten(prev) {
+		if !seen[m.ID] {
+			diff.Removed = append(diff.Removed, m)
+		}
+	}
+
+	return diff
+}
@@ -0,0 +1,66 @@
+package alexmatchen
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Body of /digest.json, a summary of today's matches small enough for a push
+// notification.
+type digest struct {
+	Date    string
+	Count   int
+	Text    string
+	Matches []*digestMatch
+}
+
+type digestMatch struct {
+	Name    string
+	Time    string
+	Channel string
+}
+
+func init() {
+	handle("/digest.json", func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseTeamFilters(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		d, err := parseDisplay(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		if err := refreshScheduleIfNeeded(w, r); err != nil {
+			jsonRefreshError(w, err)
+			return
+		}
+
+		writeJSON(w, r, digestOf(f.apply(d.format(scheduleAt(cachedSchedule(), now()))), now(), d.lang()))
+	})
+}
+
+// Summarizes the matches of the day of t in a schedule, e.g. "Arsenal -
+// Chelsea 19:00 på TV4".
+func digestOf(s map[string][]*match, t time.Time, lang string) *digest {
+	date := midnight(t).Format("2006-01-02")
+	dg := &digest{Date: date, Matches: []*digestMatch{}}
+
+	var lines []string
+	for _, m := range s[date] {
+		dg.Matches = append(dg.Matches, &digestMatch{Name: m.Name, Time: m.Time, Channel: m.Channel})
+		lines = append(lines, m.Name+" "+m.Time+" "+translate(lang, "digest.on")+" "+m.Channel)
+	}
+
+	dg.Count = len(dg.Matches)
+	dg.Text = strings.Join(lines, "; ")
+	if dg.Count == 0 {
+		dg.Text = translate(lang, "digest.none")
+	}
+
+	return dg
+}
@@ -0,0 +1,452 @@
+package alexmatchen
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request time presentation options, as opposed to filters which choose the
+// matches shown.
+type display struct {
+	// Show kickoff times in 12-hour format, see ?clock=12.
+	Clock12 bool
+
+	// Languages asked for with ?lang=sv,en. The first one is used for the
+	// HTML page, and all of them for the weekday labels in JSON.
+	Langs []string
+
+	// Leave out all localized labels, see ?lang=none. Days are then labelled
+	// with their ISO dates only.
+	Neutral bool
+
+	// The language of the Accept-Language header when ?lang= gives none,
+	// used for the HTML page and labels like the first of Langs, but without
+	// adding weekday labels to JSON.
+	AcceptedLang string
+
+	// Channels whose matches are listed first within each day, on the HTML
+	// page and in JSON alike, see ?preferChannel=SVT,TV4. Unlike the channels
+	// filter every match is still shown.
+	PreferChannels []string
+
+	// Teams whose matches are highlighted and listed first within each day,
+	// before those on preferred channels, see ?favorites=Arsenal,Hammarby.
+	// Default to the favorites saved on /preferences. Like preferred
+	// channels they don't hide any match, unlike ?team=.
+	Favorites []string
+
+	// Tag teams listed in TEAM_TAGS, see ?tags=true.
+	Tags bool
+
+	// Time zone kickoff times are shown in, see ?tz=Europe/London. Nil
+	// shows them in Stockholm time like upstream. Matches are still listed
+	// under their day in Stockholm.
+	Location *time.Location
+
+	// Collapse the whitespace of the HTML page, see ?min=true. Always on
+	// for the ?view=min mobile view.
+	Minify bool
+
+	// Leave out the league or channel of each row on the HTML page when they
+	// are the same for every match shown, see ?hideRedundant=true.
+	HideRedundant bool
+
+	// Group each day's matches by league on the HTML list page, with a
+	// heading per league instead of the league on every row, see
+	// ?collapseLeagues=true.
+	CollapseLeagues bool
+
+	// Most matches listed per day on the HTML page, see ?maxPerDay=5. Zero
+	// lists them all.
+	MaxPerDay int
+}
+
+// Parses the presentation parameters of a request.
+func parseDisplay(r *http.Request) (*display, error) {
+	d := &display{}
+
+	switch v := r.FormValue("clock"); v {
+	case "", "24":
+	case "12":
+		d.Clock12 = true
+	default:
+		return nil, fmt.Errorf("clock must be 12 or 24, got %q", v)
+	}
+
+	d.PreferChannels = splitList(r.FormValue("preferChannel"))
+
+	// LoadLocation takes "" for UTC and "Local" for the server's zone,
+	// neither of which is asked for by name
+	if v := r.FormValue("tz"); v != "" {
+		loc, err := time.LoadLocation(v)
+		if err != nil || v == "Local" {
+			return nil, fmt.Errorf("unknown time zone %q", v)
+		}
+
+		d.Location = loc
+	}
+
+	favorites := r.FormValue("favorites")
+	if favorites == "" {
+		favorites = preference(r, "favorites")
+	}
+
+	d.Favorites = splitList(favorites)
+
+	var err error
+	if d.HideRedundant, err = parseBoolParam(r, "hideRedundant"); err != nil {
+		return nil, err
+	}
+
+	if d.CollapseLeagues, err = parseBoolParam(r, "collapseLeagues"); err != nil {
+		return nil, err
+	}
+
+	if v := r.FormValue("maxPerDay"); v != "" {
+		if d.MaxPerDay, err = strconv.Atoi(v); err != nil || d.MaxPerDay < 1 {
+			return nil, fmt.Errorf("maxPerDay must be a positive integer, got %q", v)
+		}
+	}
+
+	if d.Tags, err = parseBoolParam(r, "tags"); err != nil {
+		return nil, err
+	}
+
+	if d.Minify, err = parseBoolParam(r, "min"); err != nil {
+		return nil, err
+	}
+
+	d.Minify = d.Minify || r.FormValue("view") == "min"
+
+	// Unknown languages are ignored rather than rejected
+	for _, lang := range splitList(r.FormValue("lang")) {
+		if lang == langNone {
+			d.Neutral = true
+		} else if _, ok := catalogs[lang]; ok {
+			d.Langs = append(d.Langs, lang)
+		}
+	}
+
+	if d.Neutral {
+		d.Langs = nil
+	} else if len(d.Langs) == 0 {
+		d.AcceptedLang = acceptedLang(r.Header.Get("Accept-Language"))
+	}
+
+	return d, nil
+}
+
+// Returns a timestamp as shown on the HTML page: localized, or RFC 3339 when
+// leaving out localized labels.
+func (d *display) stamp(t time.Time) string {
+	if d.Neutral {
+		return t.Format(time.RFC3339)
+	}
+
+	return stampLabel(t, d.lang())
+}
+
+// Returns the time zone kickoff times are shown in.
+func (d *display) zone() *time.Location {
+	if d.Location != nil {
+		return d.Location
+	}
+
+	return stockholm
+}
+
+// Returns links to the HTML page with kickoff times in each of TIME_ZONES,
+// keeping the other parameters of the request.
+func zoneLinks(r *http.Request, current *time.Location) []*pageLink {
+	links := make([]*pageLink, len(timeZones))
+	for i, name := range timeZones {
+		q := r.URL.Query()
+		q.Set("tz", name)
+		links[i] = &pageLink{
+			Name:   zoneName(name),
+			URL:    (&url.URL{Path: "/", RawQuery: q.Encode()}).String(),
+			Chosen: name == current.String(),
+		}
+	}
+
+	return links
+}
+
+// Returns links to the HTML page in each language, named in the language
+// itself and keeping the other parameters of the request.
+func langLinks(r *http.Request, current string) []*pageLink {
+	langs := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+
+	sort.Strings(langs)
+	links := make([]*pageLink, len(langs))
+	for i, lang := range langs {
+		q := r.URL.Query()
+		q.Set("lang", lang)
+		links[i] = &pageLink{
+			Name:   translate(lang, "lang.name"),
+			URL:    (&url.URL{Path: "/", RawQuery: q.Encode()}).String(),
+			Chosen: lang == current,
+		}
+	}
+
+	return links
+}
+
+// Returns the city of an IANA time zone, e.g. "New York" for
+// America/New_York.
+func zoneName(name string) string {
+	return strings.Replace(name[strings.LastIndex(name, "/")+1:], "_", " ", -1)
+}
+
+// Returns the layout kickoff times are shown in.
+func (d *display) clockLayout() string {
+	if d.Clock12 {
+		return "3:04 PM"
+	}
+
+	return "15:04"
+}
+
+// Returns the language to render the HTML page in.
+func (d *display) lang() string {
+	if len(d.Langs) > 0 {
+		return d.Langs[0]
+	}
+
+	if d.AcceptedLang != "" {
+		return d.AcceptedLang
+	}
+
+	return defaultLang
+}
+
+// Formats the display fields of the matches of a schedule in place. It must
+// only be given copies, such as those returned by scheduleAt.
+func (d *display) format(s map[string][]*match) map[string][]*match {
+	for key, matches := range s {
+		date, dated := dayDate(key)
+		for _, m := range matches {
+			if (d.Clock12 || d.Location != nil) && !m.Kickoff.IsZero() {
+				m.Time = m.Kickoff.In(d.zone()).Format(d.clockLayout())
+			}
+
+			if m.TimeKnown {
+				m.KickoffUTC = m.Kickoff.UTC().Format(time.RFC3339)
+			}
+
+			m.Highlight = isMarquee(m) || playsAny(m, d.Favorites)
+
+			if d.Tags {
+				m.Tags = tagsOf(m)
+			}
+
+			if dated && len(d.Langs) > 0 {
+				m.WeekdayLabels = make(map[string]string, len(d.Langs))
+				for _, lang := range d.Langs {
+					m.WeekdayLabels[lang] = catalogs[lang].Weekdays[date.Weekday()]
+				}
+			}
+		}
+	}
+
+	return s
+}
+
+// Reports whether a match is one of the configured marquee fixtures: either a
+// listed pair of teams, in any order, or involving a listed team. Teams are
+// compared like favorites, see teamKey.
+func isMarquee(m *match) bool {
+	home, away := teamKey(m.Home), teamKey(m.Away)
+	for _, entry := range marquee {
+		teams := strings.SplitN(entry, "|", 2)
+		if len(teams) == 1 {
+			if team := teamKey(teams[0]); home == team || away == team {
+				return true
+			}
+
+			continue
+		}
+
+		a, b := teamKey(teams[0]), teamKey(teams[1])
+		if home == a && away == b || home == b && away == a {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Moves the matches of favorite teams first within each day, then those on
+// preferred channels, otherwise keeping the order of the matches.
+func (d *display) pinPreferred(days []*day) []*day {
+	for _, day := range days {
+		d.pin(day.Matches)
+	}
+
+	return days
+}
+
+// Like pinPreferred within each slot of days bucketed by kickoff hour. The
+// slots are built from the matches in kickoff order, as pinning first would
+// split an hour in several slots.
+func (d *display) pinSlots(days []*slottedDay) []*slottedDay {
+	for _, day := range days {
+		for _, slot := range day.Slots {
+			d.pin(slot.Matches)
+		}
+	}
+
+	return days
+}
+
+// Sorts each day of a schedule the way the HTML page lists it: by kickoff,
+// with the matches of favorite teams and then those on preferred channels
+// first. The schedule must be a copy, such as one returned by filters.apply.
+func (d *display) order(s map[string][]*match) map[string][]*match {
+	for _, matches := range s {
+		sort.Stable(byKickoff(matches))
+		d.pin(matches)
+	}
+
+	return s
+}
+
+// Moves the matches of favorite teams first, then those on preferred
+// channels, keeping the order of the matches otherwise.
+func (d *display) pin(matches []*match) {
+	if len(d.PreferChannels) > 0 {
+		sort.Stable(byPreferredChannel{matches, d.PreferChannels})
+	}
+
+	if len(d.Favorites) > 0 {
+		sort.Stable(byFavorite{matches, d.Favorites})
+	}
+}
+
+// Returns all matches of a schedule as one list in the order the HTML page
+// lists them: like flatten, or with the days and their matches reversed if
+// desc, and with the matches of favorite teams and on preferred channels
+// first within each day.
+func (d *display) flatten(s map[string][]*match, desc bool) []*match {
+	days := orderedDays(s)
+	if desc {
+		reverseDays(days)
+	}
+
+	all := []*match{}
+	for _, day := range d.pinPreferred(days) {
+		all = append(all, day.Matches...)
+	}
+
+	return all
+}
+
+// Cuts the matches of each day down to MaxPerDay, counting the rest in
+// day.More.
+func (d *display) truncateDays(days []*day) []*day {
+	if d.MaxPerDay == 0 {
+		return days
+	}
+
+	for _, day := range days {
+		if len(day.Matches) > d.MaxPerDay {
+			day.More = len(day.Matches) - d.MaxPerDay
+			day.Matches = day.Matches[:d.MaxPerDay]
+		}
+	}
+
+	return days
+}
+
+// Sorts the matches of each day by league when collapsing leagues, keeping
+// their order within a league.
+func (d *display) collapseLeagues(days []*day) []*day {
+	if !d.CollapseLeagues {
+		return days
+	}
+
+	for _, day := range days {
+		sort.Stable(byLeague(day.Matches))
+	}
+
+	return days
+}
+
+type byLeague []*match
+
+func (s byLeague) Len() int           { return len(s) }
+func (s byLeague) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byLeague) Less(i, j int) bool { return s[i].League < s[j].League }
+
+// Reports whether the match at i starts a new league among matches, for the
+// league headings of ?collapseLeagues=true.
+func leagueChanged(matches []*match, i int) bool {
+	return i == 0 || matches[i].League != matches[i-1].League
+}
+
+// Sorts matches on any of channels before other matches.
+type byPreferredChannel struct {
+	matches  []*match
+	channels []string
+}
+
+func (s byPreferredChannel) Len() int      { return len(s.matches) }
+func (s byPreferredChannel) Swap(i, j int) { s.matches[i], s.matches[j] = s.matches[j], s.matches[i] }
+func (s byPreferredChannel) Less(i, j int) bool {
+	return airsOn(s.matches[i], s.channels) && !airsOn(s.matches[j], s.channels)
+}
+
+// Sorts matches of any of teams before other matches.
+type byFavorite struct {
+	matches []*match
+	teams   []string
+}
+
+func (s byFavorite) Len() int      { return len(s.matches) }
+func (s byFavorite) Swap(i, j int) { s.matches[i], s.matches[j] = s.matches[j], s.matches[i] }
+func (s byFavorite) Less(i, j int) bool {
+	return playsAny(s.matches[i], s.teams) && !playsAny(s.matches[j], s.teams)
+}
+
+// Returns the tags of the teams of a match, or nil if none of them is tagged.
+func tagsOf(m *match) map[string]string {
+	teams := []string{m.Home, m.Away}
+	if m.Home == "" && m.Away == "" {
+		teams = []string{m.Name}
+	}
+
+	var tags map[string]string
+	for _, team := range teams {
+		if tag, ok := teamTags[teamKey(team)]; ok {
+			if tags == nil {
+				tags = make(map[string]string, len(teams))
+			}
+
+			tags[team] = tag
+		}
+	}
+
+	return tags
+}
+
+// Sets the labels of days to the language of the page, or to their dates for
+// language neutral output.
+func (d *display) labelDays(days []*day) []*day {
+	for _, day := range days {
+		if d.Neutral {
+			day.Label = day.Date
+		} else if date, ok := dayDate(day.Date); ok {
+			day.Label = dayLabel(date, d.lang())
+		}
+	}
+
+	return days
+}
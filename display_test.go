@@ -0,0 +1,266 @@
+package alexmatchen
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Returns names in the order they first appear in an HTML page.
+func pageOrder(page string, names []string) []string {
+	ordered := append([]string(nil), names...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return strings.Index(page, ordered[i]) < strings.Index(page, ordered[j])
+	})
+
+	return ordered
+}
+
+func TestPreferChannelOrder(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+
+	defer useSchedule(testNow, map[string][]*match{"2014-05-17": {
+		testMatch("2014-05-17", "19:00", "Chelsea - Everton", "C More Sport"),
+		testMatch("2014-05-17", "19:00", "Fulham - Stoke", "TV4"),
+		testMatch("2014-05-17", "21:00", "Arsenal - Hull", "Viasat Fotboll"),
+		testMatch("2014-05-17", "21:00", "Liverpool - Newcastle", "TV4"),
+	}})()
+
+	want := []string{"Fulham - Stoke", "Liverpool - Newcastle", "Chelsea - Everton", "Arsenal - Hull"}
+	page := serve(t, inst, "/?preferChannel=TV4", nil).Body.String()
+	if got := pageOrder(page, want); !reflect.DeepEqual(got, want) {
+		t.Errorf("page lists %q, want %q", got, want)
+	}
+
+	var keyed map[string][]*match
+	if err := json.Unmarshal(serve(t, inst, "/schedule.json?preferChannel=TV4", nil).Body.Bytes(), &keyed); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := matchNames(keyed["2014-05-17"]); !reflect.DeepEqual(got, want) {
+		t.Errorf("/schedule.json lists %q, want %q", got, want)
+	}
+
+	var days []*day
+	if err := json.Unmarshal(serve(t, inst, "/schedule.json?preferChannel=TV4&format=days", nil).Body.Bytes(), &days); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(days) != 1 || !reflect.DeepEqual(matchNames(days[0].Matches), want) {
+		t.Errorf("/schedule.json?format=days lists %+v, want %q", days, want)
+	}
+
+	var list matchList
+	if err := json.Unmarshal(serve(t, inst, "/matches.json?preferChannel=TV4", nil).Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := matchNames(list.Matches); !reflect.DeepEqual(got, want) {
+		t.Errorf("/matches.json lists %q, want %q", got, want)
+	}
+
+	// Slots stay one per hour, pinned within
+	var slotted []*slottedDay
+	if err := json.Unmarshal(serve(t, inst, "/schedule.json?preferChannel=TV4&groupby=timeslot", nil).Body.Bytes(), &slotted); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(slotted) != 1 {
+		t.Fatalf("got %d slotted days, want 1", len(slotted))
+	}
+
+	slots, names := slotNames(slotted[0])
+	if want := []string{"19:00", "21:00"}; !reflect.DeepEqual(slots, want) {
+		t.Errorf("slots = %q, want %q", slots, want)
+	}
+
+	inSlots := [][]string{{"Fulham - Stoke", "Chelsea - Everton"}, {"Liverpool - Newcastle", "Arsenal - Hull"}}
+	if !reflect.DeepEqual(names, inSlots) {
+		t.Errorf("slots list %q, want %q", names, inSlots)
+	}
+}
+
+func TestIsMarquee(t *testing.T) {
+	prevMarquee, prevAliases := marquee, teamAliases
+	defer func() { marquee, teamAliases = prevMarquee, prevAliases }()
+	marquee = []string{"Arsenal|Tottenham", "Malmö FF", "AIK | Djurgården"}
+	teamAliases = foldAliases(map[string]string{"Spurs": "Tottenham", "Malmo": "Malmö FF"})
+
+	for _, tc := range []struct {
+		name string
+		want bool
+	}{
+		{"Arsenal - Tottenham", true},
+		{"Tottenham - Arsenal", true},
+		{"ARSENAL - Spurs", true},
+		{"Arsenal - Chelsea", false},
+		{"Chelsea - Tottenham", false},
+		{"Malmö FF - Hammarby", true},
+		{"Hammarby - Malmo FF", true},
+		{"Malmo - Hammarby", true},
+		{"Djurgarden - AIK", true},
+		{"AIK - Hammarby", false},
+		{"Hammarby - Ljungskile", false},
+	} {
+		m := testMatch("2014-05-17", "19:00", tc.name)
+		if got := isMarquee(m); got != tc.want {
+			t.Errorf("isMarquee(%s) = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPin(t *testing.T) {
+	day := func() []*match {
+		return []*match{
+			testMatch("2014-05-17", "16:00", "Arsenal - Hull", "C More Sport"),
+			testMatch("2014-05-17", "18:00", "Chelsea - Everton", "SVT1"),
+			testMatch("2014-05-17", "19:00", "Fulham - Stoke", "TV4", "C More Sport"),
+			testMatch("2014-05-17", "21:00", "Liverpool - Newcastle", "Viasat Fotboll"),
+		}
+	}
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"Arsenal - Hull", "Chelsea - Everton", "Fulham - Stoke", "Liverpool - Newcastle"}},
+		{"preferChannel=TV4", []string{"Fulham - Stoke", "Arsenal - Hull", "Chelsea - Everton", "Liverpool - Newcastle"}},
+		{"preferChannel=svt1,tv4", []string{"Chelsea - Everton", "Fulham - Stoke", "Arsenal - Hull", "Liverpool - Newcastle"}},
+		{"preferChannel=C+More+Sport", []string{"Arsenal - Hull", "Fulham - Stoke", "Chelsea - Everton", "Liverpool - Newcastle"}},
+		{"preferChannel=Kanal+5", []string{"Arsenal - Hull", "Chelsea - Everton", "Fulham - Stoke", "Liverpool - Newcastle"}},
+
+		// Favorites come before preferred channels
+		{"preferChannel=TV4&favorites=Liverpool", []string{"Liverpool - Newcastle", "Fulham - Stoke", "Arsenal - Hull", "Chelsea - Everton"}},
+	} {
+		d, err := parseDisplay(newRequest(t, "/?"+tc.query))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		matches := day()
+		if d.pin(matches); !reflect.DeepEqual(matchNames(matches), tc.want) {
+			t.Errorf("?%s pins %q, want %q", tc.query, matchNames(matches), tc.want)
+		}
+	}
+}
+
+var leagueHeading = regexp.MustCompile(`<li class="league-heading">([^<]*)</li>`)
+
+func TestCollapseLeagues(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+
+	cup := func(clock, name, round string) *match {
+		m := testMatch("2014-05-17", clock, name, "TV4")
+		m.League, m.Round = "FA Cup", round
+		return m
+	}
+
+	defer useSchedule(testNow, map[string][]*match{"2014-05-17": {
+		testMatch("2014-05-17", "13:45", "Chelsea - Everton", "C More Sport"),
+		cup("16:00", "Arsenal - Hull", "Final"),
+		testMatch("2014-05-17", "18:30", "Liverpool - Newcastle", "Viasat Fotboll"),
+		cup("21:00", "Wigan - Millwall", ""),
+	}})()
+
+	names := []string{"Chelsea - Everton", "Arsenal - Hull", "Liverpool - Newcastle", "Wigan - Millwall"}
+	for _, tc := range []struct {
+		query    string
+		headings []string
+		order    []string
+	}{
+		{"", nil, names},
+		{"collapseLeagues=false", nil, names},
+		{"collapseLeagues=true", []string{"FA Cup", "Premier League"}, []string{"Arsenal - Hull", "Wigan - Millwall", "Chelsea - Everton", "Liverpool - Newcastle"}},
+		{"groupby=league", []string{"FA Cup", "Premier League"}, []string{"Arsenal - Hull", "Wigan - Millwall", "Chelsea - Everton", "Liverpool - Newcastle"}},
+	} {
+		page := serve(t, inst, "/?leagues=all&"+tc.query, nil).Body.String()
+		var headings []string
+		for _, m := range leagueHeading.FindAllStringSubmatch(page, -1) {
+			headings = append(headings, m[1])
+		}
+
+		if !reflect.DeepEqual(headings, tc.headings) {
+			t.Errorf("/?%s has league headings %q, want %q", tc.query, headings, tc.headings)
+		}
+
+		if got := pageOrder(page, names); !reflect.DeepEqual(got, tc.order) {
+			t.Errorf("/?%s lists %q, want %q", tc.query, got, tc.order)
+		}
+
+		// Rows leave out the league under a heading, but keep the round
+		collapsed := tc.headings != nil
+		if perRow := strings.Contains(page, "(FA Cup, "); perRow == collapsed {
+			t.Errorf("/?%s shows the league on each row %v, want %v", tc.query, perRow, !collapsed)
+		}
+
+		if !strings.Contains(page, `<span class="round">Final</span>`) {
+			t.Errorf("/?%s doesn't show the round of the final", tc.query)
+		}
+	}
+}
+
+var moreNote = regexp.MustCompile(`<li class="more"><a href="([^"]*)">([^<]*)</a></li>`)
+
+func TestMaxPerDay(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, map[string][]*match{
+		"2014-05-17": {
+			testMatch("2014-05-17", "13:45", "Chelsea - Everton", "C More Sport"),
+			testMatch("2014-05-17", "16:00", "Arsenal - Hull", "TV4"),
+			testMatch("2014-05-17", "18:30", "Liverpool - Newcastle", "Viasat Fotboll"),
+			testMatch("2014-05-17", "21:00", "Fulham - Stoke", "TV4"),
+		},
+		"2014-05-18": {
+			testMatch("2014-05-18", "16:00", "Stoke - Fulham", "TV4"),
+		},
+	})()
+
+	all := []string{"Chelsea - Everton", "Arsenal - Hull", "Liverpool - Newcastle", "Fulham - Stoke", "Stoke - Fulham"}
+	for _, tc := range []struct {
+		query string
+		shown []string
+		notes [][2]string
+	}{
+		{"", all, nil},
+		{"maxPerDay=4", all, nil},
+		{"maxPerDay=2", []string{"Chelsea - Everton", "Arsenal - Hull", "Stoke - Fulham"}, [][2]string{{"/", "+2 till"}}},
+		{"maxPerDay=1&lang=en", []string{"Chelsea - Everton", "Stoke - Fulham"}, [][2]string{{"/?lang=en", "+3 more"}}},
+
+		// The cut comes after sorting
+		{"maxPerDay=2&preferChannel=TV4", []string{"Arsenal - Hull", "Fulham - Stoke", "Stoke - Fulham"}, [][2]string{{"/?preferChannel=TV4", "+2 till"}}},
+	} {
+		page := serve(t, inst, "/?"+tc.query, nil).Body.String()
+		var shown []string
+		for _, name := range all {
+			if strings.Contains(page, name) {
+				shown = append(shown, name)
+			}
+		}
+
+		if !reflect.DeepEqual(shown, tc.shown) {
+			t.Errorf("/?%s lists %q, want %q", tc.query, shown, tc.shown)
+		}
+
+		var notes [][2]string
+		for _, m := range moreNote.FindAllStringSubmatch(page, -1) {
+			notes = append(notes, [2]string{m[1], m[2]})
+		}
+
+		if !reflect.DeepEqual(notes, tc.notes) {
+			t.Errorf("/?%s has notes %q, want %q", tc.query, notes, tc.notes)
+		}
+	}
+
+	for _, query := range []string{"maxPerDay=0", "maxPerDay=-1", "maxPerDay=two"} {
+		if w := serve(t, inst, "/?"+query, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("GET /?%s = %d, want 400", query, w.Code)
+		}
+	}
+}
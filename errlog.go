@@ -0,0 +1,49 @@
+package alexmatchen
+
+import (
+	"net/http"
+	"time"
+)
+
+// Number of refresh errors kept for /admin/errors.json.
+const refreshErrorLimit = 20
+
+// A failed refresh as listed by /admin/errors.json.
+type refreshErrorEntry struct {
+	Time    time.Time
+	Code    string
+	Message string
+}
+
+// The last refreshErrorLimit refresh errors, oldest first. Guarded by mu.
+var refreshErrors []*refreshErrorEntry
+
+func init() {
+	handle("/admin/errors.json", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		mu.RLock()
+		entries := make([]*refreshErrorEntry, len(refreshErrors))
+		copy(entries, refreshErrors)
+		mu.RUnlock()
+
+		// Newest first, like a log tail
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+
+		writeJSON(w, r, entries)
+	}))
+}
+
+// Records a failed refresh, dropping the oldest entry when the buffer is
+// full. The caller must hold mu.
+func recordRefreshError(t time.Time, err error) {
+	code := errFetchFailed
+	if re, ok := err.(*refreshError); ok {
+		code = re.Code
+	}
+
+	refreshErrors = append(refreshErrors, &refreshErrorEntry{Time: t, Code: code, Message: err.Error()})
+	if len(refreshErrors) > refreshErrorLimit {
+		refreshErrors = refreshErrors[len(refreshErrors)-refreshErrorLimit:]
+	}
+}
@@ -0,0 +1,69 @@
+package alexmatchen
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Error codes of the JSON endpoints, for clients to branch on.
+const (
+	errFetchFailed = "fetch_failed"
+	errParseFailed = "parse_failed"
+	errBadRequest  = "bad_request"
+	errNotFound    = "not_found"
+	errForbidden   = "forbidden"
+	errInternal    = "internal_error"
+	errStale       = "stale_data"
+	errNotLoaded   = "not_loaded"
+)
+
+// A failed refresh, with the error code reported to JSON clients.
+type refreshError struct {
+	Code string
+	Err  error
+}
+
+func (e *refreshError) Error() string {
+	return e.Err.Error()
+}
+
+// Body of JSON error responses: {"error":{"code":"...","message":"..."}}.
+type errorBody struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Writes a structured JSON error response.
+func jsonError(w http.ResponseWriter, status int, code, message string) {
+	var body errorBody
+	body.Error.Code = code
+	body.Error.Message = message
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Writes the JSON error response for an error returned by
+// refreshScheduleIfNeeded.
+func jsonRefreshError(w http.ResponseWriter, err error) {
+	code := errFetchFailed
+	if re, ok := err.(*refreshError); ok {
+		code = re.Code
+	}
+
+	jsonError(w, refreshErrorStatus(err), code, err.Error())
+}
+
+// Returns the response status for an error returned by
+// refreshScheduleIfNeeded: 503 when the schedule is too old to serve or not
+// loaded yet, and 502 when upstream failed.
+func refreshErrorStatus(err error) int {
+	if re, ok := err.(*refreshError); ok && (re.Code == errStale || re.Code == errNotLoaded) {
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusBadGateway
+}
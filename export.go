@@ -0,0 +1,94 @@
+package alexmatchen
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Largest schedule /admin/import accepts.
+const maxImportBytes = 8 << 20
+
+func init() {
+	// Dumps the cached schedule as stored, without the request time fields,
+	// for loading into another instance with /admin/import
+	handle("/admin/export", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		mu.RLock()
+		js, err := json.Marshal(schedule)
+		mu.RUnlock()
+		if err != nil {
+			jsonError(w, http.StatusInternalServerError, errInternal, err.Error())
+			return
+		}
+
+		writeJSONBytes(w, r, http.StatusOK, js)
+	}))
+
+	// Replaces the cached schedule with a posted export, so a user's exact
+	// data can be rendered locally. The import counts as a successful
+	// refresh, so it's served until the next refresh is due.
+	handle("/admin/import", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			jsonError(w, http.StatusMethodNotAllowed, errBadRequest, "import must be posted")
+			return
+		}
+
+		var imported map[string][]*match
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportBytes)).Decode(&imported); err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, fmt.Sprintf("malformed schedule: %v", err))
+			return
+		}
+
+		if err := validateImport(imported); err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		refreshMu.Lock()
+		mu.Lock()
+		previousSchedule = schedule
+		schedule = imported
+		lastAttempt = now()
+		lastRefresh = lastAttempt
+		lastRefreshErr = nil
+		failedRefreshes = 0
+		invalidateSnapshots()
+		mu.Unlock()
+		refreshMu.Unlock()
+
+		newContext(r).Infof("Imported %d matches", countMatches(imported))
+		writeJSON(w, r, map[string]int{"Days": len(imported), "Matches": countMatches(imported)})
+	}))
+}
+
+// Checks that an imported schedule is keyed by dates and holds named
+// matches, and fills in the fields derived from the kickoff.
+func validateImport(s map[string][]*match) error {
+	if s == nil {
+		return fmt.Errorf("schedule must be an object keyed by date")
+	}
+
+	for date, matches := range s {
+		if _, ok := dayDate(date); !ok {
+			return fmt.Errorf("day %q is not a YYYY-MM-DD date", date)
+		}
+
+		if matches == nil {
+			s[date] = []*match{}
+		}
+
+		for i, m := range matches {
+			if m == nil || m.Name == "" {
+				return fmt.Errorf("match %d of %s has no name", i, date)
+			}
+
+			m.TimeKnown = !m.Kickoff.IsZero()
+			if m.Channels == nil {
+				m.Channels = []string{}
+			}
+		}
+	}
+
+	return nil
+}
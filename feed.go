@@ -0,0 +1,151 @@
+package alexmatchen
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Prefix of the tag URIs identifying the feed and its entries, per RFC 4151.
+// Entries are identified by match ID, so feed readers recognize a match
+// across refreshes rather than list it again.
+const feedTagPrefix = "tag:" + icalUIDDomain + ",2014:"
+
+type (
+	// An Atom feed of upcoming matches, see /feed.xml.
+	atomFeed struct {
+		XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+		ID      string       `xml:"id"`
+		Title   string       `xml:"title"`
+		Updated string       `xml:"updated"`
+		Author  atomAuthor   `xml:"author"`
+		Links   []atomLink   `xml:"link"`
+		Entries []*atomEntry `xml:"entry"`
+	}
+
+	atomAuthor struct {
+		Name string `xml:"name"`
+	}
+
+	atomLink struct {
+		Rel  string `xml:"rel,attr,omitempty"`
+		Href string `xml:"href,attr"`
+	}
+
+	atomEntry struct {
+		ID         string         `xml:"id"`
+		Title      string         `xml:"title"`
+		Updated    string         `xml:"updated"`
+		Summary    string         `xml:"summary"`
+		Categories []atomCategory `xml:"category"`
+	}
+
+	atomCategory struct {
+		Term string `xml:"term,attr"`
+	}
+)
+
+func init() {
+	// Upcoming matches of the leagues chosen, like the HTML page, one entry
+	// each
+	handle("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseFilters(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		d, err := parseDisplay(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := refreshScheduleIfNeeded(w, r); err != nil {
+			http.Error(w, err.Error(), refreshErrorStatus(err))
+			return
+		}
+
+		upcoming := []*match{}
+		for _, m := range d.flatten(f.apply(d.format(scheduleAt(cachedSchedule(), now()))), false) {
+			if m.Status != statusFinished {
+				upcoming = append(upcoming, m)
+			}
+		}
+
+		last, _ := refreshStatus()
+		feed := atomFeedOf(upcoming, last, "https://"+r.Host, d.lang())
+		var b bytes.Buffer
+		b.WriteString(xml.Header)
+		if err := xml.NewEncoder(&b).Encode(feed); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		w.Write(b.Bytes())
+	})
+}
+
+// Builds the feed of matches, updated at the last refresh. The link to the
+// page is relative to site, e.g. "https://alex-matchen.appspot.com".
+func atomFeedOf(matches []*match, updated time.Time, site, lang string) *atomFeed {
+	stamp := updated.UTC().Format(time.RFC3339)
+	title := translate(lang, "title")
+	feed := &atomFeed{
+		ID:      feedTagPrefix + "feed",
+		Title:   title,
+		Updated: stamp,
+		Author:  atomAuthor{Name: title},
+		Links:   []atomLink{{Href: site + "/"}, {Rel: "self", Href: site + "/feed.xml"}},
+		Entries: make([]*atomEntry, len(matches)),
+	}
+
+	for i, m := range matches {
+		entry := &atomEntry{
+			ID:      feedTagPrefix + "match:" + m.ID,
+			Title:   m.Name,
+			Updated: stamp,
+			Summary: feedSummary(m, lang),
+		}
+
+		for _, category := range []string{m.League, m.Sport} {
+			if category != "" {
+				entry.Categories = append(entry.Categories, atomCategory{Term: category})
+			}
+		}
+
+		feed.Entries[i] = entry
+	}
+
+	return feed
+}
+
+// Describes a match in a line: its day and kickoff, league and round, and
+// channels, e.g. "Lördag 17 maj 16:00 · FA Cup · TV4, C More Sport".
+func feedSummary(m *match, lang string) string {
+	when := translate(lang, "time.tbd")
+	if m.TimeKnown {
+		when = m.Time
+	}
+
+	if !m.Kickoff.IsZero() {
+		when = dayLabel(midnight(m.Kickoff), lang) + " " + when
+	}
+
+	league := m.League
+	if m.Round != "" {
+		league += ", " + m.Round
+	}
+
+	parts := []string{when}
+	for _, part := range []string{league, m.Channel} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	return strings.Join(parts, " · ")
+}
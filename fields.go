@@ -0,0 +1,100 @@
+package alexmatchen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Names of the match fields by lower case name, for ?fields=.
+var matchFields = fieldNames(reflect.TypeOf(match{}))
+
+func fieldNames(t reflect.Type) map[string]string {
+	names := make(map[string]string, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.PkgPath == "" {
+			names[strings.ToLower(f.Name)] = f.Name
+		}
+	}
+
+	return names
+}
+
+// Parses the fields parameter, a comma separated list of the match fields to
+// return, e.g. ?fields=name,time,channel. Names ignore case. Without the
+// parameter all fields are returned and the set is nil.
+func parseFields(r *http.Request) (map[string]bool, error) {
+	names := splitList(r.FormValue("fields"))
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	fields := make(map[string]bool, len(names))
+	for _, name := range names {
+		field, ok := matchFields[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+
+		fields[field] = true
+	}
+
+	return fields, nil
+}
+
+// Strips the matches in a marshaled response down to the given fields,
+// wherever they are nested. Matches are told apart from other objects by
+// their ID and Kickoff keys.
+func projectJSON(js []byte, fields map[string]bool) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(project(v, fields))
+}
+
+func project(v interface{}, fields map[string]bool) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		for i := range v {
+			v[i] = project(v[i], fields)
+		}
+
+	case map[string]interface{}:
+		_, id := v["ID"]
+		_, kickoff := v["Kickoff"]
+		if id && kickoff {
+			for key := range v {
+				if !fields[key] {
+					delete(v, key)
+				}
+			}
+
+			return v
+		}
+
+		for key := range v {
+			v[key] = project(v[key], fields)
+		}
+	}
+
+	return v
+}
+
+// Returns the given fields of a match by name.
+func projectMatch(m *match, fields map[string]bool) map[string]interface{} {
+	v := reflect.ValueOf(m).Elem()
+	out := make(map[string]interface{}, len(fields))
+	for field := range fields {
+		out[field] = v.FieldByName(field).Interface()
+	}
+
+	return out
+}
@@ -0,0 +1,126 @@
+package alexmatchen
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Returns the keys of a decoded JSON object, sorted.
+func jsonKeys(v interface{}) []string {
+	keys := []string{}
+	for key := range v.(map[string]interface{}) {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func TestFields(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, map[string][]*match{"2014-05-17": {
+		testMatch("2014-05-17", "16:00", "Arsenal - Hull", "TV4"),
+		testMatch("2014-05-17", "19:00", "Chelsea - Everton", "C More Sport"),
+	}})()
+
+	envelope := []string{"AppliedFilters", "Matches", "NextRefresh", "Source", "Truncated"}
+	for _, tc := range []struct {
+		path string
+
+		// Finds the matches in the decoded body, checking the rest of it
+		matches func(body interface{}) []interface{}
+		fields  []string
+	}{
+		{
+			"/matches.json?fields=name,time",
+			func(body interface{}) []interface{} {
+				if got := jsonKeys(body); !reflect.DeepEqual(got, envelope) {
+					t.Errorf("/matches.json?fields=name,time has %q, want %q", got, envelope)
+				}
+
+				return body.(map[string]interface{})["Matches"].([]interface{})
+			},
+			[]string{"Name", "Time"},
+		},
+		{
+			"/matches.json?fields=NAME,Kickoff,channels",
+			func(body interface{}) []interface{} {
+				return body.(map[string]interface{})["Matches"].([]interface{})
+			},
+			[]string{"Channels", "Kickoff", "Name"},
+		},
+		{
+			"/schedule.json?fields=id",
+			func(body interface{}) []interface{} {
+				return body.(map[string]interface{})["2014-05-17"].([]interface{})
+			},
+			[]string{"ID"},
+		},
+		{
+			"/schedule.json?format=days&fields=name",
+			func(body interface{}) []interface{} {
+				day := body.([]interface{})[0].(map[string]interface{})
+				if day["Date"] != "2014-05-17" || day["Label"] == nil {
+					t.Errorf("/schedule.json?format=days&fields=name lost the day's fields: %v", day)
+				}
+
+				return day["Matches"].([]interface{})
+			},
+			[]string{"Name"},
+		},
+	} {
+		w := serve(t, inst, tc.path, nil)
+		var body interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); w.Code != http.StatusOK || err != nil {
+			t.Errorf("GET %s = %d %v", tc.path, w.Code, err)
+			continue
+		}
+
+		matches := tc.matches(body)
+		if len(matches) != 2 {
+			t.Errorf("%s lists %d matches, want 2", tc.path, len(matches))
+		}
+
+		for _, m := range matches {
+			if got := jsonKeys(m); !reflect.DeepEqual(got, tc.fields) {
+				t.Errorf("%s has match fields %q, want %q", tc.path, got, tc.fields)
+			}
+		}
+	}
+
+	for _, path := range []string{"/matches.json?fields=name,bogus", "/schedule.json?fields=bogus", "/schedule.json?fields=name,provenance2"} {
+		if w := serve(t, inst, path, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want 400", path, w.Code)
+		}
+	}
+
+	// Each projection is its own representation
+	etags := map[string]string{}
+	for _, path := range []string{"/matches.json", "/matches.json?fields=name", "/matches.json?fields=time", "/matches.json?fields=name,time"} {
+		etag := serve(t, inst, path, nil).Header().Get("ETag")
+		if etag == "" {
+			t.Errorf("GET %s has no ETag", path)
+		}
+
+		for other, seen := range etags {
+			if etag == seen {
+				t.Errorf("GET %s has the ETag of %s, %s", path, other, etag)
+			}
+		}
+
+		etags[path] = etag
+	}
+
+	header := http.Header{"If-None-Match": {etags["/matches.json?fields=name"]}}
+	if w := serve(t, inst, "/matches.json?fields=time", header); w.Code != http.StatusOK {
+		t.Errorf("GET /matches.json?fields=time with the ETag of fields=name = %d, want 200", w.Code)
+	}
+
+	if w := serve(t, inst, "/matches.json?fields=name", header); w.Code != http.StatusNotModified {
+		t.Errorf("GET /matches.json?fields=name with its ETag = %d, want 304", w.Code)
+	}
+}
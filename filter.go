@@ -0,0 +1,461 @@
+package alexmatchen
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Value of the leagues and sports parameters that shows every league or
+// sport.
+const allLeagues = "all"
+
+// Request time filters shared by all endpoints. Empty fields don't filter.
+type filters struct {
+	Leagues  []string
+	Channels []string
+
+	// Teams of which matches are shown, see ?teams=Arsenal,Liverpool. A team
+	// must be a whole side of a match, so "Manchester" matches neither
+	// Manchester club; short names can be added as TEAM_ALIASES.
+	Teams []string
+
+	// Sports by display name, see ?sports=Fotboll,Ishockey. Matches of an
+	// unknown sport, such as secondary ones without one, always pass.
+	Sports []string
+
+	// Teams whose matches pass the league filter whatever their league, the
+	// INTEREST_TEAMS setting while the default leagues are shown.
+	InterestTeams []string
+
+	// Inclusive range of days, see ?from=2014-05-18&to=2014-05-20. Zero
+	// times leave that end open.
+	From, To time.Time
+
+	// Number of days to show starting today, see ?days=3. Zero shows all.
+	Days int
+
+	// Keep matches whose kickoff time isn't known yet, see ?includeTBD=true.
+	IncludeTBD bool
+
+	// Only show matches on the channels of a region in CHANNEL_REGIONS, see
+	// ?region=SE-basic.
+	Region string
+
+	// Teams whose matches are removed, see ?hide=Arsenal&hide=Chelsea. Hides
+	// win over every other filter, so a team both in Teams and Hide is hidden.
+	Hide []string
+}
+
+// The filters in effect for a request as echoed back to clients, after
+// defaults, normalization and clamping. Unused filters are left out.
+type appliedFilters struct {
+	Leagues    []string `json:",omitempty"`
+	Channels   []string `json:",omitempty"`
+	Teams      []string `json:",omitempty"`
+	Sports     []string `json:",omitempty"`
+	From       string   `json:",omitempty"`
+	To         string   `json:",omitempty"`
+	Days       int      `json:",omitempty"`
+	IncludeTBD bool     `json:",omitempty"`
+	Region     string   `json:",omitempty"`
+	Hide       []string `json:",omitempty"`
+	Tz         string   `json:",omitempty"`
+	Order      string   `json:",omitempty"`
+}
+
+// Returns the filters as echoed back to clients, with the order of the
+// results when descending and the time zone kickoffs are shown in, if a
+// request chose one.
+func (f *filters) applied(desc bool, zone *time.Location) *appliedFilters {
+	a := &appliedFilters{
+		Leagues:    f.Leagues,
+		Channels:   f.Channels,
+		Teams:      f.Teams,
+		Sports:     f.Sports,
+		Days:       f.Days,
+		IncludeTBD: f.IncludeTBD,
+		Region:     f.Region,
+		Hide:       f.Hide,
+	}
+
+	if !f.From.IsZero() {
+		a.From = f.From.Format("2006-01-02")
+	}
+
+	if !f.To.IsZero() {
+		a.To = f.To.Format("2006-01-02")
+	}
+
+	if zone != nil {
+		a.Tz = zone.String()
+	}
+
+	if desc {
+		a.Order = "desc"
+	}
+
+	return a
+}
+
+func init() {
+	// Echoes the filters of a request as parsed and normalized, without
+	// reading the schedule, for debugging how clients build queries
+	handle("/filters/parse", func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseFilters(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		desc, err := parseOrder(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		d, err := parseDisplay(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		writeJSON(w, r, f.applied(desc, d.Location))
+	})
+}
+
+// Parses the filter parameters of a request. Each parameter takes a comma
+// separated list, e.g. ?leagues=Premier League,Allsvenskan. Without a leagues
+// parameter the leagues saved on /preferences are shown, or else the default
+// leagues along with the matches of the teams of interest, and ?leagues=all
+// shows every league. Leagues can be scoped to a sport, see inLeague. Sports
+// default to those saved or DEFAULT_SPORTS likewise, and ?sports=all shows
+// every sport. The hide parameter can also be repeated.
+func parseFilters(r *http.Request) (*filters, error) {
+	chosenLeagues := r.FormValue("leagues")
+	if chosenLeagues == "" {
+		chosenLeagues = preference(r, "leagues")
+	}
+
+	chosenSports := r.FormValue("sports")
+	if chosenSports == "" {
+		chosenSports = preference(r, "sports")
+	}
+
+	f := &filters{
+		Leagues:  splitList(chosenLeagues),
+		Channels: splitList(r.FormValue("channels")),
+		Teams:    splitList(r.FormValue("team")),
+		Sports:   splitList(chosenSports),
+	}
+
+	// ?teams= reads better for several teams, and adds to ?team=
+	f.Teams = append(f.Teams, splitList(r.FormValue("teams"))...)
+
+	// FormValue above has parsed the form
+	for _, v := range r.Form["hide"] {
+		f.Hide = append(f.Hide, splitList(v)...)
+	}
+
+	switch {
+	case len(f.Leagues) == 0:
+		f.Leagues = defaultLeagues()
+		f.InterestTeams = interestTeams
+	case len(f.Leagues) == 1 && strings.EqualFold(f.Leagues[0], allLeagues):
+		f.Leagues = nil
+	}
+
+	switch {
+	case len(f.Sports) == 0:
+		f.Sports = defaultSports
+	case len(f.Sports) == 1 && strings.EqualFold(f.Sports[0], allLeagues):
+		f.Sports = nil
+	}
+
+	if f.Region = r.FormValue("region"); f.Region != "" {
+		if _, ok := channelRegions[f.Region]; !ok {
+			return nil, fmt.Errorf("unknown region %q", f.Region)
+		}
+	}
+
+	var err error
+	if f.IncludeTBD, err = parseBoolParam(r, "includeTBD"); err != nil {
+		return nil, err
+	}
+
+	if f.From, err = parseDateParam(r, "from"); err != nil {
+		return nil, err
+	}
+
+	if f.To, err = parseDateParam(r, "to"); err != nil {
+		return nil, err
+	}
+
+	if !f.From.IsZero() && !f.To.IsZero() && f.To.Before(f.From) {
+		return nil, fmt.Errorf("from must not be after to")
+	}
+
+	if v := r.FormValue("days"); v != "" {
+		if f.Days, err = strconv.Atoi(v); err != nil || f.Days < 1 {
+			return nil, fmt.Errorf("days must be a positive integer, got %q", v)
+		}
+
+		// There's never more than daysToShow days to show
+		if days := shownDays(); f.Days > days {
+			f.Days = days
+		}
+	}
+
+	return f, nil
+}
+
+// Parses an optional YYYY-MM-DD date parameter.
+func parseDateParam(r *http.Request, name string) (time.Time, error) {
+	v := r.FormValue(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	d, err := time.ParseInLocation("2006-01-02", v, stockholm)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be a YYYY-MM-DD date, got %q", name, v)
+	}
+
+	return d, nil
+}
+
+// Splits a comma separated parameter, normalizing the whitespace of each item
+// like scraped text and dropping empty items.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = normalizeText(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// Reports whether a match passes all filters. Leagues match on a case
+// insensitive substring, or pass for matches of InterestTeams, while channels
+// must match exactly apart from case, and teams apart from case and accents,
+// see sameTeamAny. A channel matches any of the channels a match airs on, and
+// a team either side of the match, or the whole name if it couldn't be split
+// into teams. Matches without a known kickoff time only pass with IncludeTBD,
+// and matches of hidden teams never pass.
+func (f *filters) match(m *match) bool {
+	if !f.IncludeTBD && !m.TimeKnown {
+		return false
+	}
+
+	if len(f.Leagues) > 0 && !inLeague(m, f.Leagues) && !playsAny(m, f.InterestTeams) {
+		return false
+	}
+
+	if len(f.Sports) > 0 && m.Sport != "" && !equalsAny(m.Sport, f.Sports) {
+		return false
+	}
+
+	if len(f.Channels) > 0 && !f.matchChannel(m) {
+		return false
+	}
+
+	if f.Region != "" && !airsOn(m, channelRegions[f.Region]) {
+		return false
+	}
+
+	if len(f.Teams) > 0 && !f.matchTeam(m) {
+		return false
+	}
+
+	// Hides go last and override everything above
+	if len(f.Hide) > 0 && playsAny(m, f.Hide) {
+		return false
+	}
+
+	return true
+}
+
+// Reports whether a match is in any of leagues, on a case insensitive
+// substring. A league can be scoped to a sport as "Sport:League", e.g.
+// "Ishockey:Allsvenskan", to leave out leagues of the same name in other
+// sports.
+func inLeague(m *match, leagues []string) bool {
+	for _, league := range leagues {
+		sport, name, scoped := scopedLeague(league)
+		if scoped && !strings.EqualFold(m.Sport, sport) {
+			continue
+		}
+
+		if strings.Contains(strings.ToLower(m.League), strings.ToLower(name)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Splits a league scoped to a sport, reporting whether it is. Only the
+// display names in SPORTS scope leagues, so other names may contain colons.
+func scopedLeague(league string) (sport, name string, scoped bool) {
+	parts := strings.SplitN(league, ":", 2)
+	if len(parts) == 2 {
+		for _, s := range sports {
+			if strings.EqualFold(s, strings.TrimSpace(parts[0])) {
+				return s, strings.TrimSpace(parts[1]), true
+			}
+		}
+	}
+
+	return "", league, false
+}
+
+func (f *filters) matchChannel(m *match) bool {
+	return airsOn(m, f.Channels)
+}
+
+// Reports whether a match airs on any of the channels, ignoring case.
+func airsOn(m *match, channels []string) bool {
+	for _, channel := range m.Channels {
+		if equalsAny(channel, channels) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *filters) matchTeam(m *match) bool {
+	return playsAny(m, f.Teams)
+}
+
+// Reports whether either side of a match, or its whole name if it couldn't
+// be split into teams, is any of teams, see sameTeamAny.
+func playsAny(m *match, teams []string) bool {
+	if m.Home == "" && m.Away == "" {
+		return sameTeamAny(m.Name, teams)
+	}
+
+	return sameTeamAny(m.Home, teams) || sameTeamAny(m.Away, teams)
+}
+
+// Reports whether a team is any of teams, ignoring case and accents and
+// resolving TEAM_ALIASES, so "Malmo" and "Malmö FF" can be the same team.
+func sameTeamAny(team string, teams []string) bool {
+	key := teamKey(team)
+	for _, t := range teams {
+		if teamKey(t) == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Folds the accented letters common in European team names.
+var accentFolder = strings.NewReplacer(
+	"å", "a", "ä", "a", "á", "a", "à", "a", "â", "a", "ã", "a",
+	"æ", "ae", "ç", "c", "é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i", "ñ", "n",
+	"ö", "o", "ó", "o", "ò", "o", "ô", "o", "õ", "o", "ø", "o",
+	"ü", "u", "ú", "u", "ù", "u", "û", "u", "ß", "ss",
+)
+
+// Returns the form team names are compared in: lower case and without
+// accents, with aliases replaced by the canonical name.
+func teamKey(name string) string {
+	key := foldTeam(name)
+	if canonical, ok := teamAliases[key]; ok {
+		return canonical
+	}
+
+	return key
+}
+
+func foldTeam(name string) string {
+	return accentFolder.Replace(strings.ToLower(normalizeText(name)))
+}
+
+// Reports whether a day of the schedule is within the date range and the
+// number of days asked for.
+func (f *filters) matchDay(key string) bool {
+	if f.From.IsZero() && f.To.IsZero() && f.Days == 0 {
+		return true
+	}
+
+	d, ok := dayDate(key)
+	if !ok || d.Before(f.From) || !f.To.IsZero() && d.After(f.To) {
+		return false
+	}
+
+	return f.Days == 0 || d.Before(midnight(now()).AddDate(0, 0, f.Days))
+}
+
+// Returns a copy of the schedule with only the days and matches passing the
+// filters, with clashes between them flagged. Days without any remaining
+// matches are kept, empty. It must only be given copies, such as those
+// returned by scheduleAt.
+func (f *filters) apply(s map[string][]*match) map[string][]*match {
+	out := make(map[string][]*match, len(s))
+	for date, matches := range s {
+		if !f.matchDay(date) {
+			continue
+		}
+
+		kept := []*match{}
+		for _, m := range matches {
+			if f.match(m) {
+				kept = append(kept, m)
+			}
+		}
+		out[date] = kept
+	}
+
+	markClashes(out)
+	return out
+}
+
+// Flags the matches sharing their kickoff with another match in the schedule.
+func markClashes(s map[string][]*match) {
+	atKickoff := make(map[int64][]*match)
+	for _, matches := range s {
+		for _, m := range matches {
+			m.Clash = false
+			if m.TimeKnown {
+				atKickoff[m.Kickoff.Unix()] = append(atKickoff[m.Kickoff.Unix()], m)
+			}
+		}
+	}
+
+	for _, matches := range atKickoff {
+		if len(matches) > 1 {
+			for _, m := range matches {
+				m.Clash = true
+			}
+		}
+	}
+}
+
+func containsAny(s string, substrs []string) bool {
+	s = strings.ToLower(s)
+	for _, sub := range substrs {
+		if strings.Contains(s, strings.ToLower(sub)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func equalsAny(s string, values []string) bool {
+	for _, v := range values {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+
+	return false
+}
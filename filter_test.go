@@ -0,0 +1,388 @@
+package alexmatchen
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Returns the names of the matches the filters of query pass, by day.
+func filtered(t *testing.T, query string, s map[string][]*match) map[string][]string {
+	f, err := parseFilters(newRequest(t, "/?"+query))
+	if err != nil {
+		t.Fatalf("parsing filters of %q: %v", query, err)
+	}
+
+	names := map[string][]string{}
+	for date, matches := range f.apply(s) {
+		names[date] = matchNames(matches)
+		sort.Strings(names[date])
+	}
+
+	return names
+}
+
+func TestAllLeagues(t *testing.T) {
+	division := testMatch("2014-05-17", "15:00", "Sirius - Degerfors", "TV4")
+	division.League = "Division 1"
+	cup := testMatch("2014-05-17", "16:00", "Arsenal - Hull City", "C More Sport")
+	cup.League = "FA Cup"
+	s := map[string][]*match{"2014-05-17": {
+		division,
+		cup,
+		testMatch("2014-05-17", "19:00", "Chelsea - Everton", "TV4"),
+	}}
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"Chelsea - Everton"}},
+		{"leagues=all", []string{"Arsenal - Hull City", "Chelsea - Everton", "Sirius - Degerfors"}},
+		{"leagues=ALL", []string{"Arsenal - Hull City", "Chelsea - Everton", "Sirius - Degerfors"}},
+		{"leagues=FA+Cup", []string{"Arsenal - Hull City"}},
+		{"leagues=all&channels=TV4", []string{"Chelsea - Everton", "Sirius - Degerfors"}},
+		{"leagues=all&team=Sirius", []string{"Sirius - Degerfors"}},
+		{"channels=TV4", []string{"Chelsea - Everton"}},
+	} {
+		if got := filtered(t, tc.query, s)["2014-05-17"]; !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("?%s shows %q, want %q", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestDateRange(t *testing.T) {
+	s := map[string][]*match{
+		"2014-05-17": {testMatch("2014-05-17", "19:00", "Chelsea - Everton")},
+		"2014-05-18": {testMatch("2014-05-18", "16:00", "Liverpool - Newcastle")},
+		"2014-05-19": {testMatch("2014-05-19", "20:00", "Stoke - Fulham")},
+		"2014-05-20": {testMatch("2014-05-20", "20:00", "Swansea - Sunderland")},
+	}
+	defer useSchedule(testNow, s)()
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"2014-05-17", "2014-05-18", "2014-05-19", "2014-05-20"}},
+		{"from=2014-05-18&to=2014-05-19", []string{"2014-05-18", "2014-05-19"}},
+		{"from=2014-05-19&to=2014-05-19", []string{"2014-05-19"}},
+		{"from=2014-05-19", []string{"2014-05-19", "2014-05-20"}},
+		{"to=2014-05-18", []string{"2014-05-17", "2014-05-18"}},
+		{"from=2014-05-18&days=2", []string{"2014-05-18"}},
+		{"from=2014-06-01&to=2014-06-07", []string{}},
+	} {
+		got := []string{}
+		for date := range filtered(t, tc.query, s) {
+			got = append(got, date)
+		}
+
+		if sort.Strings(got); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("?%s shows days %q, want %q", tc.query, got, tc.want)
+		}
+	}
+
+	for _, query := range []string{"from=2014-05-19&to=2014-05-18", "from=18+maj", "to=2014-5-18", "from=2014-02-30"} {
+		if _, err := parseFilters(newRequest(t, "/?"+query)); err == nil {
+			t.Errorf("?%s parsed, want an error", query)
+		}
+	}
+
+	inst, done := newInstance(t)
+	defer done()
+	if w := serve(t, inst, "/schedule.json?from=2014-05-19&to=2014-05-18", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("/schedule.json with from after to = %d, want 400", w.Code)
+	}
+
+	if w := serve(t, inst, "/schedule.json?from=2014-06-01&to=2014-06-07", nil); w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "{}" {
+		t.Errorf("/schedule.json of a range without matches = %d %s, want 200 {}", w.Code, w.Body)
+	}
+}
+
+// Filter values are compared normalized like scraped text, whatever their
+// spacing.
+func TestNormalizedFilters(t *testing.T) {
+	cup := testMatch("2014-05-17", "16:00", "Arsenal - Hull City", "C More Sport")
+	cup.League = "FA Cup"
+	s := map[string][]*match{"2014-05-17": {
+		cup,
+		testMatch("2014-05-17", "19:00", "Chelsea - Everton", "TV4"),
+	}}
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"leagues=Premier++League", []string{"Chelsea - Everton"}},
+		{"leagues=%09premier%0A+league+", []string{"Chelsea - Everton"}},
+		{"leagues=FA++Cup,,+", []string{"Arsenal - Hull City"}},
+		{"leagues=all&channels=C++More+Sport", []string{"Arsenal - Hull City"}},
+		{"leagues=all&team=Hull++City+", []string{"Arsenal - Hull City"}},
+		{"leagues=all&team=Hull+C+ity", nil},
+	} {
+		if got := filtered(t, tc.query, s)["2014-05-17"]; !reflect.DeepEqual(got, tc.want) && len(got)+len(tc.want) > 0 {
+			t.Errorf("?%s shows %q, want %q", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestClashes(t *testing.T) {
+	cup := testMatch("2014-05-17", "19:00", "Arsenal - Hull", "TV4")
+	cup.League = "FA Cup"
+	s := map[string][]*match{
+		"2014-05-17": {
+			testMatch("2014-05-17", "16:00", "Fulham - Stoke", "TV4"),
+			testMatch("2014-05-17", "19:00", "Chelsea - Everton", "C More Sport"),
+			cup,
+			testMatch("2014-05-17", "TBD", "Swansea - Sunderland"),
+			testMatch("2014-05-17", "TBD", "Cardiff - Norwich"),
+		},
+		"2014-05-18": {testMatch("2014-05-18", "19:00", "Liverpool - Newcastle", "TV4")},
+	}
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"leagues=all&includeTBD=true", []string{"Arsenal - Hull", "Chelsea - Everton"}},
+
+		// Clashes are between the matches shown
+		{"leagues=Premier+League", nil},
+		{"leagues=all&channels=TV4", nil},
+	} {
+		f, err := parseFilters(newRequest(t, "/?"+tc.query))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var clashing []string
+		for _, m := range flatten(f.apply(scheduleAt(s, testNow))) {
+			if m.Clash {
+				clashing = append(clashing, m.Name)
+			}
+		}
+
+		if sort.Strings(clashing); !reflect.DeepEqual(clashing, tc.want) {
+			t.Errorf("?%s flags %q as clashing, want %q", tc.query, clashing, tc.want)
+		}
+	}
+
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, s)()
+	if page := serve(t, inst, "/?leagues=all", nil).Body.String(); strings.Count(page, `class="time clash"`) != 2 {
+		t.Errorf("/?leagues=all doesn't mark the 2 clashing matches:\n%s", page)
+	}
+}
+
+func TestRegions(t *testing.T) {
+	const name = "TEST_CHANNEL_REGIONS"
+	defer os.Unsetenv(name)
+	os.Setenv(name, "SE-basic=SVT1| svt2 |TV4,SE-sport=C More Sport|Viasat Fotboll")
+
+	prevRegions := channelRegions
+	defer func() { channelRegions = prevRegions }()
+	channelRegions = settingLists(name, "")
+
+	s := map[string][]*match{"2014-05-17": {
+		testMatch("2014-05-17", "15:00", "Fulham - Stoke", "SVT2"),
+		testMatch("2014-05-17", "16:00", "Arsenal - Hull", "C More Sport"),
+		testMatch("2014-05-17", "19:00", "Chelsea - Everton", "C More Sport", "TV4"),
+		testMatch("2014-05-17", "21:00", "Liverpool - Newcastle", "Kanal 5"),
+	}}
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"Arsenal - Hull", "Chelsea - Everton", "Fulham - Stoke", "Liverpool - Newcastle"}},
+		{"region=SE-basic", []string{"Chelsea - Everton", "Fulham - Stoke"}},
+		{"region=SE-sport", []string{"Arsenal - Hull", "Chelsea - Everton"}},
+		{"region=SE-sport&channels=TV4", []string{"Chelsea - Everton"}},
+		{"region=SE-basic&team=Arsenal", nil},
+	} {
+		if got := filtered(t, tc.query, s)["2014-05-17"]; !reflect.DeepEqual(got, tc.want) && len(got)+len(tc.want) > 0 {
+			t.Errorf("?%s shows %q, want %q", tc.query, got, tc.want)
+		}
+	}
+
+	for _, query := range []string{"region=SE-premium", "region=se-basic"} {
+		if _, err := parseFilters(newRequest(t, "/?"+query)); err == nil {
+			t.Errorf("?%s parsed, want an unknown region error", query)
+		}
+	}
+}
+
+func TestHide(t *testing.T) {
+	prevAliases := teamAliases
+	defer func() { teamAliases = prevAliases }()
+	teamAliases = foldAliases(map[string]string{"Spurs": "Tottenham", "Malmo": "Malmö FF"})
+
+	s := map[string][]*match{"2014-05-17": {
+		testMatch("2014-05-17", "15:00", "Arsenal - Hull", "TV4"),
+		testMatch("2014-05-17", "16:00", "Chelsea - Tottenham", "C More Sport"),
+		testMatch("2014-05-17", "19:00", "Malmö FF - AIK", "TV4"),
+		testMatch("2014-05-17", "21:00", "Liverpool - Arsenal", "Kanal 5"),
+	}}
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"hide=Arsenal", []string{"Chelsea - Tottenham", "Malmö FF - AIK"}},
+		{"hide=arsenal&hide=CHELSEA", []string{"Malmö FF - AIK"}},
+		{"hide=Arsenal,Chelsea", []string{"Malmö FF - AIK"}},
+		{"hide=Spurs", []string{"Arsenal - Hull", "Liverpool - Arsenal", "Malmö FF - AIK"}},
+		{"hide=Malmo", []string{"Arsenal - Hull", "Chelsea - Tottenham", "Liverpool - Arsenal"}},
+		{"hide=malmo+ff", []string{"Arsenal - Hull", "Chelsea - Tottenham", "Liverpool - Arsenal"}},
+		{"hide=Hull&channels=TV4", []string{"Malmö FF - AIK"}},
+
+		// Hides win over the team filter
+		{"team=Arsenal&hide=Arsenal", nil},
+		{"team=Arsenal&hide=Hull", []string{"Liverpool - Arsenal"}},
+	} {
+		if got := filtered(t, tc.query, s)["2014-05-17"]; !reflect.DeepEqual(got, tc.want) && len(got)+len(tc.want) > 0 {
+			t.Errorf("?%s shows %q, want %q", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestHideFavorites(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+
+	defer useSchedule(testNow, map[string][]*match{"2014-05-17": {
+		testMatch("2014-05-17", "19:00", "Chelsea - Everton", "C More Sport"),
+		testMatch("2014-05-17", "21:00", "Arsenal - Hull", "TV4"),
+	}})()
+
+	// Favorites only reorder, so a favorite that is also hidden is removed
+	var list matchList
+	if err := json.Unmarshal(serve(t, inst, "/matches.json?favorites=Arsenal&hide=Arsenal", nil).Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := matchNames(list.Matches), []string{"Chelsea - Everton"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("/matches.json lists %q, want %q", got, want)
+	}
+
+	if list.AppliedFilters == nil || !reflect.DeepEqual(list.AppliedFilters.Hide, []string{"Arsenal"}) {
+		t.Errorf("AppliedFilters = %+v, want Hide [Arsenal]", list.AppliedFilters)
+	}
+
+	page := serve(t, inst, "/?favorites=Arsenal&hide=Arsenal", nil).Body.String()
+	if strings.Contains(page, "Arsenal - Hull") || !strings.Contains(page, "Chelsea - Everton") {
+		t.Errorf("page with Arsenal both a favorite and hidden lists Arsenal - Hull, or not Chelsea - Everton")
+	}
+
+	// Without the hide the favorite goes first
+	if err := json.Unmarshal(serve(t, inst, "/matches.json?favorites=Arsenal", nil).Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := matchNames(list.Matches), []string{"Arsenal - Hull", "Chelsea - Everton"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("/matches.json?favorites=Arsenal lists %q, want %q", got, want)
+	}
+}
+
+func TestInterestTeams(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+
+	prevInterest := interestTeams
+	defer func() { interestTeams = prevInterest }()
+	interestTeams = []string{"Hammarby"}
+
+	inLeague := func(league, clock, name string) *match {
+		m := testMatch("2014-05-17", clock, name, "TV4")
+		m.League = league
+		return m
+	}
+
+	defer useSchedule(testNow, map[string][]*match{"2014-05-17": {
+		testMatch("2014-05-17", "16:00", "Arsenal - Hull", "TV4"),
+		inLeague("Superettan", "17:00", "Hammarby - Ljungskile"),
+		inLeague("Allsvenskan", "19:00", "Malmö FF - AIK"),
+	}})()
+
+	all := []string{"Arsenal - Hull", "Hammarby - Ljungskile", "Malmö FF - AIK"}
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		// Superettan isn't a default league, but Hammarby is of interest
+		{"", []string{"Arsenal - Hull", "Hammarby - Ljungskile"}},
+
+		// Chosen leagues are filtered strictly
+		{"leagues=Premier+League", []string{"Arsenal - Hull"}},
+		{"leagues=Allsvenskan", []string{"Malmö FF - AIK"}},
+		{"leagues=all", all},
+	} {
+		var list matchList
+		if err := json.Unmarshal(serve(t, inst, "/matches.json?"+tc.query, nil).Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := matchNames(list.Matches); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("/matches.json?%s lists %q, want %q", tc.query, got, tc.want)
+		}
+
+		page := serve(t, inst, "/?"+tc.query, nil).Body.String()
+		var shown []string
+		for _, name := range all {
+			if strings.Contains(page, name) {
+				shown = append(shown, name)
+			}
+		}
+
+		if !reflect.DeepEqual(shown, tc.want) {
+			t.Errorf("/?%s lists %q, want %q", tc.query, shown, tc.want)
+		}
+	}
+}
+
+func TestAppliedFilters(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, map[string][]*match{"2014-05-17": {
+		testMatch("2014-05-17", "16:00", "Arsenal - Hull", "TV4"),
+	}})()
+
+	for _, tc := range []struct {
+		query string
+		want  appliedFilters
+	}{
+		{"", appliedFilters{Leagues: defaultLeagues(), Sports: defaultSports}},
+		{
+			"leagues=all&team=Arsenal&channels=TV4&days=2&order=desc&tz=Europe/London",
+			appliedFilters{Sports: defaultSports, Teams: []string{"Arsenal"}, Channels: []string{"TV4"}, Days: 2, Tz: "Europe/London", Order: "desc"},
+		},
+		{"tz=UTC&from=2014-05-17&to=2014-05-18", appliedFilters{Leagues: defaultLeagues(), Sports: defaultSports, Tz: "UTC", From: "2014-05-17", To: "2014-05-18"}},
+	} {
+		var parsed appliedFilters
+		if err := json.Unmarshal(serve(t, inst, "/filters/parse?"+tc.query, nil).Body.Bytes(), &parsed); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(parsed, tc.want) {
+			t.Errorf("/filters/parse?%s = %+v, want %+v", tc.query, parsed, tc.want)
+		}
+
+		var list matchList
+		if err := json.Unmarshal(serve(t, inst, "/matches.json?"+tc.query, nil).Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+
+		if list.AppliedFilters == nil || !reflect.DeepEqual(*list.AppliedFilters, tc.want) {
+			t.Errorf("/matches.json?%s echoes %+v, want %+v", tc.query, list.AppliedFilters, tc.want)
+		}
+	}
+
+	if w := serve(t, inst, "/filters/parse?tz=Nowhere/Special", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("GET /filters/parse?tz=Nowhere/Special = %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,166 @@
+package alexmatchen
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// Values of the groupby parameter of /schedule.json. The HTML page can be
+// grouped by day or league only.
+const (
+	groupByDay      = "day"
+	groupByWeek     = "week"
+	groupByTimeslot = "timeslot"
+	groupByLeague   = "league"
+)
+
+// An ISO week of the schedule, see ?groupby=week. Start and End are the
+// Monday and Sunday of the week, while Days only holds the days of it that
+// are in the schedule.
+type week struct {
+	Week   string
+	Year   int
+	Number int
+	Start  string
+	End    string
+	Days   []*day
+}
+
+// Parses the groupby parameter, defaulting to grouping by day.
+func parseGroupBy(r *http.Request) (string, error) {
+	switch v := r.FormValue("groupby"); v {
+	case "", groupByDay:
+		return groupByDay, nil
+	case groupByWeek, groupByTimeslot, groupByLeague:
+		return v, nil
+	default:
+		return "", fmt.Errorf("groupby must be %s, %s, %s or %s, got %q", groupByDay, groupByWeek, groupByTimeslot, groupByLeague, v)
+	}
+}
+
+// Groups days in chronological order by ISO week, e.g. 2014-W20, so a window
+// crossing a Sunday is split in two weeks. Days whose date can't be parsed are
+// left out.
+func weeksOf(days []*day) []*week {
+	weeks := []*week{}
+	for _, d := range days {
+		date, ok := dayDate(d.Date)
+		if !ok {
+			continue
+		}
+
+		year, number := date.ISOWeek()
+		if n := len(weeks); n > 0 && weeks[n-1].Year == year && weeks[n-1].Number == number {
+			weeks[n-1].Days = append(weeks[n-1].Days, d)
+			continue
+		}
+
+		// Weeks start on Monday, and Sunday is the seventh day
+		offset := (int(date.Weekday()) + 6) % 7
+		start := date.AddDate(0, 0, -offset)
+		weeks = append(weeks, &week{
+			Week:   fmt.Sprintf("%d-W%02d", year, number),
+			Year:   year,
+			Number: number,
+			Start:  start.Format("2006-01-02"),
+			End:    start.AddDate(0, 0, 6).Format("2006-01-02"),
+			Days:   []*day{d},
+		})
+	}
+
+	return weeks
+}
+
+// A day of the schedule with its matches bucketed by kickoff hour, see
+// ?groupby=timeslot.
+type slottedDay struct {
+	Date  string
+	Label string
+	Slots []*timeslot
+}
+
+// The matches of a day kicking off within the same hour, e.g. "19:00" for
+// 19:00 to 19:59. Matches without a known kickoff share the unknownGroup
+// slot, last.
+type timeslot struct {
+	Slot    string
+	Matches []*match
+}
+
+// Buckets the matches of each day by kickoff hour. Days must be in kickoff
+// order, like those of orderedDays, and hours without matches are left out.
+func timeslotsOf(days []*day) []*slottedDay {
+	slotted := make([]*slottedDay, len(days))
+	for i, d := range days {
+		slotted[i] = &slottedDay{Date: d.Date, Label: d.Label, Slots: []*timeslot{}}
+		for _, m := range d.Matches {
+			slot := unknownGroup
+			if m.TimeKnown {
+				slot = m.Kickoff.In(stockholm).Format("15") + ":00"
+			}
+
+			slots := slotted[i].Slots
+			if n := len(slots); n > 0 && slots[n-1].Slot == slot {
+				slots[n-1].Matches = append(slots[n-1].Matches, m)
+				continue
+			}
+
+			slotted[i].Slots = append(slots, &timeslot{Slot: slot, Matches: []*match{m}})
+		}
+	}
+
+	return slotted
+}
+
+// A day of the schedule with its matches grouped by league, see
+// ?groupby=league.
+type leagueDay struct {
+	Date    string
+	Label   string
+	Leagues []*leagueGroup
+}
+
+// The matches of a day in the same league, keeping their order. Matches
+// without a league share the unknownGroup league, last.
+type leagueGroup struct {
+	League  string
+	Matches []*match
+}
+
+// Groups the matches of each day by league, in alphabetical order of league
+// like the headings of ?collapseLeagues=true on the HTML page.
+func leagueGroupsOf(days []*day) []*leagueDay {
+	grouped := make([]*leagueDay, len(days))
+	for i, d := range days {
+		grouped[i] = &leagueDay{Date: d.Date, Label: d.Label, Leagues: []*leagueGroup{}}
+		matches := append([]*match{}, d.Matches...)
+		sort.Stable(byLeague(matches))
+
+		var unknown *leagueGroup
+		for _, m := range matches {
+			if m.League == "" {
+				if unknown == nil {
+					unknown = &leagueGroup{League: unknownGroup}
+				}
+
+				unknown.Matches = append(unknown.Matches, m)
+				continue
+			}
+
+			leagues := grouped[i].Leagues
+			if n := len(leagues); n > 0 && leagues[n-1].League == m.League {
+				leagues[n-1].Matches = append(leagues[n-1].Matches, m)
+				continue
+			}
+
+			grouped[i].Leagues = append(leagues, &leagueGroup{League: m.League, Matches: []*match{m}})
+		}
+
+		if unknown != nil {
+			grouped[i].Leagues = append(grouped[i].Leagues, unknown)
+		}
+	}
+
+	return grouped
+}
@@ -0,0 +1,228 @@
+package alexmatchen
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// Returns the slots of a day bucketed by timeslotsOf, and the names of the
+// matches in each.
+func slotNames(d *slottedDay) ([]string, [][]string) {
+	var slots []string
+	var names [][]string
+	for _, slot := range d.Slots {
+		slots = append(slots, slot.Slot)
+		names = append(names, matchNames(slot.Matches))
+	}
+
+	return slots, names
+}
+
+func TestPinnedTimeslots(t *testing.T) {
+	s := map[string][]*match{"2014-05-17": {
+		testMatch("2014-05-17", "21:00", "Arsenal - Hull", "TV4"),
+		testMatch("2014-05-17", "19:00", "Chelsea - Everton", "C More Sport"),
+		testMatch("2014-05-17", "19:30", "Fulham - Stoke", "TV4"),
+	}}
+
+	d := &display{PreferChannels: []string{"TV4"}}
+	days := d.pinSlots(timeslotsOf(orderedDays(s)))
+	slots, names := slotNames(days[0])
+	if want := []string{"19:00", "21:00"}; !reflect.DeepEqual(slots, want) {
+		t.Errorf("slots = %q, want %q", slots, want)
+	}
+
+	want := [][]string{{"Fulham - Stoke", "Chelsea - Everton"}, {"Arsenal - Hull"}}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("matches = %q, want %q", names, want)
+	}
+}
+
+// Returns the weeks grouped by weeksOf, and the dates of the days in each.
+func weekDates(weeks []*week) ([]string, [][]string) {
+	var labels []string
+	var dates [][]string
+	for _, w := range weeks {
+		labels = append(labels, w.Week+" "+w.Start+" "+w.End)
+		dates = append(dates, dayDates(w.Days))
+	}
+
+	return labels, dates
+}
+
+func TestWeekGrouping(t *testing.T) {
+	tests := []struct {
+		name   string
+		dates  []string
+		weeks  []string
+		inWeek [][]string
+	}{
+		{
+			name:   "Sunday to Monday",
+			dates:  []string{"2014-05-17", "2014-05-18", "2014-05-19"},
+			weeks:  []string{"2014-W20 2014-05-12 2014-05-18", "2014-W21 2014-05-19 2014-05-25"},
+			inWeek: [][]string{{"2014-05-17", "2014-05-18"}, {"2014-05-19"}},
+		},
+		{
+			name:   "a week skipped",
+			dates:  []string{"2014-05-18", "2014-05-26"},
+			weeks:  []string{"2014-W20 2014-05-12 2014-05-18", "2014-W22 2014-05-26 2014-06-01"},
+			inWeek: [][]string{{"2014-05-18"}, {"2014-05-26"}},
+		},
+		{
+			name:   "new year in week 1",
+			dates:  []string{"2014-12-28", "2014-12-29", "2015-01-04", "2015-01-05"},
+			weeks:  []string{"2014-W52 2014-12-22 2014-12-28", "2015-W01 2014-12-29 2015-01-04", "2015-W02 2015-01-05 2015-01-11"},
+			inWeek: [][]string{{"2014-12-28"}, {"2014-12-29", "2015-01-04"}, {"2015-01-05"}},
+		},
+		{
+			name:   "new year in week 53",
+			dates:  []string{"2015-12-31", "2016-01-03", "2016-01-04"},
+			weeks:  []string{"2015-W53 2015-12-28 2016-01-03", "2016-W01 2016-01-04 2016-01-10"},
+			inWeek: [][]string{{"2015-12-31", "2016-01-03"}, {"2016-01-04"}},
+		},
+		{
+			name:   "unparsable date",
+			dates:  []string{"2014-05-18", "bogus"},
+			weeks:  []string{"2014-W20 2014-05-12 2014-05-18"},
+			inWeek: [][]string{{"2014-05-18"}},
+		},
+	}
+
+	for _, tc := range tests {
+		s := map[string][]*match{}
+		for _, date := range tc.dates {
+			s[date] = []*match{testMatch(date, "19:00", "Arsenal - Hull", "TV4")}
+		}
+
+		weeks, inWeek := weekDates(weeksOf(orderedDays(s)))
+		if !reflect.DeepEqual(weeks, tc.weeks) || !reflect.DeepEqual(inWeek, tc.inWeek) {
+			t.Errorf("%s: weeks = %q with days %q, want %q with days %q", tc.name, weeks, inWeek, tc.weeks, tc.inWeek)
+		}
+	}
+}
+
+func TestWeekGroupingEndpoint(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+
+	defer useSchedule(testNow, map[string][]*match{
+		"2014-05-18": {testMatch("2014-05-18", "16:00", "Arsenal - Hull", "TV4")},
+		"2014-05-19": {testMatch("2014-05-19", "20:45", "Chelsea - Everton", "C More Sport")},
+	})()
+
+	var weeks []*week
+	if err := json.Unmarshal(serve(t, inst, "/schedule.json?groupby=week", nil).Body.Bytes(), &weeks); err != nil {
+		t.Fatal(err)
+	}
+
+	labels, dates := weekDates(weeks)
+	if want := []string{"2014-W20 2014-05-12 2014-05-18", "2014-W21 2014-05-19 2014-05-25"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("weeks = %q, want %q", labels, want)
+	}
+
+	if want := [][]string{{"2014-05-18"}, {"2014-05-19"}}; !reflect.DeepEqual(dates, want) {
+		t.Errorf("days = %q, want %q", dates, want)
+	}
+
+	if len(weeks) == 2 && (weeks[1].Year != 2014 || weeks[1].Number != 21 || len(weeks[1].Days[0].Matches) != 1) {
+		t.Errorf("second week = %+v, want 2014 week 21 with one match", weeks[1])
+	}
+}
+
+func TestTimeslots(t *testing.T) {
+	tests := []struct {
+		name    string
+		matches []*match
+		slots   []string
+		inSlot  [][]string
+	}{
+		{
+			name: "same hour",
+			matches: []*match{
+				testMatch("2014-05-17", "19:00", "Chelsea - Everton"),
+				testMatch("2014-05-17", "19:30", "Fulham - Stoke"),
+				testMatch("2014-05-17", "19:59", "Arsenal - Hull"),
+				testMatch("2014-05-17", "20:00", "Liverpool - Newcastle"),
+			},
+			slots:  []string{"19:00", "20:00"},
+			inSlot: [][]string{{"Chelsea - Everton", "Fulham - Stoke", "Arsenal - Hull"}, {"Liverpool - Newcastle"}},
+		},
+		{
+			name: "empty hours left out",
+			matches: []*match{
+				testMatch("2014-05-17", "00:30", "Malmö FF - AIK"),
+				testMatch("2014-05-17", "13:45", "Chelsea - Everton"),
+				testMatch("2014-05-17", "23:45", "Arsenal - Hull"),
+			},
+			slots:  []string{"00:00", "13:00", "23:00"},
+			inSlot: [][]string{{"Malmö FF - AIK"}, {"Chelsea - Everton"}, {"Arsenal - Hull"}},
+		},
+		{
+			name: "unknown kickoffs last",
+			matches: []*match{
+				testMatch("2014-05-17", "-", "Fulham - Stoke"),
+				testMatch("2014-05-17", "16:00", "Arsenal - Hull"),
+				testMatch("2014-05-17", "Ej klart", "Chelsea - Everton"),
+			},
+			slots:  []string{"16:00", unknownGroup},
+			inSlot: [][]string{{"Arsenal - Hull"}, {"Fulham - Stoke", "Chelsea - Everton"}},
+		},
+		{
+			name:    "no matches",
+			matches: []*match{},
+		},
+	}
+
+	for _, tc := range tests {
+		days := timeslotsOf(orderedDays(map[string][]*match{"2014-05-17": tc.matches}))
+		if len(days) != 1 || days[0].Date != "2014-05-17" || days[0].Slots == nil {
+			t.Errorf("%s: slotted %+v, want one day with slots", tc.name, days)
+			continue
+		}
+
+		slots, names := slotNames(days[0])
+		if !reflect.DeepEqual(slots, tc.slots) || !reflect.DeepEqual(names, tc.inSlot) {
+			t.Errorf("%s: slots %q with matches %q, want %q with %q", tc.name, slots, names, tc.slots, tc.inSlot)
+		}
+	}
+}
+
+func TestTimeslotsEndpoint(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, map[string][]*match{
+		"2014-05-17": {
+			testMatch("2014-05-17", "19:30", "Fulham - Stoke"),
+			testMatch("2014-05-17", "19:00", "Chelsea - Everton"),
+			testMatch("2014-05-17", "21:00", "Arsenal - Hull"),
+		},
+		"2014-05-18": {
+			testMatch("2014-05-18", "19:15", "Liverpool - Newcastle"),
+		},
+	})()
+
+	var days []*slottedDay
+	if err := json.Unmarshal(serve(t, inst, "/schedule.json?groupby=timeslot", nil).Body.Bytes(), &days); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(days) != 2 {
+		t.Fatalf("got %d slotted days, want 2", len(days))
+	}
+
+	// Buckets are per day, so the same hour on another day is apart
+	for i, want := range []struct {
+		slots  []string
+		inSlot [][]string
+	}{
+		{[]string{"19:00", "21:00"}, [][]string{{"Chelsea - Everton", "Fulham - Stoke"}, {"Arsenal - Hull"}}},
+		{[]string{"19:00"}, [][]string{{"Liverpool - Newcastle"}}},
+	} {
+		slots, names := slotNames(days[i])
+		if !reflect.DeepEqual(slots, want.slots) || !reflect.DeepEqual(names, want.inSlot) {
+			t.Errorf("%s: slots %q with matches %q, want %q with %q", days[i].Date, slots, names, want.slots, want.inSlot)
+		}
+	}
+}
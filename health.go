@@ -0,0 +1,60 @@
+package alexmatchen
+
+import (
+	"net/http"
+	"time"
+)
+
+// Body of /healthz, the readiness probe.
+type health struct {
+	Loaded  bool
+	Matches int
+
+	// When the schedule was last refreshed successfully, and when a refresh
+	// was last attempted, successful or not.
+	LastRefresh string
+	LastAttempt string
+
+	NextRefresh string
+	LastError   string
+
+	// Refreshes failed in a row, backing off the next one.
+	FailedRefreshes int
+}
+
+func init() {
+	handle("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		// nextRefresh takes the lock itself
+		next := nextRefresh()
+		mu.RLock()
+		h := &health{
+			Loaded:          schedule != nil,
+			Matches:         countMatches(schedule),
+			LastRefresh:     lastRefresh.Format(time.RFC3339),
+			LastAttempt:     lastAttempt.Format(time.RFC3339),
+			NextRefresh:     next.Format(time.RFC3339),
+			FailedRefreshes: failedRefreshes,
+		}
+
+		if lastRefreshErr != nil {
+			h.LastError = lastRefreshErr.Error()
+		}
+		mu.RUnlock()
+
+		// Unhealthy until there is a schedule to serve
+		status := http.StatusOK
+		if !h.Loaded {
+			status = http.StatusServiceUnavailable
+		}
+
+		writeJSONStatus(w, r, status, h)
+	})
+
+	// Liveness probe, answering as long as the instance serves requests. It
+	// must not read the schedule or take locks, so a stuck refresh can't
+	// make it fail.
+	handleProbe("/livez", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(`{"Alive":true}`))
+	})
+}
@@ -0,0 +1,104 @@
+package alexmatchen
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLivezTakesNoLocks(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+
+	// A stuck refresh, and overrides due a reload
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+	mu.Lock()
+	defer mu.Unlock()
+	overridesMu.Lock()
+	loaded := overridesLoaded
+	overridesLoaded = time.Time{}
+	overridesMu.Unlock()
+	defer func() {
+		overridesMu.Lock()
+		overridesLoaded = loaded
+		overridesMu.Unlock()
+	}()
+
+	overridesMu.RLock()
+	defer overridesMu.RUnlock()
+
+	within(t, "GET /livez", func() {
+		if w := serve(t, inst, "/livez", nil); w.Code != http.StatusOK {
+			t.Errorf("GET /livez = %d, want 200", w.Code)
+		}
+	})
+}
+
+func TestFailedRefreshKeepsSuccess(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, map[string][]*match{"2014-05-17": {
+		testMatch("2014-05-17", "21:00", "Arsenal - Hull"),
+	}})()
+
+	prevDelay, prevRetries := fetchRetryDelay, fetchRetries
+	defer func() { fetchRetryDelay, fetchRetries = prevDelay, prevRetries }()
+	fetchRetryDelay, fetchRetries = time.Millisecond, 1
+
+	refreshAt := func(at time.Time, rt http.RoundTripper) error {
+		now = func() time.Time { return at }
+		defer useTransport(rt)()
+		r, err := inst.NewRequest("GET", "/tasks/refresh", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return refreshSchedule(r)
+	}
+
+	checkHealth := func(what string, want *health) {
+		var h health
+		if err := json.Unmarshal(serve(t, inst, "/healthz", nil).Body.Bytes(), &h); err != nil {
+			t.Fatal(err)
+		}
+
+		if h.LastRefresh != want.LastRefresh || h.LastAttempt != want.LastAttempt || (h.LastError != "") != (want.LastError != "") || h.FailedRefreshes != want.FailedRefreshes {
+			t.Errorf("%s: /healthz = %+v, want %+v", what, h, want)
+		}
+	}
+
+	success := testNow.Format(time.RFC3339)
+	attempt := testNow.Add(time.Hour)
+	if err := refreshAt(attempt, &cannedTransport{err: errors.New("connection refused")}); err == nil {
+		t.Fatal("refreshing from an unreachable upstream succeeded")
+	}
+
+	checkHealth("after a failed attempt", &health{LastRefresh: success, LastAttempt: attempt.Format(time.RFC3339), LastError: "failed", FailedRefreshes: 1})
+
+	// The page and its headers still date the schedule to the last success
+	w := serve(t, inst, "/", nil)
+	if got := w.Header().Get("X-Last-Refresh"); got != success {
+		t.Errorf("X-Last-Refresh = %q after a failed attempt, want %q", got, success)
+	}
+
+	if w.Header().Get("X-Data-Stale") != "true" {
+		t.Errorf("the page isn't flagged stale after a failed attempt")
+	}
+
+	if page := w.Body.String(); !strings.Contains(page, "Uppdaterad 17 maj 2014 17:00") {
+		t.Errorf("the page isn't dated to the last success:\n%s", page)
+	}
+
+	// A success moves both on
+	later := attempt.Add(time.Hour)
+	if err := refreshAt(later, pageTransport(func(r *http.Request) string { return selftestFixture })); err != nil {
+		t.Fatal(err)
+	}
+
+	stamp := later.Format(time.RFC3339)
+	checkHealth("after a success", &health{LastRefresh: stamp, LastAttempt: stamp})
+}
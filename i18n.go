@@ -0,0 +1,180 @@
+package alexmatchen
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Language used when a request doesn't ask for any.
+	defaultLang = "sv"
+
+	// Asks for output without any localized labels, see display.Neutral.
+	langNone = "none"
+)
+
+// Localized strings for one language.
+type catalog struct {
+	Weekdays map[time.Weekday]string
+	Months   map[time.Month]string
+
+	// Layouts of day labels and of timestamps shown on the page, in which
+	// {weekday}, {day}, {month}, {year} and {time} are replaced, see
+	// formatDate.
+	DayLayout   string
+	StampLayout string
+
+	// UI strings by message key, see translate.
+	Messages map[string]string
+}
+
+// Message catalogs by language code.
+var catalogs = map[string]*catalog{
+	"sv": {
+		Weekdays: map[time.Weekday]string{
+			time.Monday:    "Måndag",
+			time.Tuesday:   "Tisdag",
+			time.Wednesday: "Onsdag",
+			time.Thursday:  "Torsdag",
+			time.Friday:    "Fredag",
+			time.Saturday:  "Lördag",
+			time.Sunday:    "Söndag",
+		},
+		Months: map[time.Month]string{
+			time.January: "januari", time.February: "februari", time.March: "mars",
+			time.April: "april", time.May: "maj", time.June: "juni",
+			time.July: "juli", time.August: "augusti", time.September: "september",
+			time.October: "oktober", time.November: "november", time.December: "december",
+		},
+		DayLayout:   "{weekday} {day} {month}",
+		StampLayout: "{day} {month} {year} {time}",
+		Messages: map[string]string{
+			"title":           "Match på TV:n",
+			"intro":           "Fotboll på TV:n.",
+			"updated":         "Uppdaterad",
+			"tz":              "Tider i",
+			"lang.name":       "Svenska",
+			"status.live":     "Pågår",
+			"status.finished": "Slut",
+			"time.tbd":        "Tid ej bestämd",
+			"clash":           "Samtidigt som en annan match",
+			"offseason":       "Det är uppehåll, så det finns inga matcher att visa just nu.",
+			"digest.on":       "på",
+			"digest.none":     "Inga matcher idag",
+			"notify.title":    "Dagens matcher på TV",
+			"more":            "till",
+			"prefs.title":     "Välj sporter och ligor",
+			"prefs.save":      "Spara",
+			"prefs.favorites": "Favoritlag, listas först",
+		},
+	},
+	"en": {
+		Weekdays: map[time.Weekday]string{
+			time.Monday:    "Monday",
+			time.Tuesday:   "Tuesday",
+			time.Wednesday: "Wednesday",
+			time.Thursday:  "Thursday",
+			time.Friday:    "Friday",
+			time.Saturday:  "Saturday",
+			time.Sunday:    "Sunday",
+		},
+		Months: map[time.Month]string{
+			time.January: "January", time.February: "February", time.March: "March",
+			time.April: "April", time.May: "May", time.June: "June",
+			time.July: "July", time.August: "August", time.September: "September",
+			time.October: "October", time.November: "November", time.December: "December",
+		},
+		DayLayout:   "{weekday}, {month} {day}",
+		StampLayout: "{month} {day}, {year} {time}",
+		Messages: map[string]string{
+			"title":           "Football on TV",
+			"intro":           "Football on TV.",
+			"updated":         "Updated",
+			"tz":              "Times in",
+			"lang.name":       "English",
+			"status.live":     "Live",
+			"status.finished": "Finished",
+			"time.tbd":        "Time TBD",
+			"clash":           "Same time as another match",
+			"offseason":       "It's the off-season, so there are no matches to show right now.",
+			"digest.on":       "on",
+			"digest.none":     "No matches today",
+			"notify.title":    "Today's matches on TV",
+			"more":            "more",
+			"prefs.title":     "Choose sports and leagues",
+			"prefs.save":      "Save",
+			"prefs.favorites": "Favorite teams, listed first",
+		},
+	},
+}
+
+// Returns the language with a catalog that an Accept-Language header prefers,
+// e.g. "en" for "en-GB,en;q=0.9,sv;q=0.5", or "" if it names none of them.
+// Regional variants count as their language.
+func acceptedLang(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		lang := strings.ToLower(strings.TrimSpace(fields[0]))
+		if i := strings.Index(lang, "-"); i >= 0 {
+			lang = lang[:i]
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v := strings.TrimSpace(param); strings.HasPrefix(v, "q=") {
+				if parsed, err := strconv.ParseFloat(v[2:], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+
+		// Ties go to the language listed first
+		if _, ok := catalogs[lang]; ok && q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+
+	return best
+}
+
+// Returns the message for key in the given language, falling back to the
+// default language and then to the key itself.
+func translate(lang, key string) string {
+	if c, ok := catalogs[lang]; ok {
+		if msg, ok := c.Messages[key]; ok {
+			return msg
+		}
+	}
+
+	if msg, ok := catalogs[defaultLang].Messages[key]; ok {
+		return msg
+	}
+
+	return key
+}
+
+// Returns the day label for a date in the given language, e.g. "Söndag 18
+// maj" or "Sunday, May 18".
+func dayLabel(date time.Time, lang string) string {
+	return formatDate(date, catalogs[lang].DayLayout, lang)
+}
+
+// Returns a timestamp in Swedish time as shown on the page in the given
+// language, e.g. "18 maj 2014 20:45".
+func stampLabel(t time.Time, lang string) string {
+	return formatDate(t.In(stockholm), catalogs[lang].StampLayout, lang)
+}
+
+// Renders a date in a catalog layout of the given language.
+func formatDate(t time.Time, layout, lang string) string {
+	c := catalogs[lang]
+	return strings.NewReplacer(
+		"{weekday}", c.Weekdays[t.Weekday()],
+		"{day}", strconv.Itoa(t.Day()),
+		"{month}", c.Months[t.Month()],
+		"{year}", strconv.Itoa(t.Year()),
+		"{time}", t.Format("15:04"),
+	).Replace(layout)
+}
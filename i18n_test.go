@@ -0,0 +1,64 @@
+package alexmatchen
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDateLabels(t *testing.T) {
+	for _, tc := range []struct {
+		date       string
+		lang       string
+		day, stamp string
+	}{
+		{"2014-05-18", "sv", "Söndag 18 maj", "18 maj 2014 20:45"},
+		{"2014-05-18", "en", "Sunday, May 18", "May 18, 2014 20:45"},
+		{"2015-01-01", "sv", "Torsdag 1 januari", "1 januari 2015 20:45"},
+		{"2015-01-01", "en", "Thursday, January 1", "January 1, 2015 20:45"},
+	} {
+		date, _ := dayDate(tc.date)
+		if got := dayLabel(date, tc.lang); got != tc.day {
+			t.Errorf("dayLabel(%s, %s) = %q, want %q", tc.date, tc.lang, got, tc.day)
+		}
+
+		// Stamps are shown in Swedish time whatever their zone
+		at := date.Add(20*time.Hour + 45*time.Minute).UTC()
+		if got := stampLabel(at, tc.lang); got != tc.stamp {
+			t.Errorf("stampLabel(%v, %s) = %q, want %q", at, tc.lang, got, tc.stamp)
+		}
+	}
+}
+
+func TestLocalizedPage(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, map[string][]*match{"2014-05-18": {
+		testMatch("2014-05-18", "16:00", "Arsenal - Hull"),
+	}})()
+
+	for _, tc := range []struct {
+		query, day, stamp string
+	}{
+		{"", "Söndag 18 maj", "Uppdaterad 17 maj 2014 17:00"},
+		{"lang=sv", "Söndag 18 maj", "Uppdaterad 17 maj 2014 17:00"},
+		{"lang=en", "Sunday, May 18", "Updated May 17, 2014 17:00"},
+		{"lang=none", "2014-05-18", strings.Replace(testNow.Format(time.RFC3339), "+", "&#43;", 1)},
+	} {
+		page := serve(t, inst, "/?"+tc.query, nil).Body.String()
+		if !strings.Contains(page, "<h2>"+tc.day+"</h2>") || !strings.Contains(page, tc.stamp) {
+			t.Errorf("/?%s lacks day %q or stamp %q:\n%s", tc.query, tc.day, tc.stamp, page)
+		}
+	}
+
+	// JSON labels are localized too, while dates stay ISO dates
+	var days []*day
+	if err := json.Unmarshal(serve(t, inst, "/schedule.json?format=days&lang=en", nil).Body.Bytes(), &days); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(days) != 1 || days[0].Date != "2014-05-18" || days[0].Label != "Sunday, May 18" {
+		t.Errorf("/schedule.json?format=days&lang=en = %+v, want 2014-05-18 labeled Sunday, May 18", days)
+	}
+}
@@ -0,0 +1,178 @@
+package alexmatchen
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	icalTimeFormat = "20060102T150405Z"
+	icalUIDDomain  = "alex-matchen.appspot.com"
+
+	// Name of the calendar, see X-WR-CALNAME.
+	icalName = "Match på TV:n"
+
+	// RFC 5545 lines should be folded when longer than this many octets.
+	icalLineLimit = 75
+)
+
+// Escapes TEXT values per RFC 5545. Line breaks of any kind become \n.
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+func init() {
+	handle("/schedule.ics", func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseFilters(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Finished matches are left out unless asked for, to keep calendars
+		// clean
+		includeFinished, err := parseBoolParam(r, "includeFinished")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		verbose, err := parseBoolParam(r, "verbose")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := refreshScheduleIfNeeded(w, r); err != nil {
+			http.Error(w, err.Error(), refreshErrorStatus(err))
+			return
+		}
+
+		matches := []*match{}
+		for _, m := range flatten(f.apply(scheduleAt(cachedSchedule(), now()))) {
+			if includeFinished || m.Status != statusFinished {
+				matches = append(matches, m)
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		// Calendars of chosen leagues are named after them, so subscribing
+		// to several keeps them apart
+		name := icalName
+		if r.FormValue("leagues") != "" && len(f.Leagues) > 0 {
+			name += " - " + strings.Join(f.Leagues, ", ")
+		}
+
+		last, _ := refreshStatus()
+		w.Write(icalendar(matches, last, name, verbose))
+	})
+}
+
+// Renders matches as an iCalendar document. Matches without a known kickoff
+// are left out, and no matches give a calendar without events. Event UIDs
+// are derived from the match ID so calendar apps update events across
+// refreshes instead of duplicating them. Verbose calendars describe events
+// in full, see icalDescription, and categorize them by league and sport.
+func icalendar(matches []*match, stamp time.Time, name string, verbose bool) []byte {
+	var b bytes.Buffer
+	line := func(s string) {
+		b.WriteString(foldICalLine(s))
+		b.WriteString("\r\n")
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//alex-matchen//Match pa TV//SV")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	line("X-WR-CALNAME:" + icalEscaper.Replace(name))
+
+	for _, m := range matches {
+		if m.Kickoff.IsZero() {
+			continue
+		}
+
+		line("BEGIN:VEVENT")
+		line("UID:" + m.ID + "@" + icalUIDDomain)
+		line("DTSTAMP:" + stamp.UTC().Format(icalTimeFormat))
+		line("DTSTART:" + m.Kickoff.UTC().Format(icalTimeFormat))
+		line("DTEND:" + m.end().UTC().Format(icalTimeFormat))
+		line("SUMMARY:" + icalEscaper.Replace(m.Name))
+		if !verbose {
+			line("DESCRIPTION:" + icalEscaper.Replace(m.League+", "+m.Channel))
+			line("END:VEVENT")
+			continue
+		}
+
+		line("DESCRIPTION:" + icalEscaper.Replace(icalDescription(m)))
+		if m.Source == sourceName {
+			line("URL:" + tvmatchenUrl)
+		}
+
+		// Categories are a comma separated list, so each one is escaped on
+		// its own
+		categories := []string{}
+		for _, category := range []string{m.League, m.Sport} {
+			if category != "" {
+				categories = append(categories, icalEscaper.Replace(category))
+			}
+		}
+
+		if len(categories) > 0 {
+			line("CATEGORIES:" + strings.Join(categories, ","))
+		}
+
+		line("END:VEVENT")
+	}
+
+	line("END:VCALENDAR")
+	return b.Bytes()
+}
+
+// Describes a match on a line each: its league and round, every channel, its
+// status at request time, and for scraped matches the page it was found on.
+func icalDescription(m *match) string {
+	lines := []string{m.League}
+	if m.Round != "" {
+		lines[0] += ", " + m.Round
+	}
+
+	lines = append(lines, strings.Join(m.Channels, ", "))
+	if m.Status == statusLive || m.Status == statusFinished {
+		lines = append(lines, translate(defaultLang, "status."+m.Status))
+	}
+
+	if m.Source == sourceName {
+		lines = append(lines, tvmatchenUrl)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Folds a content line into chunks of at most icalLineLimit octets, without
+// splitting UTF-8 sequences. Continuation lines start with a space.
+func foldICalLine(s string) string {
+	if len(s) <= icalLineLimit {
+		return s
+	}
+
+	var b bytes.Buffer
+	limit := icalLineLimit
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+
+		b.WriteString(s[:cut])
+		b.WriteString("\r\n ")
+		s = s[cut:]
+
+		// Continuation lines lose one octet to the leading space
+		limit = icalLineLimit - 1
+	}
+
+	b.WriteString(s)
+	return b.String()
+}
@@ -0,0 +1,251 @@
+package alexmatchen
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmptyCalendar(t *testing.T) {
+	for _, verbose := range []bool{false, true} {
+		cal := string(icalendar(nil, testNow, icalName, verbose))
+		if !strings.HasPrefix(cal, "BEGIN:VCALENDAR\r\n") || !strings.HasSuffix(cal, "END:VCALENDAR\r\n") {
+			t.Errorf("empty calendar (verbose %v) isn't a VCALENDAR:\n%s", verbose, cal)
+		}
+
+		if strings.Contains(cal, "VEVENT") {
+			t.Errorf("empty calendar (verbose %v) has events:\n%s", verbose, cal)
+		}
+
+		if strings.Contains(strings.Replace(cal, "\r\n", "", -1), "\n") {
+			t.Errorf("empty calendar (verbose %v) has bare line feeds:\n%q", verbose, cal)
+		}
+	}
+}
+
+// Each serializer of an empty schedule gives a valid document without
+// matches, rather than null or an error.
+func TestEmptySchedule(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, map[string][]*match{})()
+
+	for _, tc := range []struct {
+		path  string
+		check func(body string) bool
+	}{
+		{"/schedule.json", func(body string) bool {
+			var s map[string][]*match
+			return json.Unmarshal([]byte(body), &s) == nil && s != nil && len(s) == 0
+		}},
+		{"/matches.json", func(body string) bool {
+			return strings.Contains(body, `"Matches":[]`) && !strings.Contains(body, "null")
+		}},
+		{"/schedule.ics", func(body string) bool {
+			return strings.HasPrefix(body, "BEGIN:VCALENDAR\r\n") && !strings.Contains(body, "VEVENT")
+		}},
+		{"/feed.xml", func(body string) bool {
+			var f atomFeed
+			return xml.Unmarshal([]byte(body), &f) == nil && f.ID != "" && len(f.Entries) == 0
+		}},
+		{"/schedule.csv", func(body string) bool {
+			return body == "\ufeff"+strings.Join(csvHeader, ",")+"\n"
+		}},
+	} {
+		w := serve(t, inst, tc.path, nil)
+		if w.Code != http.StatusOK || !tc.check(w.Body.String()) {
+			t.Errorf("GET %s of an empty schedule = %d %q", tc.path, w.Code, w.Body)
+		}
+	}
+}
+
+var icalUID = regexp.MustCompile(`(?m)^UID:(.*)\r$`)
+
+// Returns the UIDs of the events of a calendar.
+func calendarUIDs(cal string) []string {
+	var uids []string
+	for _, m := range icalUID.FindAllStringSubmatch(cal, -1) {
+		uids = append(uids, m[1])
+	}
+
+	return uids
+}
+
+// A calendar of a team has only the team's matches, as events that keep
+// their UIDs when a refresh changes the kickoff or channels.
+func TestTeamCalendar(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+
+	defer useSchedule(testNow, map[string][]*match{
+		"2014-05-17": {
+			testMatch("2014-05-17", "18:00", "Arsenal - Hull", "TV4"),
+			testMatch("2014-05-17", "21:00", "Chelsea - Everton", "C More Sport"),
+		},
+		"2014-05-18": {testMatch("2014-05-18", "16:00", "Liverpool - Arsenal", "Viasat Fotboll")},
+	})()
+
+	const path = "/schedule.ics?team=arsenal"
+	first := calendarUIDs(serve(t, inst, path, nil).Body.String())
+	if len(first) != 2 || first[0] == first[1] {
+		t.Fatalf("GET %s has UIDs %q, want 2 distinct ones", path, first)
+	}
+
+	defer useSchedule(testNow, map[string][]*match{
+		"2014-05-17": {
+			testMatch("2014-05-17", "18:30", "Arsenal - Hull", "TV4", "C More Sport"),
+			testMatch("2014-05-17", "21:00", "Chelsea - Everton", "C More Sport"),
+		},
+		"2014-05-18": {testMatch("2014-05-18", "16:00", "Liverpool - Arsenal", "TV3")},
+	})()
+
+	if again := calendarUIDs(serve(t, inst, path, nil).Body.String()); !reflect.DeepEqual(again, first) {
+		t.Errorf("GET %s after a refresh has UIDs %q, want %q", path, again, first)
+	}
+}
+
+func TestCalendarFinished(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+
+	// At 17:00 the early match is over, the next one on
+	finished := testMatch("2014-05-17", "13:30", "Fulham - Stoke")
+	live := testMatch("2014-05-17", "16:00", "Arsenal - Hull")
+	upcoming := testMatch("2014-05-17", "19:00", "Chelsea - Everton")
+	defer useSchedule(testNow, map[string][]*match{"2014-05-17": {finished, live, upcoming}})()
+
+	uid := func(m *match) string { return m.ID + "@" + icalUIDDomain }
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{uid(live), uid(upcoming)}},
+		{"includeFinished=false", []string{uid(live), uid(upcoming)}},
+		{"includeFinished=true", []string{uid(finished), uid(live), uid(upcoming)}},
+	} {
+		if got := calendarUIDs(serve(t, inst, "/schedule.ics?"+tc.query, nil).Body.String()); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("/schedule.ics?%s has events %q, want %q", tc.query, got, tc.want)
+		}
+	}
+
+	if w := serve(t, inst, "/schedule.ics?includeFinished=maybe", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("/schedule.ics?includeFinished=maybe = %d, want 400", w.Code)
+	}
+}
+
+func TestLeagueCalendar(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+
+	cup := testMatch("2014-05-17", "16:00", "Arsenal - Hull", "TV4")
+	cup.League = "FA Cup"
+	league := testMatch("2014-05-17", "19:00", "Chelsea - Everton", "C More Sport")
+	defer useSchedule(testNow, map[string][]*match{"2014-05-17": {cup, league}})()
+
+	cal := serve(t, inst, "/schedule.ics?leagues=Premier+League", nil).Body.String()
+	want := []string{league.ID + "@" + icalUIDDomain}
+	if got := calendarUIDs(cal); !reflect.DeepEqual(got, want) {
+		t.Errorf("Premier League calendar has events %q, want %q", got, want)
+	}
+
+	// 19:00 in Stockholm is 17:00 UTC, and matches last matchDuration
+	for _, line := range []string{"X-WR-CALNAME:Match på TV:n - Premier League\r\n", "DTSTART:20140517T170000Z\r\n", "DTEND:20140517T190000Z\r\n"} {
+		if !strings.Contains(cal, line) {
+			t.Errorf("Premier League calendar lacks %q:\n%s", line, cal)
+		}
+	}
+
+	// A refresh moving the kickoff keeps the UID
+	moved := testMatch("2014-05-17", "19:30", "Chelsea - Everton", "TV4")
+	defer useSchedule(testNow, map[string][]*match{"2014-05-17": {cup, moved}})()
+	cal = serve(t, inst, "/schedule.ics?leagues=Premier+League", nil).Body.String()
+	if got := calendarUIDs(cal); !reflect.DeepEqual(got, want) || !strings.Contains(cal, "DTSTART:20140517T173000Z\r\n") {
+		t.Errorf("Premier League calendar after a refresh has events %q, want %q moved to 17:30 UTC:\n%s", got, want, cal)
+	}
+
+	w := serve(t, inst, "/schedule.ics?leagues=Allsvenskan", nil)
+	if body := w.Body.String(); w.Code != http.StatusOK || !strings.HasPrefix(body, "BEGIN:VCALENDAR\r\n") || !strings.HasSuffix(body, "END:VCALENDAR\r\n") || len(calendarUIDs(body)) > 0 {
+		t.Errorf("Allsvenskan calendar = %d %q, want an empty VCALENDAR", w.Code, body)
+	}
+}
+
+// Events end their sport's duration after kickoff.
+func TestCalendarDurations(t *testing.T) {
+	prevDurations := sportDurations
+	defer func() { sportDurations = prevDurations }()
+	sportDurations = map[string]time.Duration{"Ishockey": 2*time.Hour + 30*time.Minute}
+
+	hockey := testMatch("2014-05-17", "19:00", "Frölunda - HV71")
+	hockey.Sport = "Ishockey"
+	for _, tc := range []struct {
+		m    *match
+		want string
+	}{
+		{testMatch("2014-05-17", "19:00", "Chelsea - Everton"), "DTEND:20140517T190000Z\r\n"},
+		{hockey, "DTEND:20140517T193000Z\r\n"},
+	} {
+		if cal := string(icalendar([]*match{tc.m}, testNow, icalName, false)); !strings.Contains(cal, tc.want) {
+			t.Errorf("calendar of %s (%s) lacks %q:\n%s", tc.m.Name, tc.m.Sport, tc.want, cal)
+		}
+	}
+}
+
+func TestICalEscaping(t *testing.T) {
+	for _, tc := range []struct {
+		text, want string
+	}{
+		{"Arsenal - Hull", "Arsenal - Hull"},
+		{"TV4, C More", `TV4\, C More`},
+		{"Allsvenskan; Damer", `Allsvenskan\; Damer`},
+		{`C:\TV`, `C:\\TV`},
+		{"Final\nReplay", `Final\nReplay`},
+		{"Final\r\nReplay\rAgain", `Final\nReplay\nAgain`},
+		{`a\,b`, `a\\\,b`},
+	} {
+		if got := icalEscaper.Replace(tc.text); got != tc.want {
+			t.Errorf("escaping %q = %q, want %q", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestVerboseCalendar(t *testing.T) {
+	m := testMatch("2014-05-17", "19:00", "Malmö FF - AIK", "TV4", "C More Sport")
+	m.League, m.Round, m.Status = "Allsvenskan; Damer", "Omgång 8", statusFinished
+
+	listed := testMatch("2014-05-17", "21:00", "Arsenal - Hull")
+	listed.Source = "streams.example"
+
+	// Long lines are folded, so they're unfolded before looking for them
+	unfold := func(cal []byte) string { return strings.Replace(string(cal), "\r\n ", "", -1) }
+	cal := unfold(icalendar([]*match{m, listed}, testNow, icalName, true))
+	for _, want := range []string{
+		`DESCRIPTION:Allsvenskan\; Damer\, Omgång 8\nTV4\, C More Sport\n` + translate(defaultLang, "status.finished") + `\n` + tvmatchenUrl + "\r\n",
+		"URL:" + tvmatchenUrl + "\r\n",
+		`CATEGORIES:Allsvenskan\; Damer,Fotboll` + "\r\n",
+		"DESCRIPTION:Premier League\\n\r\n",
+		"CATEGORIES:Premier League,Fotboll\r\n",
+	} {
+		if !strings.Contains(cal, want) {
+			t.Errorf("verbose calendar lacks %q:\n%s", want, cal)
+		}
+	}
+
+	if n := strings.Count(cal, "URL:"); n != 1 {
+		t.Errorf("verbose calendar has %d URLs, want one for the scraped match only:\n%s", n, cal)
+	}
+
+	// The default calendar stays minimal
+	cal = unfold(icalendar([]*match{m}, testNow, icalName, false))
+	if want := `DESCRIPTION:Allsvenskan\; Damer\, TV4\, C More Sport` + "\r\n"; !strings.Contains(cal, want) {
+		t.Errorf("calendar lacks %q:\n%s", want, cal)
+	}
+
+	if strings.Contains(cal, "CATEGORIES:") || strings.Contains(cal, "URL:") {
+		t.Errorf("calendar without verbose has categories or a URL:\n%s", cal)
+	}
+}
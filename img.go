@@ -0,0 +1,116 @@
+package alexmatchen
+
+import (
+	"appengine/urlfetch"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// Largest image /img passes on.
+	maxImageBytes = 1 << 20
+
+	// How long browsers and proxies may cache proxied images.
+	imageMaxAge = 24 * 60 * 60
+)
+
+// Hosts /img fetches images from, matching subdomains too, e.g.
+// "tvmatchen.nu,cdn.example.com".
+var imageHosts = splitList(setting("IMAGE_HOSTS", "tvmatchen.nu"))
+
+func init() {
+	// Serves upstream images such as channel logos from our own origin, so
+	// pages don't mix in plain HTTP content or send their address to
+	// upstream as the referrer
+	handle("/img", func(w http.ResponseWriter, r *http.Request) {
+		u, err := parseImageURL(r.FormValue("u"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		c := newContext(r)
+		client := urlfetch.Client(c)
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if !imageHost(req.URL.Host) {
+				return fmt.Errorf("refusing redirect to %q", req.URL.Host)
+			}
+
+			return nil
+		}
+
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		req.Header.Set("User-Agent", userAgent)
+		resp, err := client.Do(req)
+		if err != nil {
+			c.Warningf("Fetching image %s failed: %v", u, err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		defer resp.Body.Close()
+		contentType := resp.Header.Get("Content-Type")
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(contentType, "image/") {
+			http.Error(w, fmt.Sprintf("unexpected response from upstream: %s %s", resp.Status, contentType), http.StatusBadGateway)
+			return
+		}
+
+		if resp.ContentLength > maxImageBytes {
+			http.Error(w, "image too large", http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", imageMaxAge))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+
+		// SVG images can carry scripts, which must not run on our origin
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+		io.Copy(w, io.LimitReader(resp.Body, maxImageBytes))
+	})
+}
+
+// Parses the address of an image to proxy, which must be an http or https
+// URL on one of imageHosts.
+func parseImageURL(raw string) (*url.URL, error) {
+	if raw == "" {
+		return nil, fmt.Errorf("missing image URL")
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("image URL must be an http or https URL, got %q", raw)
+	}
+
+	if !imageHost(u.Host) {
+		return nil, fmt.Errorf("images from %q are not allowed", u.Host)
+	}
+
+	return u, nil
+}
+
+// Reports whether host is one of imageHosts or a subdomain of one.
+func imageHost(host string) bool {
+	host = strings.ToLower(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	for _, allowed := range imageHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+
+	return false
+}
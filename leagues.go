@@ -0,0 +1,38 @@
+package alexmatchen
+
+import (
+	"net/http"
+	"sort"
+)
+
+func init() {
+	// Leagues of the cached schedule, so users can discover what
+	// ?leagues= can choose from
+	handle("/leagues.json", func(w http.ResponseWriter, r *http.Request) {
+		if err := refreshScheduleIfNeeded(w, r); err != nil {
+			jsonRefreshError(w, err)
+			return
+		}
+
+		writeJSON(w, r, leaguesOf(scheduleAt(cachedSchedule(), now())))
+	})
+}
+
+// Returns the distinct leagues of a schedule in alphabetical order, leaving
+// out matches without a league. The list is empty rather than nil when there
+// are no leagues.
+func leaguesOf(s map[string][]*match) []string {
+	seen := make(map[string]bool)
+	leagues := []string{}
+	for _, matches := range s {
+		for _, m := range matches {
+			if m.League != "" && !seen[m.League] {
+				seen[m.League] = true
+				leagues = append(leagues, m.League)
+			}
+		}
+	}
+
+	sort.Strings(leagues)
+	return leagues
+}
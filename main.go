@@ -3,51 +3,280 @@ package alexmatchen
 import (
 	"appengine"
 	"appengine/urlfetch"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
-	"html/template"
+	"golang.org/x/net/html/charset"
+	"io"
 	"net/http"
+	"net/url"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
 const (
-	daysToShow    = 10
 	cacheDuration = 10 * time.Hour
-	tvmatchenUrl  = "http://www.tvmatchen.nu/"
+	retryDelay    = 5 * time.Minute
+	matchDuration = 2 * time.Hour
+	tvmatchenUrl  = "https://www.tvmatchen.nu/"
+	maxRedirects  = 5
+	dayIDPrefix   = "match-day-"
+
+	// Name of the site the schedule is scraped from, as reported to clients.
+	sourceName = "tvmatchen.nu"
+
+	// Group for matches lacking the value grouped on, e.g. a channel.
+	unknownGroup = "unknown"
+
+	// Upstream lists the matches of the small hours, kicking off before this
+	// hour, at the end of the evening before.
+	nightEnd = 3
+)
+
+// CSS selectors parseSchedule finds the parts of a tvmatchen.nu page with, so
+// operators can follow small upstream markup changes, see the SELECTOR_*
+// settings. Row parts are looked up within each row.
+type pageSelectors struct {
+	// Header of each day, followed by the element holding its match rows.
+	Day string
+
+	// Element within a day header whose id is dayIDPrefix and the date.
+	DayID string
+
+	// Match rows within the element following a day header. Rows are
+	// further narrowed to the scraped sports, see sportSelector.
+	Row string
+
+	// Name of the match, usually "Home - Away".
+	Name string
+
+	// League of the match, with links to its sport and the round, if any.
+	League string
+
+	// One element per channel, named by its title attribute.
+	Channel string
+
+	// Kickoff time, e.g. "20:45".
+	Time string
+
+	// Link to the next page of the schedule, when upstream paginates.
+	NextPage string
+}
+
+// Machine-readable match states, see match.statusAt.
+const (
+	statusScheduled = "scheduled"
+	statusLive      = "live"
+	statusFinished  = "finished"
 )
 
 var (
 	multipleSpaces = regexp.MustCompile(`\s+`)
-	leagues        = []string{"Premier League" /*, "Ligue 1", "Championship", "Allsvenskan"*/}
-	dayNames         = map[string]string{
-		"Monday":    "Måndag",
-		"Tuesday":   "Tisdag",
-		"Wednesday": "Onsdag",
-		"Thursday":  "Torsdag",
-		"Friday":    "Fredag",
-		"Saturday":  "Lördag",
-		"Sunday":    "Söndag",
-	}
-	schedule    map[string][]*match
+	roundPattern   = regexp.MustCompile(`(?i)\b(omgång|round)\s*\d+`)
+	teamSeparator  = regexp.MustCompile(`\s+[-–]\s+`)
+	clockPattern   = regexp.MustCompile(`\b\d{1,2}:\d{2}\b`)
+	jsIdentifier   = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*(\.[A-Za-z_$][A-Za-z0-9_$]*)*$`)
+
+	// The StartsIn field of a match as marshaled, which ETags leave out.
+	// Quotes within strings are escaped, so it can't match within a name.
+	startsInField = regexp.MustCompile(`"StartsIn":-?[0-9]+,?`)
+
+	// Leagues shown when a request doesn't choose its own, unless overridden
+	// on /admin/config, see defaultLeagues. Every league is scraped and
+	// cached regardless, apart from LEAGUE_BLOCKLIST.
+	leagues   = []string{"Premier League" /*, "Ligue 1", "Championship", "Allsvenskan"*/}
+	stockholm = mustLoadLocation("Europe/Stockholm")
+
+	// The clock used by everything time dependent (status, cache expiry).
+	// Tests may replace it to freeze time; production code must not.
+	now = time.Now
+
+	// Wait before trying a failed fetch again, doubling with each retry.
+	// Tests may shorten it.
+	fetchRetryDelay = time.Second
+
+	// Makes the transport upstream pages and listings are fetched with.
+	// Tests may replace it to serve saved pages; production code must not.
+	newTransport = func(c appengine.Context) http.RoundTripper {
+		return &urlfetch.Transport{Context: c, Deadline: fetchTimeout}
+	}
+
+	// The cache, guarded by mu. Refreshes swap in new schedule maps rather
+	// than change them, so a map read under mu stays usable after the lock
+	// is released, but must not be modified. Restoring a stored schedule
+	// swaps it in without refreshMu, so refreshes read the cache under mu
+	// too.
+	schedule map[string][]*match
+
+	// When the schedule was last refreshed successfully, and when a refresh
+	// was last attempted, successful or not.
 	lastRefresh time.Time
-	mu          sync.RWMutex
+	lastAttempt time.Time
+
+	// The schedule replaced by the last successful refresh, see /diff.json.
+	previousSchedule map[string][]*match
+
+	lastRefreshErr error
+
+	// Refreshes failed in a row since the last successful one, which back
+	// off the next retry, see retryBackoff.
+	failedRefreshes int
+
+	mu sync.RWMutex
+
+	// Held for the whole of a refresh or import, so only one runs at a time,
+	// while readers only wait on mu for the final swap. Requests never wait
+	// on it, see restoreSchedule.
+	refreshMu sync.Mutex
 )
 
 type (
 	match struct {
-		Name    string
-		League  string
-		Channel string
+		ID       string
+		Name     string
+		RawName  string
+		Home     string
+		Away     string
+		Sport    string
+		League   string
+		Round    string
+		Channel  string
+		Channels []string
+
+		// What upstream tells about each of Channels, such as its logo and
+		// where to stream it, in the same order. Nil for matches from
+		// listings without it.
+		ChannelInfo []*channelInfo `json:",omitempty"`
+
+		// Channel titles as scraped, before CHANNEL_RENAMES.
+		RawChannel string
+
+		// Where each channel added from another listing than Source was
+		// found, by channel, see mergeListed.
+		ChannelSources map[string]string `json:",omitempty"`
+
+		// Time is the kickoff time shown, in the time zone asked for with
+		// ?tz=, while RawTime is the Stockholm time as listed upstream.
 		Time    string
+		RawTime string
+		Kickoff time.Time
+
+		// The kickoff in UTC, in RFC 3339. Omitted without a known kickoff.
+		KickoffUTC string `json:",omitempty"`
+
+		// Where the match was found: sourceName, or the name of another
+		// listing, see listings.
+		Source string
+
+		// Whether upstream gave a usable kickoff time, rather than "TBD" or
+		// nothing. Matches without one sort last within their day.
+		TimeKnown bool
+
+		Status    string
+		Highlight bool
+
+		// Whether the kickoff is at an hour matches are hardly ever
+		// broadcast, hinting at a time put on the wrong day. Only set for
+		// admins asking for diagnostics, see suspectKickoff.
+		Suspect bool `json:",omitempty"`
+
+		// Whether another match shown kicks off at the same time. Set by
+		// filters.apply, as the filters decide which matches are shown.
+		Clash bool
+
+		// Seconds until kickoff at request time, negative once started.
+		// Omitted for matches without a known kickoff.
+		StartsIn *int64 `json:",omitempty"`
+
+		// Tags of the teams of the match by team name, e.g. a flag, with
+		// ?tags=true. Teams without a tag in TEAM_TAGS are left out.
+		Tags map[string]string `json:",omitempty"`
+
+		// Selectors each field was scraped with, for admins asking for
+		// ?debug=true.
+		Provenance map[string]string `json:",omitempty"`
+
+		// Weekday of the match in each language asked for with ?lang=.
+		WeekdayLabels map[string]string `json:",omitempty"`
+	}
+
+	// A channel a match airs on, by its name in Channels.
+	channelInfo struct {
+		Name string
+
+		// Absolute URLs of the channel's logo, and of a page to watch the
+		// match on, such as a streaming service. Either may be missing.
+		Logo string `json:",omitempty"`
+		Link string `json:",omitempty"`
+	}
+
+	// A single day of the schedule, for rendering days in order.
+	day struct {
+		Date    string
+		Label   string
+		Matches []*match
+
+		// Matches of the day left out by ?maxPerDay on the HTML page.
+		More int `json:",omitempty"`
+	}
+
+	// Sorts matches by kickoff, putting matches without a known kickoff last.
+	byKickoff []*match
+
+	// Envelope for flat lists of matches.
+	matchList struct {
+		Matches     []*match
+		Truncated   bool
+		NextRefresh string
+
+		// Where the matches are scraped from, always sourceName.
+		Source string
+
+		// The filters the matches were chosen with.
+		AppliedFilters *appliedFilters
 	}
 
 	templateData struct {
-		Schedule    map[string][]*match
+		Lang        string
+		Schedule    []*day
 		LastRefresh string
+
+		// Set when every match shown shares the league or channel and the
+		// request asked to hide redundant information.
+		HideLeague  bool
+		HideChannel bool
+
+		// Set when there are no matches because it's the off-season.
+		OffSeason bool
+
+		// Set when matches are grouped under league headings, see
+		// display.CollapseLeagues.
+		CollapseLeagues bool
+
+		// Set when the matches shown are of more than one sport, which are
+		// then named on every row.
+		MixedSports bool
+
+		// The page without ?maxPerDay, linked from days cut short.
+		UnboundedURL string
+
+		// The page in each of TIME_ZONES, see ?tz=, and in each language.
+		TimeZones []*pageLink
+		Languages []*pageLink
+	}
+
+	// A link to the HTML page in another time zone or language.
+	pageLink struct {
+		Name   string
+		URL    string
+		Chosen bool
 	}
 )
 
@@ -56,198 +285,1521 @@ func (m *match) String() string {
 	return fmt.Sprintf("* %s %s (%s, %s)", m.Time, m.Name, m.League, m.Channel)
 }
 
-// Refresh data from TV-matchen.
-func refreshSchedule(w http.ResponseWriter, r *http.Request) {
-	fmt.Printf("Refreshing schedule..")
-	mu.Lock()
-	defer func() {
-		lastRefresh = time.Now()
-		mu.Unlock()
-		fmt.Println("..done")
-	}()
+func (s byKickoff) Len() int      { return len(s) }
+func (s byKickoff) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byKickoff) Less(i, j int) bool {
+	a, b := s[i].Kickoff, s[j].Kickoff
+	if a.IsZero() || b.IsZero() {
+		return !a.IsZero() && b.IsZero()
+	}
 
-	// Fetch remote HTML
-	c := appengine.NewContext(r)
-	client := urlfetch.Client(c)
-	resp, err := client.Get(tvmatchenUrl)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	return a.Before(b)
+}
+
+// Returns the status of the match at time t. Matches without a known kickoff
+// have no status.
+func (m *match) statusAt(t time.Time) string {
+	switch {
+	case m.Kickoff.IsZero():
+		return ""
+	case t.Before(m.Kickoff):
+		return statusScheduled
+	case t.Before(m.end()):
+		return statusLive
+	default:
+		return statusFinished
+	}
+}
+
+// Returns when a match is expected to end: its kickoff plus the duration of
+// its sport in SPORT_DURATIONS, or matchDuration for other sports.
+func (m *match) end() time.Time {
+	d, ok := sportDurations[m.Sport]
+	if !ok {
+		d = matchDuration
 	}
 
-	// Setup parser
-	doc, err := goquery.NewDocumentFromResponse(resp)
+	return m.Kickoff.Add(d)
+}
+
+// Combines a day and a scraped time of day ("20:45") into a kickoff in
+// Swedish local time. Of a time range ("20:45-22:30") the start is used.
+// Returns the zero time if the time can't be parsed, e.g. for TBD or an
+// empty time cell.
+func parseKickoff(day time.Time, clock string) time.Time {
+	tod, err := time.Parse("15:04", clockPattern.FindString(clock))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return time.Time{}
 	}
 
-	schedule = make(map[string][]*match, daysToShow)
+	return time.Date(day.Year(), day.Month(), day.Day(), tod.Hour(), tod.Minute(), 0, 0, stockholm)
+}
 
-	// Parse matches
-	days := doc.Find("h2.day-name")
-	days.Each(func(i int, s *goquery.Selection) {
-		if i >= daysToShow {
-			return
+// Splits a match name in the "Home - Away" form into its teams. Both teams
+// are empty if the name isn't in that form.
+func splitTeams(name string) (home, away string) {
+	teams := teamSeparator.Split(name, 2)
+	if len(teams) != 2 {
+		return "", ""
+	}
+
+	return strings.TrimSpace(teams[0]), strings.TrimSpace(teams[1])
+}
+
+// Returns a stable ID for a match, derived from its day and name so that it
+// survives refreshes even if the channel or time changes.
+func matchID(day time.Time, name string) string {
+	sum := sha1.Sum([]byte(day.Format("2006-01-02") + "|" + strings.ToLower(name)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Returns a copy of the schedule with the request time dependent fields of
+// each match filled in for time t. The cached schedule is never modified, and
+// the copy is empty rather than nil for an empty or missing schedule, so it
+// serializes as {} rather than null.
+func scheduleAt(s map[string][]*match, t time.Time) map[string][]*match {
+	out := make(map[string][]*match, len(s))
+	for day, matches := range s {
+		copies := make([]*match, len(matches))
+		for i, m := range matches {
+			c := *m
+			c.Status = c.statusAt(t.In(stockholm))
+			if !c.Kickoff.IsZero() {
+				startsIn := int64(c.Kickoff.Sub(t) / time.Second)
+				c.StartsIn = &startsIn
+			}
+			copies[i] = &c
 		}
+		out[day] = copies
+	}
 
-		day := s.Find("span.day-name-inner")
-		date, _ := day.Attr("id")
-		date = strings.Replace(date, "match-day-", "", -1)
+	return out
+}
 
-		t, _ := time.Parse("2006-01-02", date)
-		date = t.Format("2006-01-02 - ") + dayNames[t.Format("Monday")]
+// Returns all matches in the schedule as one list in chronological order
+// across the whole window: the days in date order, each sorted by kickoff.
+// Kickoffs lie within their day, so the known ones never decrease, while
+// matches without a known kickoff come last within their own day rather than
+// at the end of the list. The list is empty rather than nil when there are
+// no matches.
+func flatten(s map[string][]*match) []*match {
+	dates := make([]string, 0, len(s))
+	for date := range s {
+		dates = append(dates, date)
+	}
 
-		schedule[date] = []*match{}
+	// Date keys are ISO dates so they sort chronologically
+	sort.Strings(dates)
 
-		matchTable := s.Next()
-		matchTable.Find(".sport-name-fotboll").Each(func(mi int, ms *goquery.Selection) {
-			name := ms.Find(".match-name").Text()
-			league := ms.Find(".league").Text()
+	all := []*match{}
+	for _, date := range dates {
+		matches := append([]*match{}, s[date]...)
+		sort.Stable(byKickoff(matches))
+		all = append(all, matches...)
+	}
 
-			ms.Find(".league").Find("a").Each(func(ai int, as *goquery.Selection) {
-				league = strings.Replace(league, as.Text(), "", -1)
-			})
+	return all
+}
 
-			league = strings.Replace(league, "\n", " ", -1)
-			league = multipleSpaces.ReplaceAllString(league, " ")
-			league = strings.Trim(league, " ")
+// Groups matches by the keys returned for each match, so a match can be in
+// several groups. Matches keep their relative order within a group.
+func groupBy(matches []*match, keys func(*match) []string) map[string][]*match {
+	groups := make(map[string][]*match)
+	for _, m := range matches {
+		for _, k := range keys(m) {
+			groups[k] = append(groups[k], m)
+		}
+	}
 
-			interested := false
+	return groups
+}
 
-			// Check if we are interested in this league
-			for _, l := range leagues {
-				if strings.Contains(league, l) {
-					interested = true
-					break
-				}
+// Returns the days of the schedule in chronological order, with the matches
+// of each day sorted by kickoff.
+func orderedDays(s map[string][]*match) []*day {
+	dates := make([]string, 0, len(s))
+	for date := range s {
+		dates = append(dates, date)
+	}
+
+	// Date keys are ISO dates so they sort chronologically
+	sort.Strings(dates)
+
+	days := make([]*day, len(dates))
+	for i, date := range dates {
+		matches := append([]*match{}, s[date]...)
+		sort.Stable(byKickoff(matches))
+		days[i] = &day{Date: date, Label: date, Matches: matches}
+		if d, ok := dayDate(date); ok {
+			days[i].Label = dayLabel(d, defaultLang)
+		}
+	}
+
+	return days
+}
+
+// Reports whether all matches of the days share a single league, and
+// whether they share a single channel. Both are false without matches.
+func redundantFields(days []*day) (league, channel bool) {
+	var first *match
+	league, channel = true, true
+	for _, d := range days {
+		for _, m := range d.Matches {
+			if first == nil {
+				first = m
+				continue
 			}
 
-			if !interested {
-				return
+			league = league && m.League == first.League
+			channel = channel && m.Channel == first.Channel
+		}
+	}
+
+	if first == nil {
+		return false, false
+	}
+
+	return league, channel
+}
+
+// Reports whether the matches of the days are of more than one known sport.
+func mixedSports(days []*day) bool {
+	sport := ""
+	for _, d := range days {
+		for _, m := range d.Matches {
+			if m.Sport == "" {
+				continue
 			}
 
-			channelElement := ms.Find(".channel .channel-item")
-			channel, _ := channelElement.Attr("title")
+			if sport != "" && m.Sport != sport {
+				return true
+			}
 
-			time := ms.Find(".time .field-content").Text()
+			sport = m.Sport
+		}
+	}
 
-			schedule[date] = append(schedule[date], &match{
-				Name:    name,
-				League:  league,
-				Channel: channel,
-				Time:    time,
-			})
-		})
-	})
+	return false
+}
+
+// Reverses the order of the days and of the matches within each day.
+func reverseDays(days []*day) {
+	for i, j := 0, len(days)-1; i < j; i, j = i+1, j-1 {
+		days[i], days[j] = days[j], days[i]
+	}
+
+	for _, d := range days {
+		reverseMatches(d.Matches)
+	}
 }
 
-// Refreshes the schedule if the cache duration has expired.
-func refreshScheduleIfNeeded(w http.ResponseWriter, r *http.Request) {
-	if elapsed := time.Since(lastRefresh); elapsed > cacheDuration {
-		refreshSchedule(w, r)
+func reverseMatches(matches []*match) {
+	for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+		matches[i], matches[j] = matches[j], matches[i]
 	}
 }
 
-func init() {
-	t := template.New("t")
-	t, err := t.Parse(htmlTemplate)
+// Parses the optional order parameter, reporting whether descending order
+// was requested. Ascending is the default.
+func parseOrder(r *http.Request) (bool, error) {
+	switch v := r.FormValue("order"); v {
+	case "", "asc":
+		return false, nil
+	case "desc":
+		return true, nil
+	default:
+		return false, fmt.Errorf("order must be asc or desc, got %q", v)
+	}
+}
+
+// Parses an optional boolean parameter, false when absent.
+func parseBoolParam(r *http.Request, name string) (bool, error) {
+	v := r.FormValue(name)
+	if v == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false, got %q", name, v)
+	}
+
+	return b, nil
+}
+
+// Parses the optional limit parameter. Zero means no limit.
+func parseLimit(r *http.Request) (int, error) {
+	v := r.FormValue("limit")
+	if v == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("limit must be a positive integer, got %q", v)
+	}
+
+	return n, nil
+}
+
+// Returns the start of the day t falls on in Swedish local time.
+func midnight(t time.Time) time.Time {
+	t = t.In(stockholm)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, stockholm)
+}
+
+// Parses a schedule key, the ISO date of the day such as "2014-05-18".
+func dayDate(key string) (time.Time, bool) {
+	d, err := time.ParseInLocation("2006-01-02", key, stockholm)
+	return d, err == nil
+}
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
 	if err != nil {
 		panic(err)
 	}
 
-	http.HandleFunc("/schedule.json", func(w http.ResponseWriter, r *http.Request) {
-		refreshScheduleIfNeeded(w, r)
+	return loc
+}
+
+// Refresh data from TV-matchen. On failure the previous schedule is kept and
+// the error is returned. A panic while fetching or parsing counts as a
+// failure too, rather than taking down the request with lastRefresh unset.
+// The new schedule is built aside and swapped in at the end, so requests
+// keep being served the previous one meanwhile.
+func refreshSchedule(r *http.Request) (err error) {
+	c := newContext(r)
+	c.Infof("Refreshing schedule")
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	var (
+		merged map[string][]*match
+		stats  *parseStats
+	)
+	defer func() {
+		if p := recover(); p != nil {
+			err = &refreshError{errParseFailed, fmt.Errorf("panic while refreshing: %v", p)}
+		}
+
+		mu.Lock()
+		lastAttempt = now()
+		lastRefreshErr = err
+		if err == nil {
+			failedRefreshes = 0
+			previousSchedule = schedule
+			schedule = merged
+			lastParseStats = stats
+			lastRefresh = lastAttempt
+			invalidateSnapshots()
+		} else {
+			failedRefreshes++
+			recordRefreshError(lastAttempt, err)
+		}
+
+		mu.Unlock()
+		if err == nil {
+			storeSchedule(c, merged, lastRefresh)
+		}
 
-		js, err := json.Marshal(schedule)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			c.Errorf("Refreshing schedule failed: %v", err)
 		}
+	}()
 
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		w.Write(js)
-	})
+	// The other listings don't depend on tvmatchen.nu, so they're fetched
+	// alongside it. Their matches are still merged after the scraped ones
+	// whichever finishes first.
+	var (
+		source     string
+		fresh      map[string][]*match
+		listed     = make([][]*match, len(listings))
+		listedErrs = make([]error, len(listings))
+	)
+	// Only the client is tied to urlfetch, the scraping itself isn't
+	client := fetchClient(c)
+	tasks := []func(){func() { source, fresh, stats, err = fetchPrimary(c, client) }}
+	for i, l := range listings {
+		i, l := i, l
+		tasks = append(tasks, func() { listed[i], listedErrs[i] = l.fetch(c) })
+	}
+
+	runConcurrently(fetchConcurrency, tasks...)
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		refreshScheduleIfNeeded(w, r)
+	if err != nil {
+		return err
+	}
+
+	c.Infof("Fetched %d matches from %s", countMatches(fresh), source)
+
+	// Guard against upstream rendering only part of the page. An empty page
+	// is expected in the off-season though.
+	prev := cachedSchedule()
+	if prevCount, count := countMatches(prev), countMatches(fresh); float64(count) < dropThreshold*float64(prevCount) && !offSeasonEmpty(fresh) {
+		c.Warningf("Suspected partial scrape: %d matches, down from %d", count, prevCount)
+		if keepOnDrop {
+			return &refreshError{errParseFailed, fmt.Errorf("match count dropped from %d to %d, keeping previous schedule", prevCount, count)}
+		}
+	}
+
+	for i, l := range listings {
+		addListing(c, fresh, l, listed[i], listedErrs[i])
+	}
 
-		templateData := &templateData{Schedule: schedule, LastRefresh: lastRefresh.Format(time.RFC3339)}
-		err = t.Execute(w, templateData)
+	// The merge builds a new map, swapped in whole under mu once the
+	// refresh is done. The cached map is never changed in place.
+	merged = mergeSchedules(prev, fresh, midnight(now()))
+	return nil
+}
 
+// Fetches and parses the schedule from the primary source, falling back to
+// origin when it fails or comes back empty, with client. Returns the source
+// used.
+func fetchPrimary(c appengine.Context, client *http.Client) (string, map[string][]*match, *parseStats, error) {
+	source := primaryURL
+	fresh, stats, err := fetchSchedule(c, client, source)
+	if (err != nil || countMatches(fresh) == 0) && originURL != primaryURL {
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			c.Warningf("Fetching from %s failed, falling back to %s: %v", primaryURL, originURL, err)
+		} else {
+			c.Warningf("No matches from %s, falling back to %s", primaryURL, originURL)
+		}
+
+		source = originURL
+		fresh, stats, err = fetchSchedule(c, client, source)
+	}
+
+	return source, fresh, stats, err
+}
+
+// Runs tasks on at most n goroutines at a time and waits for all of them.
+// A panic in a task is raised again in the caller once every task is done,
+// so it's recovered like any other failure of the refresh.
+func runConcurrently(n int, tasks ...func()) {
+	var (
+		wg       sync.WaitGroup
+		panicked interface{}
+		panicMu  sync.Mutex
+	)
+
+	slots := make(chan bool, n)
+	for _, task := range tasks {
+		wg.Add(1)
+		slots <- true
+		go func(task func()) {
+			defer func() {
+				if p := recover(); p != nil {
+					panicMu.Lock()
+					panicked = p
+					panicMu.Unlock()
+				}
+
+				<-slots
+				wg.Done()
+			}()
+
+			task()
+		}(task)
+	}
+
+	wg.Wait()
+	if panicked != nil {
+		panic(panicked)
+	}
+}
+
+// Fetches and parses the schedule at source with client. When upstream
+// splits the schedule across pages, next page links are followed until
+// daysToShow days are found or maxPages pages are fetched. Failures after the
+// first page only stop the paging.
+func fetchSchedule(c appengine.Context, client *http.Client, source string) (map[string][]*match, *parseStats, error) {
+	days := shownDays()
+	fresh := make(map[string][]*match, days)
+	stats := newParseStats()
+	page := source
+	for n := 0; n < maxPages && page != ""; n++ {
+		if n > 0 {
+			c.Infof("Following next page link to %s", page)
+		}
+
+		doc, err := fetchPageRetrying(c, client, page)
+		if err != nil {
+			if n == 0 {
+				return nil, nil, err
+			}
+
+			c.Warningf("Stopped paging at page %d: %v", n+1, err)
+			break
+		}
+
+		s, st := parseSchedule(c, doc)
+		appendSchedule(fresh, s)
+		stats.add(st)
+		if len(fresh) >= days {
+			break
 		}
+
+		// A page linking to itself would otherwise be fetched maxPages times
+		if next := nextPageURL(doc); next != page {
+			page = next
+		} else {
+			page = ""
+		}
+	}
+
+	// Pages may add up to more days than are shown
+	if len(fresh) > days {
+		dates := make([]string, 0, len(fresh))
+		for date := range fresh {
+			dates = append(dates, date)
+		}
+
+		sort.Strings(dates)
+		for _, date := range dates[days:] {
+			delete(fresh, date)
+		}
+	}
+
+	return fresh, stats, nil
+}
+
+// Returns the client upstream pages are fetched with, giving up on a fetch
+// after FETCH_TIMEOUT and following redirects within tvmatchen.nu and the
+// configured mirrors only, see checkRedirect.
+func fetchClient(c appengine.Context) *http.Client {
+	return fetchClientFor(c, isUpstreamHost)
+}
+
+// Like fetchClient, following redirects to the hosts allowed reports true
+// for, such as those of another listing.
+func fetchClientFor(c appengine.Context, allowed func(host string) bool) *http.Client {
+	client := &http.Client{Transport: newTransport(c)}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return checkRedirect(c, req, via, allowed)
+	}
+
+	return client
+}
+
+// Fetches a page like fetchPage, trying again when the fetch fails, see
+// retryFetch.
+func fetchPageRetrying(c appengine.Context, client *http.Client, source string) (*goquery.Document, error) {
+	var doc *goquery.Document
+	err := retryFetch(c, source, func() (err error) {
+		doc, err = fetchPage(client, source)
+		return err
 	})
+
+	return doc, err
 }
 
-const (
-	htmlTemplate = `
-<html>
-	<head>
-		<title>Match på TV:n</title>
-	    <meta charset="utf-8" />
-	    <link rel="shortcut icon" href="/favicon.ico" type="image/x-icon">
-		<link rel="icon" href="/favicon.ico" type="image/x-icon">
-	    <link href='http://fonts.googleapis.com/css?family=Open+Sans' rel='stylesheet' type='text/css'>
-	    <style type="text/css">
-	    	body {
-	    		background: #efefef;
-	    		color: #333333;
-	    		font-family: 'Open Sans', arial;
-	    	}
-
-	    	ul {
-	    		list-style: none;
-	    		margin: 0;
-	    		padding: 0;
-	    	}
-
-	    	h2 {
-	    		margin: 5px 0;
-	    	}
-
-	    	li {
-	    		font-size: 14px;
-	    		padding: 3px 0;
-	    	}
-
-	    	em {
-	    		font-size: 10px;
-	    	}
-
-    		.time {
-    			color: #c5752a;
-    		}
-
-    		.league-channel {
-    			color: #575e5b;
-    		}
-
-    		@media all and (max-width: 500px) {
-			  .league-channel {
-			  	display: block;
-			  }
-			}
-	    </style>
-	</head>
-	<body>
-		Fotboll på TV:n.
-		
-		{{range $day, $matches := .Schedule}}
-			<h2>{{ $day }}</h2>
-			<ul>
-				{{range $match := $matches}}
-					<li>
-						<span class="time">{{$match.Time}}</span>
-						<span class="name">{{$match.Name}}</span>
-						<span class="league-channel">({{$match.League}}, {{$match.Channel}})</span>
-					</li>
-				{{end}}
-			</ul>
-		{{end}}
-
-		<em>Uppdaterad {{.LastRefresh}}</em>
-	</body>
-</html>
-`
-)
+// Runs a fetch of source, trying again up to FETCH_RETRIES times while it
+// fails with errFetchFailed, after fetchRetryDelay doubled with each retry.
+// Other failures, such as a page that can't be parsed, aren't tried again.
+func retryFetch(c appengine.Context, source string, fetch func() error) error {
+	for retry := 0; ; retry++ {
+		err := fetch()
+		re, ok := err.(*refreshError)
+		if err == nil || !ok || re.Code != errFetchFailed || retry >= fetchRetries {
+			return err
+		}
+
+		c.Warningf("Fetching %s failed, trying again: %v", source, err)
+		time.Sleep(fetchRetryDelay << uint(retry))
+	}
+}
+
+// Fetches and parses the page at source, transcoding it to UTF-8 from the
+// charset in its Content-Type header or meta tags. Pages in unknown charsets
+// are parsed as UTF-8.
+func fetchPage(client *http.Client, source string) (*goquery.Document, error) {
+	req, err := http.NewRequest("GET", source, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &refreshError{errFetchFailed, err}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &refreshError{errFetchFailed, fmt.Errorf("unexpected status from %s: %s", source, resp.Status)}
+	}
+
+	defer resp.Body.Close()
+
+	var body io.Reader = resp.Body
+	if utf8Body, err := charset.NewReader(resp.Body, resp.Header.Get("Content-Type")); err == nil {
+		body = utf8Body
+	}
+
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return nil, &refreshError{errParseFailed, err}
+	}
+
+	// Relative next page links resolve against the final URL
+	if resp.Request != nil {
+		doc.Url = resp.Request.URL
+	}
+
+	return doc, nil
+}
+
+// Returns the absolute URL of the next page link of a page, or "" if it has
+// none.
+func nextPageURL(doc *goquery.Document) string {
+	href, ok := doc.Find(selectors.NextPage).First().Attr("href")
+	if !ok || strings.TrimSpace(href) == "" || doc.Url == nil {
+		return ""
+	}
+
+	u, err := doc.Url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return ""
+	}
+
+	return u.String()
+}
+
+// Adds the matches of src to dst, skipping matches dst already has, as pages
+// may overlap.
+func appendSchedule(dst, src map[string][]*match) {
+	for date, matches := range src {
+		seen := make(map[string]bool, len(dst[date]))
+		for _, m := range dst[date] {
+			seen[m.ID] = true
+		}
+
+		if dst[date] == nil {
+			dst[date] = []*match{}
+		}
+
+		for _, m := range matches {
+			if !seen[m.ID] {
+				dst[date] = append(dst[date], m)
+			}
+		}
+
+		sort.Stable(byKickoff(dst[date]))
+	}
+}
+
+// Follows at most maxRedirects redirects, and only to hosts allowed reports
+// true for, so a moved site can't silently feed us someone else's content.
+func checkRedirect(c appengine.Context, req *http.Request, via []*http.Request, allowed func(host string) bool) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", len(via))
+	}
+
+	if !allowed(strings.ToLower(req.URL.Host)) {
+		return fmt.Errorf("refusing redirect to unexpected host %q", req.URL.Host)
+	}
+
+	c.Warningf("Upstream redirected %s to %s", via[len(via)-1].URL, req.URL)
+	return nil
+}
+
+// Reports whether host is a tvmatchen.nu host, or the host of a configured
+// mirror.
+func isUpstreamHost(host string) bool {
+	return host == "tvmatchen.nu" || strings.HasSuffix(host, ".tvmatchen.nu") || isSourceHost(host)
+}
+
+// Reports whether host is that of primaryURL or originURL.
+func isSourceHost(host string) bool {
+	for _, source := range []string{primaryURL, originURL} {
+		if u, err := url.Parse(source); err == nil && strings.ToLower(u.Host) == host {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Parses the schedule out of a tvmatchen.nu page, skipping days it can't
+// make sense of. Also returns diagnostics of what was found and skipped.
+func parseSchedule(c appengine.Context, doc *goquery.Document) (map[string][]*match, *parseStats) {
+	maxDays := shownDays()
+	fresh := make(map[string][]*match, maxDays)
+	cleanedLeagues := make(map[string]struct{ league, round string })
+	stats := newParseStats()
+	rowSelector := sportSelector()
+
+	// Parse matches
+	days := doc.Find(selectors.Day)
+	stats.DaysSeen = days.Length()
+	stats.SelectorHits[selectors.Day] = days.Length()
+	days.Each(func(i int, s *goquery.Selection) {
+		if i >= maxDays {
+			stats.Skipped[skipDayLimit]++
+			return
+		}
+
+		// Every lookup below is scoped to the day's own match table, which
+		// is walked once, rather than to the whole document
+		matchTable := s.Next()
+		rows := matchTable.Find(selectors.Row)
+		stats.RowsSeen += rows.Length()
+		stats.SelectorHits[selectors.Row] += rows.Length()
+
+		day := s.Find(selectors.DayID)
+		stats.SelectorHits[selectors.DayID] += day.Length()
+		id, ok := day.Attr("id")
+		if !ok || !strings.HasPrefix(id, dayIDPrefix) {
+			c.Warningf("Skipping day %d without a %q id: %q", i, dayIDPrefix, id)
+			stats.Skipped[skipDayIDMissing]++
+			return
+		}
+
+		t, err := time.Parse("2006-01-02", strings.TrimPrefix(id, dayIDPrefix))
+		if err != nil {
+			c.Warningf("Skipping day %d with malformed id %q: %v", i, id, err)
+			stats.Skipped[skipDayIDMalformed]++
+			return
+		}
+
+		stats.DaysParsed++
+
+		// Labels are added when rendering, so the stored key is just the date
+		date := t.Format("2006-01-02")
+
+		// The day may already hold matches of the small hours listed under
+		// the day before
+		if _, ok := fresh[date]; !ok {
+			fresh[date] = []*match{}
+		}
+
+		sportRows := rows.Filter(rowSelector)
+		stats.SelectorHits[rowSelector] += sportRows.Length()
+		if skipped := rows.Length() - sportRows.Length(); skipped > 0 {
+			stats.Skipped[skipOtherSport] += skipped
+		}
+		stats.RowsParsed += sportRows.Length()
+		var latest time.Time
+		sportRows.Each(func(mi int, ms *goquery.Selection) {
+			sport := sportOf(ms)
+			rawName := ms.Find(selectors.Name).Text()
+			name := normalizeText(rawName)
+			home, away := splitTeams(name)
+
+			// Most rows of a day share a handful of league cells, so only
+			// clean up each distinct cell once
+			leagueCell := ms.Find(selectors.League)
+			var links []string
+			leagueCell.Find("a").Each(func(ai int, as *goquery.Selection) {
+				links = append(links, as.Text())
+			})
+
+			key := leagueCell.Text() + "\x00" + strings.Join(links, "\x00")
+			cleaned, ok := cleanedLeagues[key]
+			if !ok {
+				cleaned.league, cleaned.round = cleanLeague(leagueCell.Text(), links)
+				cleanedLeagues[key] = cleaned
+			}
+			league, round := cleaned.league, cleaned.round
+			if blockedLeague(league) {
+				stats.RowsParsed--
+				stats.Skipped[skipBlockedLeague]++
+				return
+			}
+
+			// Channels are named by their title attribute, or by their text
+			// for the odd item without one
+			titles := []string{}
+			scraped := []*channelInfo{}
+			ms.Find(selectors.Channel).Each(func(ci int, cs *goquery.Selection) {
+				title, ok := cs.Attr("title")
+				if !ok {
+					title = cs.Text()
+				}
+
+				if title = normalizeText(title); title != "" {
+					titles = append(titles, title)
+					scraped = append(scraped, channelDetails(doc, cs, title))
+				}
+			})
+			channels := renameChannels(titles)
+
+			rawTime := ms.Find(selectors.Time).Text()
+			clock := normalizeText(rawTime)
+			kickoff := parseKickoff(t, clock)
+
+			// A small hours kickoff listed after a later one of the day is
+			// past midnight, and the match belongs to the next day
+			kickoffDate := date
+			switch {
+			case kickoff.IsZero():
+			case kickoff.Before(latest) && kickoff.Hour() < nightEnd:
+				kickoff = kickoff.AddDate(0, 0, 1)
+				kickoffDate = kickoff.Format("2006-01-02")
+			case kickoff.After(latest):
+				latest = kickoff
+			}
+
+			if suspectKickoff(kickoff) {
+				c.Warningf("Suspect kickoff %s for %q, the time may belong to another day", kickoff.Format(time.RFC3339), name)
+				stats.Suspect++
+			}
+
+			fresh[kickoffDate] = append(fresh[kickoffDate], &match{
+				ID:          matchID(t, name),
+				Name:        name,
+				RawName:     rawName,
+				Home:        home,
+				Away:        away,
+				Sport:       sport,
+				League:      league,
+				Round:       round,
+				Channel:     strings.Join(channels, ", "),
+				Channels:    channels,
+				ChannelInfo: renameChannelInfo(scraped),
+				RawChannel:  strings.Join(titles, ", "),
+				Time:        clock,
+				RawTime:     rawTime,
+				Kickoff:     kickoff,
+				Source:      sourceName,
+				TimeKnown:   !kickoff.IsZero(),
+			})
+		})
+	})
+
+	for _, matches := range fresh {
+		sort.Stable(byKickoff(matches))
+	}
+
+	return fresh, stats
+}
+
+// Merges a freshly parsed schedule into the previous one, day by day. A
+// day's matches are only replaced when the fresh parse found matches for it,
+// so days missing from a truncated page keep their last known matches. Days
+// before today are dropped. Neither input is modified, and the merged map
+// only shares the day slices, which are never changed once stored, so the
+// cached schedule can be merged from while it's being served.
+func mergeSchedules(prev, fresh map[string][]*match, today time.Time) map[string][]*match {
+	merged := make(map[string][]*match, len(fresh))
+	for date, matches := range prev {
+		if d, ok := dayDate(date); ok && !d.Before(today) {
+			merged[date] = matches
+		}
+	}
+
+	for date, matches := range fresh {
+		if _, ok := merged[date]; !ok || len(matches) > 0 {
+			merged[date] = matches
+		}
+	}
+
+	return merged
+}
+
+// Cleans up the text of a league cell, removing the text of its links and
+// normalizing whitespace. Round info such as "Omgång 34" is split off.
+func cleanLeague(text string, links []string) (league, round string) {
+	league = text
+	for _, link := range links {
+		league = strings.Replace(league, link, "", -1)
+	}
+
+	league = normalizeText(league)
+
+	round = roundPattern.FindString(league)
+	if round != "" {
+		league = strings.Trim(normalizeText(strings.Replace(league, round, "", 1)), " ,")
+	}
+
+	return league, round
+}
+
+// Collapses runs of whitespace, including newlines, into single spaces and
+// trims the ends. Scraped text and filter parameters both go through this so
+// that they compare equal regardless of spacing.
+func normalizeText(s string) string {
+	return strings.TrimSpace(multipleSpaces.ReplaceAllString(s, " "))
+}
+
+// Reports whether a league is in LEAGUE_BLOCKLIST, matching like the leagues
+// filter on a case insensitive substring.
+func blockedLeague(league string) bool {
+	return len(leagueBlocklist) > 0 && containsAny(league, leagueBlocklist)
+}
+
+// Replaces channel titles with their display names in CHANNEL_RENAMES,
+// dropping titles renamed to a channel already listed. Other titles are kept
+// as they are.
+func renameChannels(titles []string) []string {
+	seen := make(map[string]bool, len(titles))
+	channels := []string{}
+	for _, title := range titles {
+		channel := title
+		if renamed, ok := channelRenames[title]; ok {
+			channel = renamed
+		}
+
+		if !seen[channel] {
+			seen[channel] = true
+			channels = append(channels, channel)
+		}
+	}
+
+	return channels
+}
+
+// Like renameChannels for the details of channels, keeping the logo and
+// link of a renamed channel from whichever title had them.
+func renameChannelInfo(scraped []*channelInfo) []*channelInfo {
+	byName := make(map[string]*channelInfo, len(scraped))
+	infos := []*channelInfo{}
+	for _, s := range scraped {
+		name := s.Name
+		if renamed, ok := channelRenames[name]; ok {
+			name = renamed
+		}
+
+		info, ok := byName[name]
+		if !ok {
+			info = &channelInfo{Name: name}
+			byName[name] = info
+			infos = append(infos, info)
+		}
+
+		if info.Logo == "" {
+			info.Logo = s.Logo
+		}
+
+		if info.Link == "" {
+			info.Link = s.Link
+		}
+	}
+
+	return infos
+}
+
+// Returns what a channel item of a page tells about the channel titled
+// title: the image in or of the item as its logo, and the link it is or is
+// in as where to watch.
+func channelDetails(doc *goquery.Document, item *goquery.Selection, title string) *channelInfo {
+	info := &channelInfo{Name: title}
+
+	img := item.Filter("img")
+	if img.Length() == 0 {
+		img = item.Find("img").First()
+	}
+
+	if src, ok := img.Attr("src"); ok {
+		info.Logo = absoluteURL(doc, src)
+	}
+
+	link := item.Filter("a")
+	if link.Length() == 0 {
+		link = item.Find("a").First()
+	}
+
+	if link.Length() == 0 {
+		link = item.Closest("a")
+	}
+
+	if href, ok := link.Attr("href"); ok {
+		info.Link = absoluteURL(doc, href)
+	}
+
+	return info
+}
+
+// Returns a reference of a page as an absolute http or https URL, or "" if
+// it isn't one. Pages without a URL resolve against tvmatchen.nu.
+func absoluteURL(doc *goquery.Document, ref string) string {
+	base := doc.Url
+	if base == nil {
+		base, _ = url.Parse(tvmatchenUrl)
+	}
+
+	u, err := base.Parse(strings.TrimSpace(ref))
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+
+	return u.String()
+}
+
+// Returns a selector matching the rows of every scraped sport, e.g.
+// ".sport-name-fotboll, .sport-name-ishockey".
+func sportSelector() string {
+	classes := make([]string, 0, len(sports))
+	for class := range sports {
+		classes = append(classes, ".sport-name-"+class)
+	}
+
+	sort.Strings(classes)
+	return strings.Join(classes, ", ")
+}
+
+// Returns the display name of the sport of a match row.
+func sportOf(row *goquery.Selection) string {
+	for class, name := range sports {
+		if row.HasClass("sport-name-" + class) {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// Returns the total number of matches in a schedule.
+func countMatches(s map[string][]*match) int {
+	n := 0
+	for _, matches := range s {
+		n += len(matches)
+	}
+
+	return n
+}
+
+// Returns when the schedule is next due to be refreshed: cacheDuration after
+// the last refresh, or after a failed one the backoff of the failures so far.
+func nextRefresh() time.Time {
+	mu.RLock()
+	defer mu.RUnlock()
+	if lastRefreshErr != nil {
+		return lastAttempt.Add(retryBackoff(failedRefreshes))
+	}
+
+	return lastRefresh.Add(refreshInterval())
+}
+
+// Returns how long to wait before retrying after n failed refreshes in a
+// row: retryDelay, doubled for every failure after the first, but never
+// longer than cacheDuration, so upstream being down for hours isn't hit
+// every few minutes.
+func retryBackoff(n int) time.Duration {
+	delay, limit := retryDelay, refreshInterval()
+	for i := 1; i < n && delay < limit; i++ {
+		delay *= 2
+	}
+
+	if delay > limit {
+		return limit
+	}
+
+	return delay
+}
+
+// Refreshes the schedule if the cache duration has expired, or the retry
+// delay after a failed refresh. A failed refresh is only returned if there is
+// no previous schedule to serve instead, or the previous one is older than
+// maxDataAge. Otherwise the freshness headers of the schedule served are set
+// on w. Without REFRESH_ON_REQUEST the cached schedule is served as it is,
+// and an error returned until cron has loaded one.
+func refreshScheduleIfNeeded(w http.ResponseWriter, r *http.Request) error {
+	// Cached schedules show up as a refresh of next to nothing
+	defer recordTiming(w, "refresh", time.Now())
+
+	// Another instance may have refreshed since the last check, or this one
+	// may have just started
+	if storeCheckDue() {
+		restoreSchedule(r)
+	}
+
+	if refreshOnRequest && now().After(nextRefresh()) {
+		if err := refreshSchedule(r); err != nil && cachedSchedule() == nil {
+			return err
+		}
+	}
+
+	if cachedSchedule() == nil {
+		return &refreshError{errNotLoaded, fmt.Errorf("schedule not loaded yet, waiting for the first refresh")}
+	}
+
+	// Fail closed rather than serve a schedule that's misleadingly old
+	last, lastErr := refreshStatus()
+	if age := now().Sub(last); age > maxDataAge {
+		return &refreshError{errStale, fmt.Errorf("schedule is %v old, last refresh error: %v", age, lastErr)}
+	}
+
+	setFreshnessHeaders(w)
+	return nil
+}
+
+// Tells clients when the schedule was last refreshed, and flags it as stale
+// when the last refresh failed or is older than cacheDuration, as the last
+// known schedule is served either way. Stale responses carry the standard
+// Warning header too. An empty off-season schedule is never flagged. As
+// labels are in the language of Accept-Language unless ?lang= is given,
+// responses vary with it.
+func setFreshnessHeaders(w http.ResponseWriter) {
+	w.Header().Add("Vary", "Accept-Language")
+
+	last, lastErr := refreshStatus()
+	w.Header().Set("X-Last-Refresh", last.Format(time.RFC3339))
+	if (lastErr != nil || now().Sub(last) > refreshInterval()) && !offSeasonEmpty(cachedSchedule()) {
+		w.Header().Set("X-Data-Stale", "true")
+		w.Header().Set("Warning", `110 - "Response is Stale"`)
+	}
+}
+
+// Returns the cached schedule, read under mu. It must not be modified, see
+// scheduleAt for a copy that may be.
+func cachedSchedule() map[string][]*match {
+	mu.RLock()
+	defer mu.RUnlock()
+	return schedule
+}
+
+// Returns when the schedule was last refreshed successfully and the error of
+// the last attempt, if it failed, read under mu.
+func refreshStatus() (time.Time, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return lastRefresh, lastRefreshErr
+}
+
+// Returns the URL of a request with a trailing slash removed from its path,
+// if that is the path of an endpoint, e.g. /schedule.json for
+// /schedule.json/, keeping the query.
+func withoutTrailingSlash(r *http.Request) (string, bool) {
+	path := strings.TrimRight(r.URL.Path, "/")
+	if path == r.URL.Path || path == "" {
+		return "", false
+	}
+
+	probe := &http.Request{Method: r.Method, Host: r.Host, URL: &url.URL{Path: path}}
+	if _, pattern := http.DefaultServeMux.Handler(probe); pattern != path {
+		return "", false
+	}
+
+	u := *r.URL
+	u.Path = path
+	return u.String(), true
+}
+
+// Writes matches as newline delimited JSON, one match per line, encoding
+// each as it's written rather than the whole list up front. There is no
+// envelope, and no JSONP. A non-nil field set limits the fields written, see
+// parseFields.
+func writeNDJSON(w http.ResponseWriter, matches []*match, fields map[string]bool) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	for _, m := range matches {
+		var v interface{} = m
+		if fields != nil {
+			v = projectMatch(m, fields)
+		}
+
+		if err := enc.Encode(v); err != nil {
+			return
+		}
+	}
+}
+
+// Writes v as JSON, or as JSONP when the request has a callback parameter.
+// Callbacks that aren't plain JavaScript identifiers are rejected. Matches
+// are stripped down to the fields parameter, if any, see parseFields.
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	writeJSONStatus(w, r, http.StatusOK, v)
+}
+
+// Like writeJSON, with the given response status.
+func writeJSONStatus(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	start := time.Now()
+	js, err := json.Marshal(v)
+	recordTiming(w, "render", start)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, errInternal, err.Error())
+		return
+	}
+
+	writeJSONBytes(w, r, status, js)
+}
+
+// Like writeJSONStatus, for an already marshaled value.
+func writeJSONBytes(w http.ResponseWriter, r *http.Request, status int, js []byte) {
+	callback := r.FormValue("callback")
+	if callback != "" && !jsIdentifier.MatchString(callback) {
+		jsonError(w, http.StatusBadRequest, errBadRequest, "invalid callback")
+		return
+	}
+
+	fields, err := parseFields(r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+		return
+	}
+
+	if fields != nil {
+		if js, err = projectJSON(js, fields); err != nil {
+			jsonError(w, http.StatusInternalServerError, errInternal, err.Error())
+			return
+		}
+	}
+
+	body, contentType := js, "application/json; charset=utf-8"
+	if callback != "" {
+		body, contentType = []byte(fmt.Sprintf("%s(%s);", callback, js)), "application/javascript; charset=utf-8"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if status == http.StatusOK && validate(w, r, body) {
+		return
+	}
+
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// Sets the validators of a successful response: a strong ETag hashing the
+// body, and Last-Modified at the last refresh. Answers 304 Not Modified and
+// reports true when the request's If-None-Match has the ETag already.
+// If-Modified-Since alone is never answered with a 304, as statuses change
+// between refreshes.
+//
+// StartsIn is left out of the hash, as it changes every second and would
+// leave pollers without a single 304. Clients revalidating keep the StartsIn
+// of their copy, so should count down from Kickoff instead.
+func validate(w http.ResponseWriter, r *http.Request, body []byte) bool {
+	sum := sha1.Sum(startsInField.ReplaceAll(body, nil))
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	w.Header().Set("ETag", etag)
+	if last, _ := refreshStatus(); !last.IsZero() {
+		w.Header().Set("Last-Modified", last.UTC().Format(http.TimeFormat))
+	}
+
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		// Weak comparison, as proxies compressing the body weaken the tag
+		if tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/"); tag == etag || tag == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Writes a 404 response, as JSON if the client accepts it and HTML otherwise.
+func notFound(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		jsonError(w, http.StatusNotFound, errNotFound, "no such path: "+r.URL.Path)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprint(w, notFoundPage)
+}
+
+func init() {
+	handle("/schedule.json", func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseFilters(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		d, err := parseDisplay(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		debug, err := wantsDebug(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		cursor, pageDays, paged, err := parsePage(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		grouping, err := parseGroupBy(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		if paged && grouping != groupByDay {
+			jsonError(w, http.StatusBadRequest, errBadRequest, "pages can only be grouped by day")
+			return
+		}
+
+		// The object keyed by date already lists days in date order, as
+		// encoding/json sorts map keys, but clients whose parsers don't keep
+		// key order can ask for an array of days with ?format=days
+		var listed bool
+		switch v := r.FormValue("format"); v {
+		case "", "json":
+		case "days":
+			listed = true
+		default:
+			jsonError(w, http.StatusBadRequest, errBadRequest, fmt.Sprintf("format must be json or days, got %q", v))
+			return
+		}
+
+		if err := refreshScheduleIfNeeded(w, r); err != nil {
+			jsonRefreshError(w, err)
+			return
+		}
+
+		js, err := renderSnapshot(r, func() ([]byte, error) {
+			start := time.Now()
+			s := f.apply(d.format(scheduleAt(cachedSchedule(), now())))
+			if debug {
+				addProvenance(s)
+				flagSuspects(s)
+			}
+			recordTiming(w, "filter", start)
+			defer recordTiming(w, "render", time.Now())
+
+			// Pages, weeks and slots are lists of days rather than a map keyed
+			// by date
+			if paged {
+				return json.Marshal(pageOf(d.pinPreferred(d.labelDays(orderedDays(s))), cursor, pageDays))
+			}
+
+			switch grouping {
+			case groupByWeek:
+				return json.Marshal(weeksOf(d.pinPreferred(d.labelDays(orderedDays(s)))))
+			case groupByTimeslot:
+				return json.Marshal(d.pinSlots(timeslotsOf(d.labelDays(orderedDays(s)))))
+			case groupByLeague:
+				return json.Marshal(leagueGroupsOf(d.pinPreferred(d.labelDays(orderedDays(s)))))
+			}
+
+			if listed {
+				return json.Marshal(d.pinPreferred(d.labelDays(orderedDays(s))))
+			}
+
+			d.order(s)
+			if debug {
+				return json.Marshal(withDebug(s))
+			}
+
+			return json.Marshal(s)
+		})
+		if err != nil {
+			jsonError(w, http.StatusInternalServerError, errInternal, err.Error())
+			return
+		}
+
+		writeJSONBytes(w, r, http.StatusOK, js)
+	})
+
+	handle("/matches.json", listMatches)
+	handle("/matches", listMatches)
+
+	handle("/by-channel.json", func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseFilters(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		d, err := parseDisplay(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		if err := refreshScheduleIfNeeded(w, r); err != nil {
+			jsonRefreshError(w, err)
+			return
+		}
+
+		matches := d.flatten(f.apply(d.format(scheduleAt(cachedSchedule(), now()))), false)
+		writeJSON(w, r, groupBy(matches, func(m *match) []string {
+			if len(m.Channels) > 0 {
+				return m.Channels
+			}
+
+			return []string{unknownGroup}
+		}))
+	})
+
+	handle("/", func(w http.ResponseWriter, r *http.Request) {
+		// The root pattern catches every path without a handler of its own
+		if r.URL.Path != "/" {
+			if canonical, ok := withoutTrailingSlash(r); ok {
+				http.Redirect(w, r, canonical, http.StatusMovedPermanently)
+				return
+			}
+
+			notFound(w, r)
+			return
+		}
+
+		f, err := parseFilters(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		d, err := parseDisplay(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		desc, err := parseOrder(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		t, ok := views[r.FormValue("view")]
+		if !ok {
+			http.Error(w, "unknown view", http.StatusBadRequest)
+			return
+		}
+
+		// Grouping by league is the league headings of ?collapseLeagues=true
+		switch grouping, err := parseGroupBy(r); {
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case grouping == groupByLeague:
+			d.CollapseLeagues = true
+		case grouping != groupByDay:
+			http.Error(w, "the page can only be grouped by day or league", http.StatusBadRequest)
+			return
+		}
+
+		if err := refreshScheduleIfNeeded(w, r); err != nil {
+			http.Error(w, err.Error(), refreshErrorStatus(err))
+			return
+		}
+
+		page, err := renderSnapshot(r, func() ([]byte, error) {
+			start := time.Now()
+			cached := cachedSchedule()
+			days := d.labelDays(orderedDays(f.apply(d.format(scheduleAt(cached, now())))))
+			recordTiming(w, "filter", start)
+			defer recordTiming(w, "render", time.Now())
+
+			if desc {
+				reverseDays(days)
+			}
+
+			d.pinPreferred(days)
+			d.collapseLeagues(days)
+			d.truncateDays(days)
+
+			last, _ := refreshStatus()
+			templateData := &templateData{Lang: d.lang(), Schedule: days, LastRefresh: d.stamp(last), OffSeason: offSeasonEmpty(cached), CollapseLeagues: d.CollapseLeagues, MixedSports: mixedSports(days)}
+			templateData.TimeZones = zoneLinks(r, d.zone())
+			templateData.Languages = langLinks(r, d.lang())
+			if d.MaxPerDay > 0 {
+				q := r.URL.Query()
+				q.Del("maxPerDay")
+				templateData.UnboundedURL = (&url.URL{Path: "/", RawQuery: q.Encode()}).String()
+			}
+			if d.HideRedundant {
+				templateData.HideLeague, templateData.HideChannel = redundantFields(days)
+			}
+
+			var b bytes.Buffer
+			if err := t.Execute(&b, templateData); err != nil {
+				return nil, err
+			}
+
+			if d.Minify {
+				return minifyHTML(b.Bytes()), nil
+			}
+
+			return b.Bytes(), nil
+		})
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page)
+	})
+}
+
+// Flat list of the matches shown, at /matches.json and /matches.
+func listMatches(w http.ResponseWriter, r *http.Request) {
+	f, err := parseFilters(r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+		return
+	}
+
+	d, err := parseDisplay(r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+		return
+	}
+
+	desc, err := parseOrder(r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+		return
+	}
+
+	limit, err := parseLimit(r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+		return
+	}
+
+	debug, err := wantsDebug(r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+		return
+	}
+
+	fields, err := parseFields(r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+		return
+	}
+
+	var ndjson bool
+	switch v := r.FormValue("format"); v {
+	case "", "json":
+	case "ndjson":
+		ndjson = true
+	default:
+		jsonError(w, http.StatusBadRequest, errBadRequest, fmt.Sprintf("format must be json or ndjson, got %q", v))
+		return
+	}
+
+	if err := refreshScheduleIfNeeded(w, r); err != nil {
+		jsonRefreshError(w, err)
+		return
+	}
+
+	start := time.Now()
+	s := f.apply(d.format(scheduleAt(cachedSchedule(), now())))
+	if debug {
+		addProvenance(s)
+		flagSuspects(s)
+	}
+	recordTiming(w, "filter", start)
+
+	list := &matchList{
+		Matches:        d.flatten(s, desc),
+		NextRefresh:    nextRefresh().Format(time.RFC3339),
+		Source:         sourceName,
+		AppliedFilters: f.applied(desc, d.Location),
+	}
+	if limit > 0 && len(list.Matches) > limit {
+		list.Matches = list.Matches[:limit]
+		list.Truncated = true
+	}
+
+	if ndjson {
+		writeNDJSON(w, list.Matches, fields)
+		return
+	}
+
+	writeJSON(w, r, list)
+}
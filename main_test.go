@@ -0,0 +1,1759 @@
+package alexmatchen
+
+import (
+	"appengine"
+	"appengine/aetest"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// A Saturday evening in the middle of the season, which the clock is frozen
+// at by tests needing one.
+var testNow = time.Date(2014, 5, 17, 17, 0, 0, 0, stockholm)
+
+// A context for code that only logs, logging to the test. Calls to App
+// Engine services panic, as there is no instance to make them to.
+type testContext struct {
+	appengine.Context
+	t testing.TB
+}
+
+func (c testContext) Debugf(format string, args ...interface{}) { c.t.Logf("DEBUG: "+format, args...) }
+func (c testContext) Infof(format string, args ...interface{})  { c.t.Logf("INFO: "+format, args...) }
+func (c testContext) Warningf(format string, args ...interface{}) {
+	c.t.Logf("WARNING: "+format, args...)
+}
+func (c testContext) Errorf(format string, args ...interface{}) { c.t.Logf("ERROR: "+format, args...) }
+func (c testContext) Criticalf(format string, args ...interface{}) {
+	c.t.Logf("CRITICAL: "+format, args...)
+}
+
+// Returns a scraped Premier League match on date ("2014-05-17") kicking off
+// at clock ("20:45"), or without a known kickoff for a clock like "TBD".
+func testMatch(date, clock, name string, channels ...string) *match {
+	day, _ := dayDate(date)
+	kickoff := parseKickoff(day, clock)
+	home, away := splitTeams(name)
+	return &match{
+		ID:        matchID(day, name),
+		Name:      name,
+		RawName:   name,
+		Home:      home,
+		Away:      away,
+		Sport:     "Fotboll",
+		League:    "Premier League",
+		Channel:   strings.Join(channels, ", "),
+		Channels:  channels,
+		Time:      clock,
+		RawTime:   clock,
+		Kickoff:   kickoff,
+		Source:    sourceName,
+		TimeKnown: !kickoff.IsZero(),
+	}
+}
+
+// Returns the names of matches in order.
+func matchNames(matches []*match) []string {
+	names := make([]string, len(matches))
+	for i, m := range matches {
+		names[i] = m.Name
+	}
+
+	return names
+}
+
+// Returns a request for path, failing the test if it can't be made.
+func newRequest(t *testing.T, path string) *http.Request {
+	r, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+// Freezes the clock at at and caches s as refreshed successfully then, as if
+// by refreshSchedule. The returned func puts back the clock and the cache.
+func useSchedule(at time.Time, s map[string][]*match) func() {
+	prevNow := now
+	now = func() time.Time { return at }
+
+	mu.Lock()
+	prev, prevRefresh, prevAttempt, prevErr, prevCheck := schedule, lastRefresh, lastAttempt, lastRefreshErr, lastStoreCheck
+	schedule, lastRefresh, lastAttempt, lastRefreshErr, lastStoreCheck = s, at, at, nil, time.Time{}
+	invalidateSnapshots()
+	mu.Unlock()
+
+	return func() {
+		mu.Lock()
+		schedule, lastRefresh, lastAttempt, lastRefreshErr, lastStoreCheck = prev, prevRefresh, prevAttempt, prevErr, prevCheck
+		invalidateSnapshots()
+		mu.Unlock()
+		now = prevNow
+	}
+}
+
+// Serves a GET of path with the handlers of the app. Handlers need the
+// context of a request made by a test instance of App Engine.
+func serve(t testing.TB, inst aetest.Instance, path string, header http.Header) *httptest.ResponseRecorder {
+	r, err := inst.NewRequest("GET", path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, values := range header {
+		r.Header[name] = values
+	}
+
+	w := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(w, r)
+	return w
+}
+
+// Starts a test instance of App Engine, closed at the end of the test by the
+// returned func.
+func newInstance(t testing.TB) (aetest.Instance, func()) {
+	inst, err := aetest.NewInstance(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return inst, func() { inst.Close() }
+}
+
+func TestConditionalGet(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+
+	defer useSchedule(testNow, map[string][]*match{"2014-05-17": {
+		testMatch("2014-05-17", "19:00", "Chelsea - Everton", "C More Sport"),
+		testMatch("2014-05-17", "21:00", "Arsenal - Hull", "TV4"),
+	}})()
+
+	for _, path := range []string{"/schedule.json", "/matches.json", "/matches.json?callback=show"} {
+		now = func() time.Time { return testNow }
+		first := serve(t, inst, path, nil)
+		etag := first.Header().Get("ETag")
+		if first.Code != http.StatusOK || etag == "" {
+			t.Fatalf("GET %s = %d with ETag %q, want 200 with an ETag", path, first.Code, etag)
+		}
+
+		// A poller a few minutes later, before any status changed
+		now = func() time.Time { return testNow.Add(5 * time.Minute) }
+		for _, tc := range []struct {
+			ifNoneMatch string
+			want        int
+		}{
+			{etag, http.StatusNotModified},
+			{"W/" + etag, http.StatusNotModified},
+			{`"other", ` + etag, http.StatusNotModified},
+			{"*", http.StatusNotModified},
+			{`"other"`, http.StatusOK},
+			{"", http.StatusOK},
+		} {
+			w := serve(t, inst, path, http.Header{"If-None-Match": {tc.ifNoneMatch}})
+			if w.Code != tc.want {
+				t.Errorf("GET %s with If-None-Match %s = %d, want %d", path, tc.ifNoneMatch, w.Code, tc.want)
+			}
+
+			if w.Code == http.StatusNotModified && w.Body.Len() > 0 {
+				t.Errorf("GET %s with If-None-Match %s sent a body with the 304", path, tc.ifNoneMatch)
+			}
+
+			if got := w.Header().Get("ETag"); got != etag {
+				t.Errorf("GET %s with If-None-Match %s has ETag %s, want %s", path, tc.ifNoneMatch, got, etag)
+			}
+		}
+	}
+
+	// A match kicking off changes its status, and so the body. Unlike
+	// /schedule.json /matches.json isn't snapshotted, so it's rendered anew.
+	now = func() time.Time { return testNow }
+	etag := serve(t, inst, "/matches.json", nil).Header().Get("ETag")
+	now = func() time.Time { return testNow.Add(2*time.Hour + time.Minute) }
+	if w := serve(t, inst, "/matches.json", http.Header{"If-None-Match": {etag}}); w.Code != http.StatusOK {
+		t.Errorf("GET /matches.json after a kickoff = %d, want 200", w.Code)
+	}
+}
+
+func TestStartsInField(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{`{"Name":"A - B","StartsIn":7200,"Status":"scheduled"}`, `{"Name":"A - B","Status":"scheduled"}`},
+		{`{"Name":"A - B","StartsIn":-300}`, `{"Name":"A - B",}`},
+		{`{"StartsIn":60,"Name":"A - B"}`, `{"Name":"A - B"}`},
+		{`{"Name":"\"StartsIn\":1"}`, `{"Name":"\"StartsIn\":1"}`},
+	} {
+		if got := string(startsInField.ReplaceAll([]byte(tc.in), nil)); got != tc.want {
+			t.Errorf("%s without StartsIn = %s, want %s", tc.in, got, tc.want)
+		}
+	}
+}
+
+// Serves the page returned for each request, for fetches from tests.
+type pageTransport func(r *http.Request) string
+
+func (pt pageTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+		Body:       ioutil.NopCloser(strings.NewReader(pt(r))),
+		Request:    r,
+	}, nil
+}
+
+// Fetches upstream pages with rt rather than urlfetch, until the returned
+// func puts back the transport.
+func useTransport(rt http.RoundTripper) func() {
+	prev := newTransport
+	newTransport = func(c appengine.Context) http.RoundTripper { return rt }
+	return func() { newTransport = prev }
+}
+
+// Serves reads while refreshes merge changing schedules into the cache, for
+// go test -race (goapp test -race) to catch readers seeing the cache while
+// it's written.
+func TestConcurrentRefresh(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, nil)()
+
+	// Every other refresh moves a kickoff, so the merges change the schedule
+	var fetches int32
+	defer useTransport(pageTransport(func(r *http.Request) string {
+		if atomic.AddInt32(&fetches, 1)%2 == 0 {
+			return strings.Replace(selftestFixture, "16:00", "16:30", 1)
+		}
+
+		return selftestFixture
+	}))()
+
+	refresh := func() error {
+		r, err := inst.NewRequest("GET", "/tasks/refresh", nil)
+		if err != nil {
+			return err
+		}
+
+		return refreshSchedule(r)
+	}
+
+	if err := refresh(); err != nil {
+		t.Fatalf("first refresh failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if err := refresh(); err != nil {
+				t.Errorf("refresh %d failed: %v", i, err)
+			}
+		}
+	}()
+
+	paths := []string{"/?leagues=FA+Cup", "/schedule.json?leagues=FA+Cup", "/matches.json?leagues=FA+Cup", "/healthz", "/diff.json"}
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				for _, path := range paths {
+					r, err := inst.NewRequest("GET", path, nil)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+
+					w := httptest.NewRecorder()
+					http.DefaultServeMux.ServeHTTP(w, r)
+					if w.Code != http.StatusOK {
+						t.Errorf("GET %s during refreshes = %d %s", path, w.Code, w.Body)
+					}
+
+					if path == paths[1] && !strings.Contains(w.Body.String(), "Arsenal - Hull City") {
+						t.Errorf("GET %s during refreshes left out a match: %s", path, w.Body)
+					}
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// Returns testdata/schedule.html, a saved tvmatchen.nu page of two weeks of
+// 40 matches a day, for benchmarks needing a page of realistic size.
+func largePage(b *testing.B) []byte {
+	page, err := ioutil.ReadFile("testdata/schedule.html")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return page
+}
+
+// Returns the large page parsed into a document.
+func largeDocument(b *testing.B) *goquery.Document {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(largePage(b)))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return doc
+}
+
+// Cleans up the league cells of the large page, once for every row as
+// parsing used to, and once for each distinct cell as parseSchedule does.
+func BenchmarkLeagueCleanup(b *testing.B) {
+	type cell struct {
+		text  string
+		links []string
+	}
+
+	var cells []cell
+	largeDocument(b).Find(selectors.Row).Filter(sportSelector()).Each(func(i int, s *goquery.Selection) {
+		leagueCell := s.Find(selectors.League)
+		c := cell{text: leagueCell.Text()}
+		leagueCell.Find("a").Each(func(ai int, as *goquery.Selection) {
+			c.links = append(c.links, as.Text())
+		})
+
+		cells = append(cells, c)
+	})
+
+	b.Run("every row", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, c := range cells {
+				cleanLeague(c.text, c.links)
+			}
+		}
+	})
+
+	b.Run("distinct cells", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			cleaned := make(map[string]struct{ league, round string })
+			for _, c := range cells {
+				key := c.text + "\x00" + strings.Join(c.links, "\x00")
+				if _, ok := cleaned[key]; !ok {
+					league, round := cleanLeague(c.text, c.links)
+					cleaned[key] = struct{ league, round string }{league, round}
+				}
+			}
+		}
+	})
+}
+
+// Parses the large page into a document, and the document into the schedule
+// as refreshes do.
+func BenchmarkParseSchedule(b *testing.B) {
+	page := largePage(b)
+	b.Run("document", func(b *testing.B) {
+		b.SetBytes(int64(len(page)))
+		for i := 0; i < b.N; i++ {
+			if _, err := goquery.NewDocumentFromReader(bytes.NewReader(page)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("schedule", func(b *testing.B) {
+		doc := largeDocument(b)
+		c := testContext{t: b}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			parseSchedule(c, doc)
+		}
+	})
+}
+
+func TestRetryBackoff(t *testing.T) {
+	overridesMu.RLock()
+	prevOverrides := currentOverrides
+	overridesMu.RUnlock()
+	defer func() {
+		overridesMu.Lock()
+		currentOverrides = prevOverrides
+		overridesMu.Unlock()
+	}()
+
+	for _, tc := range []struct {
+		interval string
+		failures int
+		want     time.Duration
+	}{
+		{"", 0, retryDelay},
+		{"", 1, retryDelay},
+		{"", 2, 2 * retryDelay},
+		{"", 3, 4 * retryDelay},
+		{"", 7, 64 * retryDelay},
+		{"", 8, cacheDuration},
+		{"", 50, cacheDuration},
+		{"1h", 4, 8 * retryDelay},
+		{"1h", 5, time.Hour},
+		{"1h", 50, time.Hour},
+	} {
+		overridesMu.Lock()
+		currentOverrides = &overrides{CacheDuration: tc.interval}
+		overridesMu.Unlock()
+		if got := retryBackoff(tc.failures); got != tc.want {
+			t.Errorf("retryBackoff(%d) with CacheDuration %q = %v, want %v", tc.failures, tc.interval, got, tc.want)
+		}
+	}
+}
+
+// With REFRESH_ON_REQUEST, a failed refresh is retried on request only once
+// its backoff is over.
+func TestRetryOnRequest(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, map[string][]*match{"2014-05-17": {
+		testMatch("2014-05-17", "21:00", "Arsenal - Hull"),
+	}})()
+
+	prevOnRequest, prevThreshold, prevKeep := refreshOnRequest, dropThreshold, keepOnDrop
+	defer func() { refreshOnRequest, dropThreshold, keepOnDrop = prevOnRequest, prevThreshold, prevKeep }()
+
+	// An empty page fails the refresh as a suspected partial scrape
+	refreshOnRequest, dropThreshold, keepOnDrop = true, 0.5, true
+
+	mu.Lock()
+	prevFailed := failedRefreshes
+	failedRefreshes = 0
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		failedRefreshes = prevFailed
+		mu.Unlock()
+	}()
+
+	var fetches int32
+	up := false
+	defer useTransport(pageTransport(func(r *http.Request) string {
+		atomic.AddInt32(&fetches, 1)
+		if up {
+			return selftestFixture
+		}
+
+		return "<html><body></body></html>"
+	}))()
+
+	first := testNow.Add(refreshInterval() + time.Minute)
+	second := first.Add(retryDelay + time.Minute)
+	for _, tc := range []struct {
+		name    string
+		at      time.Time
+		up      bool
+		fetched bool
+		failed  int
+	}{
+		{"not due", testNow.Add(time.Hour), false, false, 0},
+		{"interval over", first, false, true, 1},
+		{"within the first backoff", first.Add(retryDelay - time.Minute), false, false, 1},
+		{"first backoff over", second, false, true, 2},
+		{"within the doubled backoff", second.Add(retryDelay + time.Minute), true, false, 2},
+		{"doubled backoff over", second.Add(2*retryDelay + time.Minute), true, true, 0},
+	} {
+		at := tc.at
+		now = func() time.Time { return at }
+		up = tc.up
+		atomic.StoreInt32(&fetches, 0)
+
+		r, err := inst.NewRequest("GET", "/schedule.json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The previous schedule is served whether or not the retry works
+		if err := refreshScheduleIfNeeded(httptest.NewRecorder(), r); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+
+		if fetched := atomic.LoadInt32(&fetches) > 0; fetched != tc.fetched {
+			t.Errorf("%s: fetched upstream %v, want %v", tc.name, fetched, tc.fetched)
+		}
+
+		mu.RLock()
+		failed := failedRefreshes
+		mu.RUnlock()
+		if failed != tc.failed {
+			t.Errorf("%s: %d failed refreshes in a row, want %d", tc.name, failed, tc.failed)
+		}
+	}
+}
+
+func TestMatchStatus(t *testing.T) {
+	prevDurations := sportDurations
+	defer func() { sportDurations = prevDurations }()
+	sportDurations = map[string]time.Duration{"Ishockey": 2*time.Hour + 30*time.Minute}
+
+	football := testMatch("2014-05-17", "19:00", "Chelsea - Everton")
+	hockey := testMatch("2014-05-17", "19:00", "Frölunda - HV71")
+	hockey.Sport = "Ishockey"
+	tbd := testMatch("2014-05-17", "TBD", "Arsenal - Hull")
+
+	kickoff := football.Kickoff
+	for _, tc := range []struct {
+		m    *match
+		at   time.Time
+		want string
+	}{
+		{football, kickoff.Add(-time.Hour), statusScheduled},
+		{football, kickoff, statusLive},
+		{football, kickoff.Add(time.Hour), statusLive},
+		{football, kickoff.Add(2 * time.Hour), statusFinished},
+		{hockey, kickoff.Add(2 * time.Hour), statusLive},
+		{hockey, kickoff.Add(2*time.Hour + 30*time.Minute), statusFinished},
+		{tbd, kickoff, ""},
+
+		// The time zone of the clock doesn't matter
+		{football, kickoff.Add(time.Hour).UTC(), statusLive},
+	} {
+		got := scheduleAt(map[string][]*match{"2014-05-17": {tc.m}}, tc.at)["2014-05-17"][0]
+		if got.Status != tc.want {
+			t.Errorf("status of %s (%s) at %s = %q, want %q", tc.m.Name, tc.m.Sport, tc.at.Format(time.RFC3339), got.Status, tc.want)
+		}
+
+		if tc.m.Status != "" {
+			t.Errorf("status of the cached %s was set", tc.m.Name)
+		}
+	}
+}
+
+// The status is computed when serving, so it changes between refreshes.
+func TestStatusAtRequestTime(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, map[string][]*match{"2014-05-17": {
+		testMatch("2014-05-17", "19:00", "Chelsea - Everton"),
+	}})()
+
+	for _, tc := range []struct {
+		at   time.Time
+		want string
+	}{
+		{testNow, statusScheduled},
+		{testNow.Add(3 * time.Hour), statusLive},
+		{testNow.Add(5 * time.Hour), statusFinished},
+	} {
+		now = func() time.Time { return tc.at }
+		var list matchList
+		if err := json.Unmarshal(serve(t, inst, "/matches.json", nil).Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(list.Matches) != 1 || list.Matches[0].Status != tc.want {
+			t.Errorf("/matches.json at %s = %+v, want one %s match", tc.at.Format("15:04"), list.Matches, tc.want)
+		}
+	}
+}
+
+// Parses a tvmatchen.nu page, failing the test if it isn't HTML.
+func parseTestPage(t testing.TB, page string) map[string][]*match {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, _ := parseSchedule(testContext{t: t}, doc)
+	return s
+}
+
+func TestCleanLeague(t *testing.T) {
+	for _, tc := range []struct {
+		text          string
+		links         []string
+		league, round string
+	}{
+		{"Fotboll Premier League", []string{"Fotboll"}, "Premier League", ""},
+		{"Fotboll Superettan Omgång 8", []string{"Fotboll"}, "Superettan", "Omgång 8"},
+		{"\n\tFotboll\n\tAllsvenskan,  omgång 12 ", []string{"Fotboll"}, "Allsvenskan", "omgång 12"},
+		{"Premier League Round 34", nil, "Premier League", "Round 34"},
+		{"Fotboll Omgång 3 Allsvenskan", []string{"Fotboll"}, "Allsvenskan", "Omgång 3"},
+		{"Fotboll Serie A", []string{"Fotboll", "Serie A"}, "", ""},
+		{"", nil, "", ""},
+	} {
+		league, round := cleanLeague(tc.text, tc.links)
+		if league != tc.league || round != tc.round {
+			t.Errorf("cleanLeague(%q, %q) = %q, %q, want %q, %q", tc.text, tc.links, league, round, tc.league, tc.round)
+		}
+	}
+
+	s := parseTestPage(t, selftestFixture)
+	if len(s["2014-05-17"]) != 2 {
+		t.Fatalf("parsed %v from the fixture, want 2 matches on 2014-05-17", s)
+	}
+
+	for _, m := range s["2014-05-17"] {
+		if want := map[string]string{"Hammarby - Ljungskile": "Omgång 8"}[m.Name]; m.Round != want {
+			t.Errorf("round of %s = %q, want %q", m.Name, m.Round, want)
+		}
+	}
+}
+
+func TestOrder(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, map[string][]*match{
+		"2014-05-17": {
+			testMatch("2014-05-17", "18:00", "Chelsea - Everton"),
+			testMatch("2014-05-17", "21:00", "Arsenal - Hull"),
+		},
+		"2014-05-18": {
+			testMatch("2014-05-18", "16:00", "Liverpool - Newcastle"),
+			testMatch("2014-05-18", "18:30", "Stoke - Fulham"),
+		},
+	})()
+
+	asc := []string{"Chelsea - Everton", "Arsenal - Hull", "Liverpool - Newcastle", "Stoke - Fulham"}
+	desc := []string{"Stoke - Fulham", "Liverpool - Newcastle", "Arsenal - Hull", "Chelsea - Everton"}
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"", asc},
+		{"order=asc", asc},
+		{"order=desc", desc},
+		{"limit=3", asc[:3]},
+		{"order=desc&limit=3", desc[:3]},
+		{"order=desc&limit=1", desc[:1]},
+	} {
+		var list matchList
+		if err := json.Unmarshal(serve(t, inst, "/matches.json?"+tc.query, nil).Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := matchNames(list.Matches); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("/matches.json?%s = %q, want %q", tc.query, got, tc.want)
+		}
+
+		if truncated := len(tc.want) < len(asc); list.Truncated != truncated {
+			t.Errorf("/matches.json?%s is truncated %v, want %v", tc.query, list.Truncated, truncated)
+		}
+	}
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"", asc},
+		{"order=desc", desc},
+	} {
+		if got := pageOrder(serve(t, inst, "/?"+tc.query, nil).Body.String(), asc); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("/?%s lists %q, want %q", tc.query, got, tc.want)
+		}
+	}
+
+	for _, path := range []string{"/?order=backwards", "/matches.json?order=DESC"} {
+		if w := serve(t, inst, path, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want 400", path, w.Code)
+		}
+	}
+}
+
+// A page like selftestFixture cut short after its first match, as upstream
+// sometimes renders it.
+const partialFixture = `
+<html>
+	<body>
+		<h2 class="day-name"><span class="day-name-inner" id="match-day-2014-05-17">Lördag 17 maj</span></h2>
+		<div class="view-content">
+			<div class="match sport-name-fotboll">
+				<div class="time"><span class="field-content">16:00</span></div>
+				<div class="match-name">Arsenal - Hull City</div>
+				<div class="league"><a href="/fotboll">Fotboll</a> FA Cup</div>
+				<div class="channel"><span class="channel-item" title="TV4"></span></div>
+			</div>
+		</div>
+	</body>
+</html>
+`
+
+// Fails the test unless the known kickoffs of a flat list of matches never
+// decrease.
+func checkKickoffOrder(t *testing.T, what string, matches []*match) {
+	var last time.Time
+	for _, m := range matches {
+		if !m.TimeKnown {
+			continue
+		}
+
+		if m.Kickoff.Before(last) {
+			t.Errorf("%s lists %s at %v after a match at %v", what, m.Name, m.Kickoff, last)
+		}
+
+		last = m.Kickoff
+	}
+}
+
+func TestFlatKickoffOrder(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+
+	// Days list their matches out of order, end and start around midnight,
+	// and hold matches without a known kickoff
+	defer useSchedule(testNow, map[string][]*match{
+		"2014-05-17": {
+			testMatch("2014-05-17", "23:45", "Arsenal - Hull"),
+			testMatch("2014-05-17", "-", "Fulham - Stoke"),
+			testMatch("2014-05-17", "18:00", "Chelsea - Everton"),
+		},
+		"2014-05-18": {
+			testMatch("2014-05-18", "16:00", "Liverpool - Newcastle"),
+			testMatch("2014-05-18", "00:30", "Malmö FF - AIK"),
+			testMatch("2014-05-18", "Ej klart", "Hammarby - Djurgården"),
+		},
+		"2014-05-19": {
+			testMatch("2014-05-19", "00:00", "Gefle - Elfsborg"),
+		},
+	})()
+
+	want := []string{"Chelsea - Everton", "Arsenal - Hull", "Fulham - Stoke", "Malmö FF - AIK", "Liverpool - Newcastle", "Hammarby - Djurgården", "Gefle - Elfsborg"}
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"includeTBD=true", want},
+		{"", []string{"Chelsea - Everton", "Arsenal - Hull", "Malmö FF - AIK", "Liverpool - Newcastle", "Gefle - Elfsborg"}},
+	} {
+		var list matchList
+		if err := json.Unmarshal(serve(t, inst, "/matches.json?"+tc.query, nil).Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+
+		what := "/matches.json?" + tc.query
+		checkKickoffOrder(t, what, list.Matches)
+		if got := matchNames(list.Matches); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s = %q, want %q", what, got, tc.want)
+		}
+	}
+
+	// Map order varies, so flatten the same schedule several times
+	for i := 0; i < 20; i++ {
+		all := flatten(cachedSchedule())
+		checkKickoffOrder(t, "flatten", all)
+		if got := matchNames(all); !reflect.DeepEqual(got, want) {
+			t.Fatalf("flatten = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestPartialScrape(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, nil)()
+
+	prevThreshold, prevKeep := dropThreshold, keepOnDrop
+	defer func() { dropThreshold, keepOnDrop = prevThreshold, prevKeep }()
+
+	page := selftestFixture
+	defer useTransport(pageTransport(func(r *http.Request) string { return page }))()
+
+	refresh := func() error {
+		r, err := inst.NewRequest("GET", "/tasks/refresh", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return refreshSchedule(r)
+	}
+
+	for _, tc := range []struct {
+		threshold float64
+		keep      bool
+		fails     bool
+		want      int
+	}{
+		// The partial day replaces the full one, the missing day is kept
+		{0.5, false, false, 2},
+		{0.5, true, true, 3},
+		{0.2, true, false, 2},
+	} {
+		page = selftestFixture
+		if err := refresh(); err != nil || countMatches(cachedSchedule()) != 3 {
+			t.Fatalf("refreshing the full page = %v with %d matches, want 3", err, countMatches(cachedSchedule()))
+		}
+
+		dropThreshold, keepOnDrop, page = tc.threshold, tc.keep, partialFixture
+		err := refresh()
+		if (err != nil) != tc.fails {
+			t.Errorf("DROP_THRESHOLD=%v KEEP_ON_DROP=%v: refreshing the partial page = %v, want failure %v", tc.threshold, tc.keep, err, tc.fails)
+		}
+
+		if got := countMatches(cachedSchedule()); got != tc.want {
+			t.Errorf("DROP_THRESHOLD=%v KEEP_ON_DROP=%v: %d matches after the partial page, want %d", tc.threshold, tc.keep, got, tc.want)
+		}
+
+		dropThreshold, keepOnDrop = prevThreshold, prevKeep
+	}
+}
+
+// partialFixture with every class the parser looks up renamed, as after an
+// upstream redesign.
+const renamedFixture = `
+<html>
+	<body>
+		<h3 class="dag"><span class="dag-inner" id="match-day-2014-05-17">Lördag 17 maj</span></h3>
+		<ul class="matcher">
+			<li class="rad sport-name-fotboll">
+				<span class="tid"><b>16:00</b></span>
+				<span class="namn">Arsenal - Hull City</span>
+				<span class="serie"><a href="/fotboll">Fotboll</a> FA Cup</span>
+				<span class="kanaler"><i class="kanal" title="TV4"></i><i class="kanal" title="TV12"></i></span>
+			</li>
+		</ul>
+		<a class="nasta" href="/?page=2">Nästa</a>
+	</body>
+</html>
+`
+
+func TestSelectors(t *testing.T) {
+	prevSelectors := selectors
+	defer func() { selectors = prevSelectors }()
+
+	renamed := &pageSelectors{
+		Day:      "h3.dag",
+		DayID:    "span.dag-inner",
+		Row:      ".rad",
+		Name:     ".namn",
+		League:   ".serie",
+		Channel:  ".kanaler .kanal",
+		Time:     ".tid b",
+		NextPage: "a.nasta",
+	}
+
+	for _, tc := range []struct {
+		name      string
+		selectors *pageSelectors
+		page      string
+		matches   int
+		next      string
+	}{
+		{"defaults", prevSelectors, partialFixture, 1, ""},
+		{"defaults on renamed markup", prevSelectors, renamedFixture, 0, ""},
+		{"overridden", renamed, renamedFixture, 1, "https://www.tvmatchen.nu/?page=2"},
+		{"overridden on default markup", renamed, partialFixture, 0, ""},
+	} {
+		selectors = tc.selectors
+		s := parseTestPage(t, tc.page)
+		if got := countMatches(s); got != tc.matches {
+			t.Errorf("%s: parsed %d matches, want %d", tc.name, got, tc.matches)
+			continue
+		}
+
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(tc.page))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		doc.Url, _ = url.Parse("https://www.tvmatchen.nu/")
+		if got := nextPageURL(doc); got != tc.next {
+			t.Errorf("%s: next page = %q, want %q", tc.name, got, tc.next)
+		}
+
+		if tc.matches == 0 {
+			continue
+		}
+
+		m := s["2014-05-17"][0]
+		if m.Name != "Arsenal - Hull City" || m.League != "FA Cup" || m.Time != "16:00" || !m.TimeKnown || m.Sport != "Fotboll" {
+			t.Errorf("%s: parsed %+v, want Arsenal - Hull City in the FA Cup at 16:00", tc.name, m)
+		}
+
+		if tc.selectors == renamed && !reflect.DeepEqual(m.Channels, []string{"TV4", "TV12"}) {
+			t.Errorf("%s: channels = %q, want [TV4 TV12]", tc.name, m.Channels)
+		}
+	}
+}
+
+func TestLeagueBlocklist(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, nil)()
+
+	prevBlocklist, prevInterest := leagueBlocklist, interestTeams
+	defer func() { leagueBlocklist, interestTeams = prevBlocklist, prevInterest }()
+	leagueBlocklist, interestTeams = splitList("superettan, U19"), []string{"Hammarby"}
+
+	for _, tc := range []struct {
+		league  string
+		blocked bool
+	}{
+		{"Superettan", true},
+		{"Allsvenskan U19", true},
+		{"u19 Damer", true},
+		{"Allsvenskan", false},
+		{"", false},
+	} {
+		if got := blockedLeague(tc.league); got != tc.blocked {
+			t.Errorf("blockedLeague(%q) = %v, want %v", tc.league, got, tc.blocked)
+		}
+	}
+
+	defer useTransport(pageTransport(func(r *http.Request) string { return selftestFixture }))()
+	r, err := inst.NewRequest("GET", "/tasks/refresh", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := refreshSchedule(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := lastParseStats.Skipped[skipBlockedLeague]; got != 1 {
+		t.Errorf("skipped %d rows of blocked leagues, want 1", got)
+	}
+
+	// Listed matches of blocked leagues are dropped too
+	youth := listedMatch("2014-05-17", "14:00", "Malmö FF - AIK", "Viaplay")
+	youth.League = "Allsvenskan U19"
+	adult := listedMatch("2014-05-17", "17:00", "Malmö FF - AIK", "Viaplay")
+	adult.League = "Allsvenskan"
+	listed := map[string][]*match{}
+	for date, matches := range cachedSchedule() {
+		listed[date] = matches
+	}
+
+	addListing(testContext{t: t}, listed, testListing("streams.example"), []*match{youth, adult}, nil)
+	defer useSchedule(testNow, listed)()
+
+	// Hammarby is an interest team playing in Superettan, which is blocked
+	for _, path := range []string{"/matches.json?leagues=all", "/matches.json?leagues=superettan", "/matches.json?team=Hammarby", "/matches.json"} {
+		var list matchList
+		if err := json.Unmarshal(serve(t, inst, path, nil).Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, m := range list.Matches {
+			if blockedLeague(m.League) {
+				t.Errorf("%s lists %s of %s", path, m.Name, m.League)
+			}
+		}
+
+		if path == "/matches.json?leagues=all" {
+			want := []string{"Arsenal - Hull City", "Malmö FF - AIK", "Liverpool - Newcastle"}
+			if got := matchNames(list.Matches); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s lists %q, want %q", path, got, want)
+			}
+		}
+	}
+}
+
+func TestChannels(t *testing.T) {
+	page := strings.Replace(selftestFixture, `<span class="channel-item" title="TV12"></span>`,
+		`<span class="channel-item" title="TV12"></span><span class="channel-item"> TV10 </span><span class="channel-item" title=""></span>`, 1)
+	channels := map[string][]string{}
+	for _, matches := range parseTestPage(t, page) {
+		for _, m := range matches {
+			channels[m.Name] = m.Channels
+			if m.Channel != strings.Join(m.Channels, ", ") {
+				t.Errorf("Channel of %s = %q, want %q joined", m.Name, m.Channel, m.Channels)
+			}
+		}
+	}
+
+	want := map[string][]string{
+		"Arsenal - Hull City":   {"TV4", "C More Sport"},
+		"Hammarby - Ljungskile": {"TV12", "TV10"},
+		"Liverpool - Newcastle": {"Viasat Fotboll"},
+	}
+	if !reflect.DeepEqual(channels, want) {
+		t.Errorf("parsed channels %q, want %q", channels, want)
+	}
+
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, map[string][]*match{"2014-05-17": {
+		testMatch("2014-05-17", "16:00", "Arsenal - Hull City", "TV4", "C More Sport"),
+	}})()
+
+	// Any of the channels matches, and all are shown
+	var list matchList
+	if err := json.Unmarshal(serve(t, inst, "/matches.json?channels=c+more+sport", nil).Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list.Matches) != 1 || !reflect.DeepEqual(list.Matches[0].Channels, []string{"TV4", "C More Sport"}) {
+		t.Errorf("/matches.json?channels=c+more+sport = %+v, want Arsenal - Hull City on both channels", list.Matches)
+	}
+
+	if page := serve(t, inst, "/", nil).Body.String(); !strings.Contains(page, "TV4, C More Sport") {
+		t.Errorf("/ doesn't list the channels comma separated:\n%s", page)
+	}
+}
+
+func TestChannelText(t *testing.T) {
+	for _, tc := range []struct {
+		items string
+		want  []string
+	}{
+		{`<span class="channel-item" title="TV4"></span>`, []string{"TV4"}},
+		{`<span class="channel-item">TV4</span>`, []string{"TV4"}},
+		{`<span class="channel-item">
+			<b>C More</b> Sport
+		</span>`, []string{"C More Sport"}},
+		{`<span class="channel-item" title="  Viasat   Fotboll "></span>`, []string{"Viasat Fotboll"}},
+		{`<span class="channel-item" title="TV4">TV 4 HD</span>`, []string{"TV4"}},
+		{`<span class="channel-item" title="">TV4</span>`, []string{}},
+		{`<span class="channel-item">  </span><span class="channel-item" title="TV12"></span>`, []string{"TV12"}},
+	} {
+		page := strings.Replace(partialFixture, `<span class="channel-item" title="TV4"></span>`, tc.items, 1)
+		matches := parseTestPage(t, page)["2014-05-17"]
+		if len(matches) != 1 {
+			t.Errorf("%s: parsed %d matches, want 1", tc.items, len(matches))
+			continue
+		}
+
+		if got := matches[0].Channels; !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: parsed channels %q, want %q", tc.items, got, tc.want)
+		}
+	}
+}
+
+func TestChannelRenames(t *testing.T) {
+	const name = "TEST_CHANNEL_RENAMES"
+	defer os.Unsetenv(name)
+	os.Setenv(name, "TV4 Fotboll HD=TV4 Fotboll, C More Sport = C More")
+
+	prevRenames := channelRenames
+	defer func() { channelRenames = prevRenames }()
+	channelRenames = settingMap(name, "")
+
+	for _, tc := range []struct {
+		titles, want []string
+	}{
+		{[]string{"TV4 Fotboll HD"}, []string{"TV4 Fotboll"}},
+		{[]string{"tv4 fotboll hd"}, []string{"tv4 fotboll hd"}},
+		{[]string{"TV4 Fotboll HD", "C More Sport", "TV12"}, []string{"TV4 Fotboll", "C More", "TV12"}},
+		{[]string{"TV4 Fotboll", "TV4 Fotboll HD"}, []string{"TV4 Fotboll"}},
+		{[]string{}, []string{}},
+	} {
+		if got := renameChannels(tc.titles); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("renameChannels(%q) = %q, want %q", tc.titles, got, tc.want)
+		}
+	}
+
+	page := strings.Replace(selftestFixture, `<span class="channel-item" title="TV4"></span>`, `<span class="channel-item" title="TV4 Fotboll HD"></span>`, 1)
+	var renamed *match
+	for _, matches := range parseTestPage(t, page) {
+		for _, m := range matches {
+			if m.Name == "Arsenal - Hull City" {
+				renamed = m
+			}
+		}
+	}
+
+	if renamed == nil {
+		t.Fatal("Arsenal - Hull City isn't parsed")
+	}
+
+	if want := []string{"TV4 Fotboll", "C More"}; !reflect.DeepEqual(renamed.Channels, want) || renamed.Channel != "TV4 Fotboll, C More" {
+		t.Errorf("parsed channels %q (%q), want %q", renamed.Channels, renamed.Channel, want)
+	}
+
+	if want := "TV4 Fotboll HD, C More Sport"; renamed.RawChannel != want {
+		t.Errorf("RawChannel = %q, want %q", renamed.RawChannel, want)
+	}
+
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, map[string][]*match{"2014-05-17": {renamed}})()
+
+	// Channels are filtered and shown by their display names. The match is
+	// in the FA Cup, so every league is asked for.
+	for _, tc := range []struct {
+		query   string
+		matches int
+	}{
+		{"channels=tv4+fotboll", 1},
+		{"channels=C+More", 1},
+		{"channels=TV4+Fotboll+HD", 0},
+		{"channels=C+More+Sport", 0},
+	} {
+		var list matchList
+		if err := json.Unmarshal(serve(t, inst, "/matches.json?leagues=all&"+tc.query, nil).Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(list.Matches) != tc.matches {
+			t.Errorf("/matches.json?%s lists %d matches, want %d", tc.query, len(list.Matches), tc.matches)
+		}
+	}
+
+	if page := serve(t, inst, "/?leagues=all", nil).Body.String(); !strings.Contains(page, ">TV4 Fotboll<") || !strings.Contains(page, ">C More<") || strings.Contains(page, "HD") {
+		t.Errorf("/ doesn't list the renamed channels only:\n%s", page)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, map[string][]*match{"2014-05-17": {
+		testMatch("2014-05-17", "19:00", "Chelsea - Everton"),
+	}})()
+
+	for _, path := range []string{"/bogus", "/day/2014-05-17", "/schedule.json/extra", "/index.html"} {
+		w := serve(t, inst, path, nil)
+		if w.Code != http.StatusNotFound || strings.Contains(w.Body.String(), "Chelsea - Everton") {
+			t.Errorf("GET %s = %d %s, want a 404 without the schedule", path, w.Code, w.Body)
+		}
+
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			t.Errorf("GET %s has Content-Type %s, want HTML", path, ct)
+		}
+
+		w = serve(t, inst, path, http.Header{"Accept": {"application/json"}})
+		var body errorBody
+		if err := json.Unmarshal(w.Body.Bytes(), &body); w.Code != http.StatusNotFound || err != nil || body.Error.Code != errNotFound {
+			t.Errorf("GET %s accepting JSON = %d %s, want a 404 %s error", path, w.Code, w.Body, errNotFound)
+		}
+	}
+
+	if w := serve(t, inst, "/", nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Chelsea - Everton") {
+		t.Errorf("GET / = %d, want 200 with the schedule", w.Code)
+	}
+
+	// Endpoints with a trailing slash redirect rather than 404
+	if w := serve(t, inst, "/schedule.json/?days=1", nil); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/schedule.json?days=1" {
+		t.Errorf("GET /schedule.json/ = %d to %q, want a 301 to /schedule.json?days=1", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestMalformedDays(t *testing.T) {
+	const id = ` id="match-day-2014-05-17"`
+	for _, tc := range []struct {
+		name, id, skip string
+	}{
+		{"missing", ``, skipDayIDMissing},
+		{"unprefixed", ` id="day-2014-05-17"`, skipDayIDMissing},
+		{"malformed", ` id="match-day-17 maj"`, skipDayIDMalformed},
+	} {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(strings.Replace(selftestFixture, id, tc.id, 1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s, stats := parseSchedule(testContext{t: t}, doc)
+		if _, ok := s[""]; ok || len(s) != 1 || len(s["2014-05-18"]) != 1 {
+			t.Errorf("a day with a %s id parsed to %v, want only 2014-05-18", tc.name, s)
+		}
+
+		if stats.Skipped[tc.skip] != 1 || stats.DaysParsed != 1 {
+			t.Errorf("a day with a %s id skipped %v and parsed %d days, want it skipped as %s", tc.name, stats.Skipped, stats.DaysParsed, tc.skip)
+		}
+	}
+}
+
+func TestMergeSchedules(t *testing.T) {
+	chelsea := testMatch("2014-05-17", "19:00", "Chelsea - Everton")
+	arsenal := testMatch("2014-05-17", "21:00", "Arsenal - Hull")
+	liverpool := testMatch("2014-05-18", "16:00", "Liverpool - Newcastle")
+	stoke := testMatch("2014-05-19", "20:00", "Stoke - Fulham")
+	old := testMatch("2014-05-16", "20:00", "Swansea - Sunderland")
+	today, _ := dayDate("2014-05-17")
+
+	for _, tc := range []struct {
+		name        string
+		prev, fresh map[string][]*match
+		want        map[string][]string
+	}{
+		{
+			"fewer days",
+			map[string][]*match{"2014-05-17": {chelsea}, "2014-05-18": {liverpool}},
+			map[string][]*match{"2014-05-17": {chelsea, arsenal}},
+			map[string][]string{"2014-05-17": {"Chelsea - Everton", "Arsenal - Hull"}, "2014-05-18": {"Liverpool - Newcastle"}},
+		},
+		{
+			"day without matches",
+			map[string][]*match{"2014-05-17": {chelsea}, "2014-05-18": {liverpool}},
+			map[string][]*match{"2014-05-17": {arsenal}, "2014-05-18": {}},
+			map[string][]string{"2014-05-17": {"Arsenal - Hull"}, "2014-05-18": {"Liverpool - Newcastle"}},
+		},
+		{
+			"new days",
+			map[string][]*match{"2014-05-17": {chelsea}},
+			map[string][]*match{"2014-05-18": {}, "2014-05-19": {stoke}},
+			map[string][]string{"2014-05-17": {"Chelsea - Everton"}, "2014-05-18": {}, "2014-05-19": {"Stoke - Fulham"}},
+		},
+		{
+			"past days",
+			map[string][]*match{"2014-05-16": {old}, "2014-05-17": {chelsea}},
+			map[string][]*match{"2014-05-17": {chelsea}},
+			map[string][]string{"2014-05-17": {"Chelsea - Everton"}},
+		},
+		{
+			"no previous schedule",
+			nil,
+			map[string][]*match{"2014-05-17": {arsenal}},
+			map[string][]string{"2014-05-17": {"Arsenal - Hull"}},
+		},
+	} {
+		prevDays, freshDays := len(tc.prev), len(tc.fresh)
+		got := map[string][]string{}
+		for date, matches := range mergeSchedules(tc.prev, tc.fresh, today) {
+			got[date] = matchNames(matches)
+		}
+
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: merged %q, want %q", tc.name, got, tc.want)
+		}
+
+		if len(tc.prev) != prevDays || len(tc.fresh) != freshDays {
+			t.Errorf("%s: merging modified its inputs", tc.name)
+		}
+	}
+}
+
+func TestRawName(t *testing.T) {
+	const raw = "\n\t\t\t\t  Arsenal  -\n   Hull City "
+	page := strings.Replace(selftestFixture, "Arsenal - Hull City", raw, 1)
+	var arsenal *match
+	for _, m := range parseTestPage(t, page)["2014-05-17"] {
+		if m.Home == "Arsenal" {
+			arsenal = m
+		}
+	}
+
+	if arsenal == nil || arsenal.RawName != raw || arsenal.Name != "Arsenal - Hull City" {
+		t.Fatalf("parsed %+v, want Name %q and RawName %q", arsenal, "Arsenal - Hull City", raw)
+	}
+
+	// The raw name is in the JSON, the page shows the cleaned one
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, map[string][]*match{"2014-05-17": {arsenal}})()
+
+	var list matchList
+	if err := json.Unmarshal(serve(t, inst, "/matches.json?leagues=all", nil).Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list.Matches) != 1 || list.Matches[0].RawName != raw {
+		t.Errorf("/matches.json = %+v, want RawName %q", list.Matches, raw)
+	}
+
+	if body := serve(t, inst, "/?leagues=all", nil).Body.String(); !strings.Contains(body, "Arsenal - Hull City") || strings.Contains(body, "Arsenal  -") {
+		t.Errorf("/ doesn't show the cleaned name:\n%s", body)
+	}
+}
+
+// Routes fetches from tests by host.
+type hostTransport map[string]http.RoundTripper
+
+func (ht hostTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if rt, ok := ht[r.URL.Host]; ok {
+		return rt.RoundTrip(r)
+	}
+
+	return nil, fmt.Errorf("unexpected fetch of %s", r.URL)
+}
+
+func TestFetchFallback(t *testing.T) {
+	const mirror = "https://mirror.example/fotboll"
+	prevPrimary, prevOrigin, prevDelay := primaryURL, originURL, fetchRetryDelay
+	defer func() { primaryURL, originURL, fetchRetryDelay = prevPrimary, prevOrigin, prevDelay }()
+	fetchRetryDelay = time.Millisecond
+
+	down := &cannedTransport{err: errors.New("connection refused")}
+	unavailable := &cannedTransport{status: http.StatusServiceUnavailable}
+	empty := &cannedTransport{status: http.StatusOK, body: "<html><body></body></html>"}
+	fixture := &cannedTransport{status: http.StatusOK, body: selftestFixture}
+	for _, tc := range []struct {
+		name           string
+		primary        string
+		mirror, origin http.RoundTripper
+		source         string
+		matches        int
+		fails          bool
+	}{
+		{"mirror works", mirror, fixture, down, mirror, 3, false},
+		{"mirror down", mirror, down, fixture, tvmatchenUrl, 3, false},
+		{"mirror unavailable", mirror, unavailable, fixture, tvmatchenUrl, 3, false},
+		{"mirror empty", mirror, empty, fixture, tvmatchenUrl, 3, false},
+		{"both down", mirror, down, unavailable, tvmatchenUrl, 0, true},
+		{"no mirror", tvmatchenUrl, nil, down, tvmatchenUrl, 0, true},
+	} {
+		primaryURL, originURL = tc.primary, tvmatchenUrl
+		restore := useTransport(hostTransport{"mirror.example": tc.mirror, "www.tvmatchen.nu": tc.origin, "tvmatchen.nu": tc.origin})
+		c := testContext{t: t}
+		source, fresh, _, err := fetchPrimary(c, fetchClient(c))
+		restore()
+
+		if source != tc.source || countMatches(fresh) != tc.matches || (err != nil) != tc.fails {
+			t.Errorf("%s: fetched %d matches from %s with error %v, want %d from %s, failing %v", tc.name, countMatches(fresh), source, err, tc.matches, tc.source, tc.fails)
+		}
+	}
+}
+
+func TestRunConcurrently(t *testing.T) {
+	for _, tc := range []struct {
+		n, tasks, most int
+	}{
+		{1, 4, 1},
+		{2, 4, 2},
+		{4, 3, 3},
+		{2, 0, 0},
+	} {
+		var running, most, ran int32
+		tasks := make([]func(), tc.tasks)
+		for i := range tasks {
+			tasks[i] = func() {
+				r := atomic.AddInt32(&running, 1)
+				for {
+					m := atomic.LoadInt32(&most)
+					if r <= m || atomic.CompareAndSwapInt32(&most, m, r) {
+						break
+					}
+				}
+
+				time.Sleep(10 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				atomic.AddInt32(&ran, 1)
+			}
+		}
+
+		runConcurrently(tc.n, tasks...)
+		if int(ran) != tc.tasks || int(most) != tc.most {
+			t.Errorf("runConcurrently(%d) of %d tasks ran %d with up to %d at once, want all with up to %d", tc.n, tc.tasks, ran, most, tc.most)
+		}
+	}
+
+	// A panic is raised again once the other tasks are done
+	var ran int32
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Errorf("runConcurrently raised %v, want boom", p)
+			}
+		}()
+
+		runConcurrently(2,
+			func() { panic("boom") },
+			func() { time.Sleep(10 * time.Millisecond); atomic.AddInt32(&ran, 1) },
+		)
+	}()
+
+	if ran != 1 {
+		t.Errorf("runConcurrently raised the panic before the other task was done")
+	}
+}
+
+// Delays each response of rt, like a slow upstream.
+type slowTransport struct {
+	delay time.Duration
+	rt    http.RoundTripper
+}
+
+func (st slowTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	time.Sleep(st.delay)
+	return st.rt.RoundTrip(r)
+}
+
+// A listing of a match of its own and Arsenal - Hull City of the self-test
+// fixture, on a channel of its own, fetched after delay.
+type slowListing struct {
+	source  string
+	delay   time.Duration
+	clock   string
+	match   string
+	channel string
+}
+
+func (l *slowListing) name() string { return l.source }
+
+func (l *slowListing) fetch(c appengine.Context) ([]*match, error) {
+	time.Sleep(l.delay)
+	return []*match{
+		listedMatch("2014-05-17", l.clock, l.match, l.channel),
+		listedMatch("2014-05-17", "16:00", "Arsenal - Hull City", l.channel),
+	}, nil
+}
+
+// Refreshes from tvmatchen.nu and three listings, each answering after
+// delay, or the later listings first when staggered. Returns how long the
+// refresh took.
+func refreshSources(t testing.TB, inst aetest.Instance, concurrency int, delay time.Duration, staggered bool) time.Duration {
+	prevListings, prevConcurrency := listings, fetchConcurrency
+	defer func() { listings, fetchConcurrency = prevListings, prevConcurrency }()
+
+	fetchConcurrency = concurrency
+	listings = nil
+	for i, name := range []string{"Malmö FF - AIK", "Gefle - Elfsborg", "Hammarby - Djurgården"} {
+		d := delay
+		if staggered {
+			d = delay * time.Duration(3-i)
+		}
+
+		listings = append(listings, &slowListing{fmt.Sprintf("listing%d.example", i), d, fmt.Sprintf("2%d:00", i), name, fmt.Sprintf("Stream %d", i)})
+	}
+
+	defer useTransport(slowTransport{delay, pageTransport(func(r *http.Request) string { return selftestFixture })})()
+	r, err := inst.NewRequest("GET", "/tasks/refresh", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := refreshSchedule(r); err != nil {
+		t.Fatal(err)
+	}
+
+	return time.Since(start)
+}
+
+func TestConcurrentFetch(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+
+	const delay = 30 * time.Millisecond
+	want := []string{
+		"Arsenal - Hull City from " + sourceName + " on TV4, C More Sport, Stream 0, Stream 1, Stream 2",
+		"Malmö FF - AIK from listing0.example on Stream 0",
+		"Hammarby - Ljungskile from " + sourceName + " on TV12",
+		"Gefle - Elfsborg from listing1.example on Stream 1",
+		"Hammarby - Djurgården from listing2.example on Stream 2",
+	}
+
+	elapsed := map[int]time.Duration{}
+	for _, tc := range []struct {
+		concurrency int
+		staggered   bool
+	}{
+		{1, false},
+		{4, false},
+		{4, true},
+		{2, true},
+	} {
+		restore := useSchedule(testNow, nil)
+		took := refreshSources(t, inst, tc.concurrency, delay, tc.staggered)
+		if !tc.staggered {
+			elapsed[tc.concurrency] = took
+		}
+
+		// Listings are merged in order whichever finished first, so the
+		// channels they add to a scraped match come in that order too
+		var got []string
+		for _, m := range cachedSchedule()["2014-05-17"] {
+			got = append(got, m.Name+" from "+m.Source+" on "+m.Channel)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("FETCH_CONCURRENCY=%d staggered %v: merged %q, want %q", tc.concurrency, tc.staggered, got, want)
+		}
+
+		restore()
+	}
+
+	// Four sources one after the other take four delays, all at once about
+	// one
+	if elapsed[1] < 4*delay || elapsed[4] >= 2*delay {
+		t.Errorf("refreshing took %v one source at a time and %v all at once, want at least %v and under %v", elapsed[1], elapsed[4], 4*delay, 2*delay)
+	}
+}
+
+// Serves a paged schedule by path and query, counting fetches. Pages it
+// lacks are unavailable.
+type pagedSite struct {
+	pages   map[string]string
+	fetched []string
+}
+
+func (ps *pagedSite) RoundTrip(r *http.Request) (*http.Response, error) {
+	ps.fetched = append(ps.fetched, r.URL.RequestURI())
+	page, ok := ps.pages[r.URL.RequestURI()]
+	if !ok {
+		return (&cannedTransport{status: http.StatusServiceUnavailable}).RoundTrip(r)
+	}
+
+	return pageTransport(func(*http.Request) string { return page }).RoundTrip(r)
+}
+
+// Returns a page of a single day with one match, linking to next unless
+// it's empty.
+func schedulePage(date, name, next string) string {
+	link := ""
+	if next != "" {
+		link = `<ul class="pager"><li class="pager-next"><a href="` + next + `">Nästa</a></li></ul>`
+	}
+
+	return `<html><body>
+		<h2 class="day-name"><span class="day-name-inner" id="match-day-` + date + `">` + date + `</span></h2>
+		<div class="view-content">
+			<div class="match sport-name-fotboll">
+				<div class="time"><span class="field-content">19:00</span></div>
+				<div class="match-name">` + name + `</div>
+				<div class="league"><a href="/fotboll">Fotboll</a> Premier League</div>
+				<div class="channel"><span class="channel-item" title="TV4"></span></div>
+			</div>
+		</div>
+		` + link + `
+	</body></html>`
+}
+
+func TestPaging(t *testing.T) {
+	defer useSchedule(testNow, nil)()
+	prevPages, prevDelay, prevRetries := maxPages, fetchRetryDelay, fetchRetries
+	defer func() { maxPages, fetchRetryDelay, fetchRetries = prevPages, prevDelay, prevRetries }()
+	maxPages, fetchRetryDelay, fetchRetries = 3, time.Millisecond, 1
+
+	for _, tc := range []struct {
+		name    string
+		pages   map[string]string
+		fetched []string
+		dates   []string
+	}{
+		{
+			"two pages",
+			map[string]string{
+				"/":        schedulePage("2014-05-17", "Arsenal - Hull", "/?page=2"),
+				"/?page=2": schedulePage("2014-05-18", "Chelsea - Everton", ""),
+			},
+			[]string{"/", "/?page=2"},
+			[]string{"2014-05-17", "2014-05-18"},
+		},
+		{
+			"relative link",
+			map[string]string{
+				"/":        schedulePage("2014-05-17", "Arsenal - Hull", "?page=2"),
+				"/?page=2": schedulePage("2014-05-18", "Chelsea - Everton", ""),
+			},
+			[]string{"/", "/?page=2"},
+			[]string{"2014-05-17", "2014-05-18"},
+		},
+		{
+			"linking to itself",
+			map[string]string{"/": schedulePage("2014-05-17", "Arsenal - Hull", "https://www.tvmatchen.nu/")},
+			[]string{"/"},
+			[]string{"2014-05-17"},
+		},
+		{
+			"pages linking to each other",
+			map[string]string{
+				"/":        schedulePage("2014-05-17", "Arsenal - Hull", "/?page=2"),
+				"/?page=2": schedulePage("2014-05-18", "Chelsea - Everton", "/"),
+			},
+			[]string{"/", "/?page=2", "/"},
+			[]string{"2014-05-17", "2014-05-18"},
+		},
+		{
+			"page 2 failing",
+			map[string]string{"/": schedulePage("2014-05-17", "Arsenal - Hull", "/?page=2")},
+			[]string{"/", "/?page=2", "/?page=2"},
+			[]string{"2014-05-17"},
+		},
+	} {
+		site := &pagedSite{pages: tc.pages}
+		fresh, _, err := fetchSchedule(testContext{t: t}, &http.Client{Transport: site}, tvmatchenUrl)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+
+		var dates []string
+		for date := range fresh {
+			dates = append(dates, date)
+		}
+
+		sort.Strings(dates)
+		if !reflect.DeepEqual(site.fetched, tc.fetched) || !reflect.DeepEqual(dates, tc.dates) {
+			t.Errorf("%s: fetched %q for days %q, want %q for %q", tc.name, site.fetched, dates, tc.fetched, tc.dates)
+		}
+	}
+
+	// Paging stops once enough days are found
+	prevDays := daysToShow
+	defer func() { daysToShow = prevDays }()
+	daysToShow = 1
+	site := &pagedSite{pages: map[string]string{
+		"/":        schedulePage("2014-05-17", "Arsenal - Hull", "/?page=2"),
+		"/?page=2": schedulePage("2014-05-18", "Chelsea - Everton", ""),
+	}}
+	if fresh, _, err := fetchSchedule(testContext{t: t}, &http.Client{Transport: site}, tvmatchenUrl); err != nil || len(fresh) != 1 || len(site.fetched) != 1 {
+		t.Errorf("with DAYS_TO_SHOW=1, fetched %q for %d days with error %v, want one page", site.fetched, len(fresh), err)
+	}
+
+	// A failing first page fails the fetch
+	site = &pagedSite{pages: map[string]string{}}
+	if _, _, err := fetchSchedule(testContext{t: t}, &http.Client{Transport: site}, tvmatchenUrl); err == nil {
+		t.Errorf("fetching an unavailable first page succeeded")
+	}
+}
+
+// Serves a page in the bytes and Content-Type given, for fetches from tests.
+type encodedTransport struct {
+	contentType string
+	body        []byte
+}
+
+func (et *encodedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     http.Header{"Content-Type": {et.contentType}},
+		Body:       ioutil.NopCloser(bytes.NewReader(et.body)),
+		Request:    r,
+	}, nil
+}
+
+// Encodes s in ISO-8859-1, which it must fit in.
+func latin1(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		b = append(b, byte(r))
+	}
+
+	return b
+}
+
+func TestFetchCharset(t *testing.T) {
+	meta := strings.Replace(selftestFixture, "<body>", `<head><meta charset="iso-8859-1"></head><body>`, 1)
+	for _, tc := range []struct {
+		name string
+		et   *encodedTransport
+	}{
+		{"utf-8", &encodedTransport{"text/html; charset=utf-8", []byte(selftestFixture)}},
+		{"unlabeled utf-8", &encodedTransport{"text/html", []byte(selftestFixture)}},
+		{"latin-1 header", &encodedTransport{"text/html; charset=ISO-8859-1", latin1(selftestFixture)}},
+		{"latin-1 meta", &encodedTransport{"text/html", latin1(meta)}},
+		{"unknown charset", &encodedTransport{"text/html; charset=x-unknown", []byte(selftestFixture)}},
+	} {
+		doc, err := fetchPage(&http.Client{Transport: tc.et}, tvmatchenUrl)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+
+		days := doc.Find(selectors.Day).Map(func(i int, s *goquery.Selection) string { return s.Text() })
+		if want := []string{"Lördag 17 maj", "Söndag 18 maj"}; !reflect.DeepEqual(days, want) {
+			t.Errorf("%s: days %q, want %q", tc.name, days, want)
+		}
+
+		if got := doc.Find(selectors.League).Last().Text(); !strings.Contains(got, "Premier League") {
+			t.Errorf("%s: last league %q", tc.name, got)
+		}
+	}
+}
+
+func TestRawTime(t *testing.T) {
+	day, _ := dayDate("2014-05-17")
+	for _, tc := range []struct {
+		raw, clock string
+		kickoff    time.Time
+	}{
+		{"16:00", "16:00", time.Date(2014, 5, 17, 16, 0, 0, 0, stockholm)},
+		{"\n\t\t 16:00  ", "16:00", time.Date(2014, 5, 17, 16, 0, 0, 0, stockholm)},
+		{"16:00-18:15", "16:00-18:15", time.Date(2014, 5, 17, 16, 0, 0, 0, stockholm)},
+		{"Ej klart", "Ej klart", time.Time{}},
+	} {
+		page := strings.Replace(selftestFixture, `<span class="field-content">16:00</span>`, `<span class="field-content">`+tc.raw+`</span>`, 1)
+		var arsenal *match
+		for _, m := range parseTestPage(t, page)["2014-05-17"] {
+			if m.Home == "Arsenal" {
+				arsenal = m
+			}
+		}
+
+		if arsenal == nil || arsenal.RawTime != tc.raw || arsenal.Time != tc.clock || !arsenal.Kickoff.Equal(tc.kickoff) {
+			t.Errorf("time %q parsed to %+v, want RawTime %q, Time %q and Kickoff %v", tc.raw, arsenal, tc.raw, tc.clock, tc.kickoff)
+		}
+
+		if got := parseKickoff(day, tc.clock); !got.Equal(tc.kickoff) {
+			t.Errorf("parseKickoff(%q) = %v, want %v", tc.clock, got, tc.kickoff)
+		}
+	}
+
+	// The raw time is in the JSON, the page shows the formatted one
+	inst, done := newInstance(t)
+	defer done()
+	m := testMatch("2014-05-17", "19:00", "Chelsea - Everton")
+	m.RawTime = " 19:00\n"
+	defer useSchedule(testNow, map[string][]*match{"2014-05-17": {m}})()
+
+	var list matchList
+	if err := json.Unmarshal(serve(t, inst, "/matches.json?clock=12", nil).Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list.Matches) != 1 || list.Matches[0].RawTime != m.RawTime || list.Matches[0].Time != "7:00 PM" {
+		t.Errorf("/matches.json?clock=12 = %+v, want RawTime %q and Time 7:00 PM", list.Matches, m.RawTime)
+	}
+}
+
+func TestMaxDataAge(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, map[string][]*match{"2014-05-17": {
+		testMatch("2014-05-17", "19:00", "Chelsea - Everton"),
+	}})()
+
+	for _, tc := range []struct {
+		age  time.Duration
+		want int
+	}{
+		{maxDataAge - time.Minute, http.StatusOK},
+		{maxDataAge + time.Minute, http.StatusServiceUnavailable},
+	} {
+		now = func() time.Time { return testNow.Add(tc.age) }
+		for _, path := range []string{"/", "/schedule.json", "/matches.json", "/schedule.ics", "/feed.xml"} {
+			w := serve(t, inst, path, nil)
+			if w.Code != tc.want {
+				t.Errorf("GET %s of a schedule %v old = %d, want %d", path, tc.age, w.Code, tc.want)
+			}
+
+			if w.Code == http.StatusOK && w.Header().Get("X-Data-Stale") != "true" {
+				t.Errorf("GET %s of a schedule %v old isn't flagged stale", path, tc.age)
+			}
+
+			var body errorBody
+			if strings.HasSuffix(path, ".json") && w.Code != http.StatusOK {
+				if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error.Code != errStale {
+					t.Errorf("GET %s of a schedule %v old = %s, want a %s error", path, tc.age, w.Body, errStale)
+				}
+			}
+		}
+	}
+}
+
+func TestTrailingSlash(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, map[string][]*match{"2014-05-17": {
+		testMatch("2014-05-17", "19:00", "Chelsea - Everton"),
+	}})()
+
+	for _, tc := range []struct {
+		path, location string
+		status         int
+	}{
+		{"/schedule.json/", "/schedule.json", http.StatusMovedPermanently},
+		{"/matches.json/?leagues=all&limit=1", "/matches.json?leagues=all&limit=1", http.StatusMovedPermanently},
+		{"/today/remaining.json/", "/today/remaining.json", http.StatusMovedPermanently},
+		{"/schedule.ics/", "/schedule.ics", http.StatusMovedPermanently},
+		{"/today/", "", http.StatusNotFound},
+		{"/bogus/", "", http.StatusNotFound},
+		{"/", "", http.StatusOK},
+	} {
+		w := serve(t, inst, tc.path, nil)
+		if w.Code != tc.status || w.Header().Get("Location") != tc.location {
+			t.Errorf("GET %s = %d to %q, want %d to %q", tc.path, w.Code, w.Header().Get("Location"), tc.status, tc.location)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package alexmatchen
+
+import "regexp"
+
+var (
+	// Elements whose whitespace is significant and left alone.
+	preformatted = regexp.MustCompile(`(?is)<(pre|textarea)\b.*?</(pre|textarea)>`)
+
+	whitespace = regexp.MustCompile(`[ \t\r\n]+`)
+)
+
+// Collapses each run of whitespace in rendered HTML to a single space,
+// except inside <pre> and <textarea>. A single space is kept rather than
+// none, as whitespace between inline elements renders as a space.
+func minifyHTML(page []byte) []byte {
+	out := make([]byte, 0, len(page))
+	last := 0
+	for _, loc := range preformatted.FindAllIndex(page, -1) {
+		out = append(out, whitespace.ReplaceAll(page[last:loc[0]], []byte(" "))...)
+		out = append(out, page[loc[0]:loc[1]]...)
+		last = loc[1]
+	}
+
+	out = append(out, whitespace.ReplaceAll(page[last:], []byte(" "))...)
+	return out
+}
@@ -0,0 +1,39 @@
+package alexmatchen
+
+import (
+	"net/http"
+	"testing"
+)
+
+// Minifies the page of the large schedule, reporting how much smaller it
+// gets.
+func BenchmarkMinify(b *testing.B) {
+	inst, done := newInstance(b)
+	defer done()
+
+	s, _ := parseSchedule(testContext{t: b}, largeDocument(b))
+	defer useSchedule(testNow, s)()
+
+	for _, view := range []string{"list", "grid", "print"} {
+		path := "/?view=" + view
+		if view == "list" {
+			path = "/"
+		}
+
+		w := serve(b, inst, path, nil)
+		if w.Code != http.StatusOK {
+			b.Fatalf("GET %s = %d %s", path, w.Code, w.Body)
+		}
+
+		page := w.Body.Bytes()
+		b.Run(view, func(b *testing.B) {
+			b.SetBytes(int64(len(page)))
+			var min []byte
+			for i := 0; i < b.N; i++ {
+				min = minifyHTML(page)
+			}
+
+			b.Logf("%d bytes minified to %d (%.0f%%)", len(page), len(min), 100*float64(len(min))/float64(len(page)))
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package alexmatchen
+
+import "net/http"
+
+// Response of /next.json. Match is null when there is no upcoming match.
+type nextMatch struct {
+	Match *match
+}
+
+func init() {
+	handle("/next.json", func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseTeamFilters(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		d, err := parseDisplay(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		if err := refreshScheduleIfNeeded(w, r); err != nil {
+			jsonRefreshError(w, err)
+			return
+		}
+
+		writeJSON(w, r, &nextMatch{Match: nextUpcoming(f.apply(d.format(scheduleAt(cachedSchedule(), now()))))})
+	})
+}
+
+// Like parseFilters, but for endpoints about a team's matches: a team plays
+// in whatever league it plays in, so without a leagues parameter every league
+// is searched rather than the default ones.
+func parseTeamFilters(r *http.Request) (*filters, error) {
+	f, err := parseFilters(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.FormValue("leagues") == "" {
+		f.Leagues = nil
+	}
+
+	return f, nil
+}
+
+// Returns the match in a schedule kicking off soonest that hasn't started
+// yet, or nil if there is none.
+func nextUpcoming(s map[string][]*match) *match {
+	for _, m := range flatten(s) {
+		if m.TimeKnown && m.Status == statusScheduled {
+			return m
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package alexmatchen
+
+import (
+	"appengine"
+	"appengine/urlfetch"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Result of /tasks/notify.
+type notifySummary struct {
+	Date     string
+	Matches  int
+	Webhooks []*webhookResult
+}
+
+// Outcome of posting to a webhook. Only the host is reported, as webhook
+// URLs carry their credentials.
+type webhookResult struct {
+	Host  string
+	Error string `json:",omitempty"`
+}
+
+func init() {
+	// Cron calls this every morning, see cron.yaml. Days without matches
+	// post nothing.
+	handle("/tasks/notify", func(w http.ResponseWriter, r *http.Request) {
+		if !cronOrAdmin(r) {
+			jsonError(w, http.StatusForbidden, errForbidden, "only callable by App Engine cron or admins")
+			return
+		}
+
+		// The filters are parsed like those of a request for the page
+		fr, err := http.NewRequest("GET", "/?"+notifyFilters, nil)
+		if err != nil {
+			jsonError(w, http.StatusInternalServerError, errInternal, err.Error())
+			return
+		}
+
+		f, err := parseFilters(fr)
+		if err != nil {
+			jsonError(w, http.StatusInternalServerError, errInternal, fmt.Sprintf("NOTIFY_FILTERS: %v", err))
+			return
+		}
+
+		if err := refreshScheduleIfNeeded(w, r); err != nil {
+			jsonRefreshError(w, err)
+			return
+		}
+
+		s := f.apply(scheduleAt(cachedSchedule(), now()))
+		dg := digestOf(s, now(), defaultLang)
+		summary := &notifySummary{Date: dg.Date, Matches: dg.Count, Webhooks: []*webhookResult{}}
+		if dg.Count == 0 {
+			writeJSON(w, r, summary)
+			return
+		}
+
+		today := append([]*match{}, s[dg.Date]...)
+		sort.Stable(byKickoff(today))
+		text := notifyText(today, defaultLang)
+
+		c := newContext(r)
+		status := http.StatusOK
+		for _, hook := range notifyWebhooks {
+			result := postWebhook(c, hook, text, dg)
+			if result.Error != "" {
+				c.Errorf("Posting to webhook at %s failed: %s", result.Host, result.Error)
+				status = http.StatusBadGateway
+			}
+
+			summary.Webhooks = append(summary.Webhooks, result)
+		}
+
+		writeJSONStatus(w, r, status, summary)
+	})
+}
+
+// Renders the message posted to chats: a heading, then a line per match in
+// the style of match.String.
+func notifyText(matches []*match, lang string) string {
+	lines := []string{translate(lang, "notify.title")}
+	for _, m := range matches {
+		lines = append(lines, m.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Posts the matches of the day to a webhook, as a chat message for the chat
+// services, see webhookBody.
+func postWebhook(c appengine.Context, hook, text string, dg *digest) *webhookResult {
+	u, err := url.Parse(hook)
+	if err != nil {
+		return &webhookResult{Error: "invalid webhook URL"}
+	}
+
+	result := &webhookResult{Host: u.Host}
+	body, err := json.Marshal(webhookBody(u.Host, text, dg))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	client := &http.Client{Transport: &urlfetch.Transport{Context: c, Deadline: fetchTimeout}}
+	req, err := http.NewRequest("POST", hook, bytes.NewReader(body))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		result.Error = fmt.Sprintf("unexpected status: %s", resp.Status)
+	}
+
+	return result
+}
+
+// Returns what is posted to a webhook at host: the message in the field the
+// chat service reads, or the digest for generic webhooks.
+func webhookBody(host, text string, dg *digest) interface{} {
+	switch strings.ToLower(host) {
+	case "hooks.slack.com", "api.telegram.org":
+		return map[string]string{"text": text}
+	case "discord.com", "discordapp.com":
+		return map[string]string{"content": text}
+	default:
+		return dg
+	}
+}
@@ -0,0 +1,234 @@
+package alexmatchen
+
+import (
+	"appengine/datastore"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Where the overrides of /admin/config are persisted, a single entity.
+	overridesKind = "Config"
+	overridesID   = "current"
+
+	// How often each instance reloads the overrides, so changes made on
+	// another instance are picked up within this long.
+	overridesMaxAge = time.Minute
+
+	// Shortest refresh interval an override may set, to spare upstream.
+	minCacheDuration = 15 * time.Minute
+
+	// Most days an override may show.
+	maxDaysToShow = 31
+)
+
+// Settings operators can change at runtime on /admin/config, without
+// redeploying. Zero values leave the built in default or app.yaml setting.
+type overrides struct {
+	// Leagues shown when a request doesn't choose its own, see leagues.
+	Leagues []string `json:",omitempty"`
+
+	// See DAYS_TO_SHOW.
+	DaysToShow int `json:",omitempty"`
+
+	// How long a refreshed schedule lasts before the next refresh is due,
+	// e.g. "6h", see cacheDuration.
+	CacheDuration string `json:",omitempty"`
+
+	Updated time.Time
+}
+
+// Body of /admin/config: the overrides stored, and the settings in effect.
+type configBody struct {
+	Overrides *overrides
+	Effective struct {
+		Leagues       []string
+		DaysToShow    int
+		CacheDuration string
+	}
+}
+
+var (
+	// The overrides in effect, replaced whole rather than changed, and when
+	// they were last loaded. Guarded by overridesMu.
+	currentOverrides = &overrides{}
+	overridesLoaded  time.Time
+	overridesMu      sync.RWMutex
+
+	// Held while reloading the overrides, so only one request at a time
+	// loads them from datastore.
+	reloadingOverrides = make(chan bool, 1)
+)
+
+func init() {
+	// Answers the overrides and the settings in effect, and replaces the
+	// overrides with a posted body. Posting {} goes back to the defaults.
+	handle("/admin/config", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			o := &overrides{}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(o); err != nil {
+				jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+				return
+			}
+
+			if err := o.check(); err != nil {
+				jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+				return
+			}
+
+			o.Updated = time.Now()
+			c := newContext(r)
+			if _, err := datastore.Put(c, datastore.NewKey(c, overridesKind, overridesID, 0, nil), o); err != nil {
+				jsonError(w, http.StatusInternalServerError, errInternal, err.Error())
+				return
+			}
+
+			setOverrides(o)
+			c.Infof("Config changed to %+v", o)
+		}
+
+		overridesMu.RLock()
+		body := &configBody{Overrides: currentOverrides}
+		overridesMu.RUnlock()
+		body.Effective.Leagues = defaultLeagues()
+		body.Effective.DaysToShow = shownDays()
+		body.Effective.CacheDuration = refreshInterval().String()
+		writeJSON(w, r, body)
+	}))
+}
+
+// Checks posted overrides, normalizing the leagues.
+func (o *overrides) check() error {
+	var leagues []string
+	for _, league := range o.Leagues {
+		if league = normalizeText(league); league != "" {
+			leagues = append(leagues, league)
+		}
+	}
+
+	o.Leagues = leagues
+	if o.DaysToShow < 0 || o.DaysToShow > maxDaysToShow {
+		return fmt.Errorf("DaysToShow must be at most %d, or 0 for the default, got %d", maxDaysToShow, o.DaysToShow)
+	}
+
+	if o.CacheDuration != "" {
+		d, err := time.ParseDuration(o.CacheDuration)
+		if err != nil || d < minCacheDuration {
+			return fmt.Errorf("CacheDuration must be a duration of at least %v, got %q", minCacheDuration, o.CacheDuration)
+		}
+	}
+
+	return nil
+}
+
+// Wraps a handler so the overrides are reloaded before it runs once they're
+// older than overridesMaxAge. Only one request reloads them. Others run with
+// the overrides in effect meanwhile rather than wait, unless they were never
+// loaded.
+func withOverrides(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if stale, loaded := overridesAge(); stale {
+			if loaded {
+				select {
+				case reloadingOverrides <- true:
+					reloadOverrides(r)
+				default:
+				}
+			} else {
+				reloadingOverrides <- true
+				reloadOverrides(r)
+			}
+		}
+
+		h(w, r)
+	}
+}
+
+// Reports whether the overrides are due a reload, and whether they were ever
+// loaded.
+func overridesAge() (stale, loaded bool) {
+	overridesMu.RLock()
+	defer overridesMu.RUnlock()
+	return time.Since(overridesLoaded) > overridesMaxAge, !overridesLoaded.IsZero()
+}
+
+// Loads the stored overrides while holding reloadingOverrides, unless the
+// request that held it before has just done so.
+func reloadOverrides(r *http.Request) {
+	defer func() { <-reloadingOverrides }()
+	if stale, _ := overridesAge(); stale {
+		loadOverrides(r)
+	}
+}
+
+// Loads the stored overrides. Failures keep the overrides in effect, and are
+// tried again after overridesMaxAge.
+func loadOverrides(r *http.Request) {
+	c := newContext(r)
+	o := &overrides{}
+	switch err := datastore.Get(c, datastore.NewKey(c, overridesKind, overridesID, 0, nil), o); err {
+	case nil, datastore.ErrNoSuchEntity:
+		setOverrides(o)
+	default:
+		c.Warningf("Loading config overrides failed: %v", err)
+		overridesMu.Lock()
+		overridesLoaded = time.Now()
+		overridesMu.Unlock()
+	}
+}
+
+func setOverrides(o *overrides) {
+	overridesMu.Lock()
+	currentOverrides = o
+	overridesLoaded = time.Now()
+	overridesMu.Unlock()
+}
+
+// Returns a version of the overrides in effect, changing whenever they do.
+func overridesVersion() string {
+	overridesMu.RLock()
+	defer overridesMu.RUnlock()
+	if currentOverrides.Updated.IsZero() {
+		return "0"
+	}
+
+	return strconv.FormatInt(currentOverrides.Updated.UnixNano(), 36)
+}
+
+// Returns the leagues shown when a request doesn't choose its own.
+func defaultLeagues() []string {
+	overridesMu.RLock()
+	defer overridesMu.RUnlock()
+	if len(currentOverrides.Leagues) > 0 {
+		return currentOverrides.Leagues
+	}
+
+	return leagues
+}
+
+// Returns the number of days scraped, stored and shown.
+func shownDays() int {
+	overridesMu.RLock()
+	defer overridesMu.RUnlock()
+	if currentOverrides.DaysToShow > 0 {
+		return currentOverrides.DaysToShow
+	}
+
+	return daysToShow
+}
+
+// Returns how long a refreshed schedule lasts before the next refresh is
+// due.
+func refreshInterval() time.Duration {
+	overridesMu.RLock()
+	defer overridesMu.RUnlock()
+	if d, err := time.ParseDuration(currentOverrides.CacheDuration); err == nil && currentOverrides.CacheDuration != "" {
+		return d
+	}
+
+	return cacheDuration
+}
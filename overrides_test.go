@@ -0,0 +1,53 @@
+package alexmatchen
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestOverridesReload(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, map[string][]*match{"2014-05-18": {
+		testMatch("2014-05-18", "18:00", "Liverpool - Newcastle", "Viasat Fotboll"),
+	}})()
+
+	overridesMu.Lock()
+	prev, prevLoaded := currentOverrides, overridesLoaded
+	stale := &overrides{DaysToShow: 3}
+	staleLoaded := time.Now().Add(-2 * overridesMaxAge)
+	currentOverrides, overridesLoaded = stale, staleLoaded
+	overridesMu.Unlock()
+	defer func() {
+		overridesMu.Lock()
+		currentOverrides, overridesLoaded = prev, prevLoaded
+		overridesMu.Unlock()
+	}()
+
+	// Another request is reloading, so this one goes on with the overrides
+	// in effect
+	reloadingOverrides <- true
+	within(t, "GET /schedule.json", func() {
+		if w := serve(t, inst, "/schedule.json", nil); w.Code != http.StatusOK {
+			t.Errorf("GET /schedule.json while reloading = %d, want 200", w.Code)
+		}
+	})
+	<-reloadingOverrides
+
+	overridesMu.RLock()
+	if currentOverrides != stale || !overridesLoaded.Equal(staleLoaded) {
+		t.Errorf("overrides reloaded while another request was reloading them")
+	}
+	overridesMu.RUnlock()
+
+	// Once it's done they're reloaded as usual
+	serve(t, inst, "/schedule.json", nil)
+	if shownDays() != daysToShow {
+		t.Errorf("overrides show %d days after reloading, want the stored none to leave %d", shownDays(), daysToShow)
+	}
+
+	if stale, _ := overridesAge(); stale {
+		t.Error("overrides still stale after reloading")
+	}
+}
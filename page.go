@@ -0,0 +1,62 @@
+package alexmatchen
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// A page of days of /schedule.json, see parsePage. NextCursor is null on the
+// last page.
+type dayPage struct {
+	Days       []*day
+	NextCursor *string
+}
+
+// Parses the cursor pagination parameters: ?pageDays=3 for the number of
+// days per page, and ?cursor=2014-05-18 for the date of the last day already
+// loaded. Either one asks for a page, by default of daysToShow days from the
+// first day.
+func parsePage(r *http.Request) (cursor string, pageDays int, paged bool, err error) {
+	if v := r.FormValue("cursor"); v != "" {
+		if _, ok := dayDate(v); !ok {
+			return "", 0, false, fmt.Errorf("cursor must be a YYYY-MM-DD date, got %q", v)
+		}
+
+		cursor, paged = v, true
+	}
+
+	pageDays = shownDays()
+	if v := r.FormValue("pageDays"); v != "" {
+		if pageDays, err = strconv.Atoi(v); err != nil || pageDays < 1 {
+			return "", 0, false, fmt.Errorf("pageDays must be a positive integer, got %q", v)
+		}
+
+		paged = true
+	}
+
+	return cursor, pageDays, paged, nil
+}
+
+// Returns the page of at most n days following the cursor date. Cursors are
+// dates rather than offsets, so a page stays right when old days drop off
+// the start of the schedule between requests.
+func pageOf(days []*day, cursor string, n int) *dayPage {
+	start := 0
+	for start < len(days) && cursor != "" && days[start].Date <= cursor {
+		start++
+	}
+
+	end := start + n
+	if end > len(days) {
+		end = len(days)
+	}
+
+	page := &dayPage{Days: days[start:end]}
+	if end < len(days) {
+		next := days[end-1].Date
+		page.NextCursor = &next
+	}
+
+	return page
+}
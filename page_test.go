@@ -0,0 +1,94 @@
+package alexmatchen
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// Returns the dates of days.
+func dayDates(days []*day) []string {
+	dates := []string{}
+	for _, d := range days {
+		dates = append(dates, d.Date)
+	}
+
+	return dates
+}
+
+func TestPageOf(t *testing.T) {
+	var days []*day
+	for _, date := range []string{"2014-05-17", "2014-05-18", "2014-05-19", "2014-05-20", "2014-05-21"} {
+		days = append(days, &day{Date: date})
+	}
+
+	for _, tc := range []struct {
+		cursor string
+		n      int
+		want   []string
+		next   string
+	}{
+		{"", 2, []string{"2014-05-17", "2014-05-18"}, "2014-05-18"},
+		{"2014-05-18", 2, []string{"2014-05-19", "2014-05-20"}, "2014-05-20"},
+		{"2014-05-20", 2, []string{"2014-05-21"}, ""},
+		{"2014-05-21", 2, []string{}, ""},
+		{"", 5, []string{"2014-05-17", "2014-05-18", "2014-05-19", "2014-05-20", "2014-05-21"}, ""},
+
+		// The cursor's day has dropped off the start, or is between days
+		{"2014-05-16", 2, []string{"2014-05-17", "2014-05-18"}, "2014-05-18"},
+		{"2014-05-18T", 1, []string{"2014-05-19"}, "2014-05-19"},
+	} {
+		page := pageOf(days, tc.cursor, tc.n)
+		next := ""
+		if page.NextCursor != nil {
+			next = *page.NextCursor
+		}
+
+		if got := dayDates(page.Days); !reflect.DeepEqual(got, tc.want) || next != tc.next {
+			t.Errorf("page of %d after %q = %q next %q, want %q next %q", tc.n, tc.cursor, got, next, tc.want, tc.next)
+		}
+	}
+}
+
+// Following the cursors of /schedule.json loads every day once.
+func TestSchedulePages(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, map[string][]*match{
+		"2014-05-17": {testMatch("2014-05-17", "19:00", "Chelsea - Everton")},
+		"2014-05-18": {testMatch("2014-05-18", "16:00", "Liverpool - Newcastle")},
+		"2014-05-19": {},
+		"2014-05-20": {testMatch("2014-05-20", "20:00", "Stoke - Fulham")},
+	})()
+
+	var loaded []string
+	path := "/schedule.json?pageDays=3"
+	for pages := 0; ; pages++ {
+		if pages > 4 {
+			t.Fatalf("still paging after %q", loaded)
+		}
+
+		var page dayPage
+		if err := json.Unmarshal(serve(t, inst, path, nil).Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+
+		loaded = append(loaded, dayDates(page.Days)...)
+		if page.NextCursor == nil {
+			break
+		}
+
+		path = "/schedule.json?pageDays=3&cursor=" + *page.NextCursor
+	}
+
+	if want := []string{"2014-05-17", "2014-05-18", "2014-05-19", "2014-05-20"}; !reflect.DeepEqual(loaded, want) {
+		t.Errorf("pages loaded %q, want %q", loaded, want)
+	}
+
+	for _, query := range []string{"pageDays=0", "pageDays=three", "cursor=yesterday"} {
+		if w := serve(t, inst, "/schedule.json?"+query, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("/schedule.json?%s = %d, want 400", query, w.Code)
+		}
+	}
+}
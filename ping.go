@@ -0,0 +1,55 @@
+package alexmatchen
+
+import (
+	"net/http"
+	"time"
+)
+
+// Body of /admin/ping. Reachable is true if upstream answered at all, with
+// any status.
+type ping struct {
+	URL       string
+	Reachable bool
+	Status    int `json:",omitempty"`
+	LatencyMs int64
+	Error     string `json:",omitempty"`
+}
+
+func init() {
+	// Checks that upstream answers, without parsing or caching anything, to
+	// tell an upstream outage from a broken parser
+	handle("/admin/ping", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		p := pingUpstream(fetchClient(newContext(r)), primaryURL)
+		status := http.StatusOK
+		if !p.Reachable {
+			status = http.StatusBadGateway
+		}
+
+		writeJSONStatus(w, r, status, p)
+	}))
+}
+
+// Sends a HEAD request to source, timing the answer by the wall clock rather
+// than now.
+func pingUpstream(client *http.Client, source string) *ping {
+	p := &ping{URL: source}
+	req, err := http.NewRequest("HEAD", source, nil)
+	if err != nil {
+		p.Error = err.Error()
+		return p
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+	start := time.Now()
+	resp, err := client.Do(req)
+	p.LatencyMs = int64(time.Since(start) / time.Millisecond)
+	if err != nil {
+		p.Error = err.Error()
+		return p
+	}
+
+	resp.Body.Close()
+	p.Reachable = true
+	p.Status = resp.StatusCode
+	return p
+}
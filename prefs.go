@@ -0,0 +1,237 @@
+package alexmatchen
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Seconds the preferences are kept, a year.
+const prefsMaxAge = 365 * 24 * 60 * 60
+
+// Parameters whose values can be saved on /preferences, each in a cookie of
+// the same name, for requests that don't give the parameter themselves.
+var savedParams = []string{"leagues", "sports", "favorites"}
+
+// Page choosing the sports and leagues shown, see /preferences.
+var prefsPage = template.Must(template.New("preferences").Funcs(templateFuncs).Parse(prefsTemplate))
+
+type (
+	prefsData struct {
+		Lang   string
+		Sports []*prefsSport
+
+		// The saved favorite teams, comma separated as typed.
+		Favorites string
+	}
+
+	// A sport and its leagues in the cached schedule. Matches of an unknown
+	// sport are listed under one with an empty name.
+	prefsSport struct {
+		Name    string
+		Chosen  bool
+		Leagues []*prefsLeague
+	}
+
+	prefsLeague struct {
+		// The league as saved, scoped to its sport if it has one, e.g.
+		// "Ishockey:SHL".
+		Value  string
+		Name   string
+		Chosen bool
+	}
+)
+
+func init() {
+	// Lists the sports and leagues of the cached schedule to choose from,
+	// along with the favorite teams, and saves the choice in cookies when
+	// posted. Posting no leagues or no sports goes back to the defaults for
+	// them. Only posts from the page itself are saved, as another site could
+	// otherwise post a form changing them, see sameOrigin.
+	handle("/preferences", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			if !sameOrigin(r) {
+				newContext(r).Warningf("Denied preferences posted from %q, referred by %q", r.Header.Get("Origin"), r.Header.Get("Referer"))
+				http.Error(w, "preferences can only be saved from this site", http.StatusForbidden)
+				return
+			}
+
+			r.ParseForm()
+			savePreference(w, "leagues", r.PostForm["league"])
+			savePreference(w, "sports", r.PostForm["sport"])
+			savePreference(w, "favorites", splitList(r.PostForm.Get("favorites")))
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		d, err := parseDisplay(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := refreshScheduleIfNeeded(w, r); err != nil {
+			http.Error(w, err.Error(), refreshErrorStatus(err))
+			return
+		}
+
+		chosenLeagues := splitList(preference(r, "leagues"))
+		if len(chosenLeagues) == 0 {
+			chosenLeagues = defaultLeagues()
+		}
+
+		chosenSports := splitList(preference(r, "sports"))
+		if len(chosenSports) == 0 {
+			chosenSports = defaultSports
+		}
+
+		data := &prefsData{
+			Lang:      d.lang(),
+			Sports:    prefsSports(scheduleAt(cachedSchedule(), now()), chosenSports, chosenLeagues),
+			Favorites: strings.Join(d.Favorites, ", "),
+		}
+		var b bytes.Buffer
+		if err := prefsPage.Execute(&b, data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// The page shows this visitor's choice
+		w.Header().Set("Cache-Control", "private, no-cache")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(b.Bytes())
+	})
+}
+
+// Returns the sports of a schedule in alphabetical order, each with its
+// leagues, marking those chosen.
+func prefsSports(s map[string][]*match, chosenSports, chosenLeagues []string) []*prefsSport {
+	bySport := make(map[string]map[string]bool)
+	for _, matches := range s {
+		for _, m := range matches {
+			if bySport[m.Sport] == nil {
+				bySport[m.Sport] = make(map[string]bool)
+			}
+
+			if m.League != "" {
+				bySport[m.Sport][m.League] = true
+			}
+		}
+	}
+
+	names := make([]string, 0, len(bySport))
+	for sport := range bySport {
+		names = append(names, sport)
+	}
+
+	sort.Strings(names)
+	out := make([]*prefsSport, len(names))
+	for i, sport := range names {
+		out[i] = &prefsSport{Name: sport, Chosen: equalsAny(sport, chosenSports)}
+		for league := range bySport[sport] {
+			value := league
+			if sport != "" {
+				value = sport + ":" + league
+			}
+
+			chosen := inLeague(&match{Sport: sport, League: league}, chosenLeagues)
+			out[i].Leagues = append(out[i].Leagues, &prefsLeague{Value: value, Name: league, Chosen: chosen})
+		}
+
+		sort.Sort(byPrefsLeague(out[i].Leagues))
+	}
+
+	return out
+}
+
+type byPrefsLeague []*prefsLeague
+
+func (s byPrefsLeague) Len() int           { return len(s) }
+func (s byPrefsLeague) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byPrefsLeague) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+// Reports whether a request comes from a page of this site, by its Origin
+// header or else its Referer. Requests with neither are refused too, as
+// browsers send at least one when posting a form.
+func sameOrigin(r *http.Request) bool {
+	from := r.Header.Get("Origin")
+	if from == "" {
+		from = r.Header.Get("Referer")
+	}
+
+	u, err := url.Parse(from)
+	return from != "" && err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// Saves the values of a parameter in its cookie, or clears the cookie when
+// there are none.
+func savePreference(w http.ResponseWriter, param string, values []string) {
+	var saved []string
+	for _, v := range values {
+		// Commas would split a value in two when read back
+		if v = normalizeText(v); v != "" && !strings.Contains(v, ",") {
+			saved = append(saved, v)
+		}
+	}
+
+	cookie := &http.Cookie{Name: param, Path: "/", MaxAge: prefsMaxAge, HttpOnly: true}
+	if len(saved) > 0 {
+		cookie.Value = url.QueryEscape(strings.Join(saved, ","))
+	} else {
+		cookie.MaxAge = -1
+	}
+
+	http.SetCookie(w, cookie)
+}
+
+// Returns the value of a parameter saved on /preferences, or "" if none is
+// saved.
+func preference(r *http.Request, param string) string {
+	cookie, err := r.Cookie(param)
+	if err != nil {
+		return ""
+	}
+
+	v, err := url.QueryUnescape(cookie.Value)
+	if err != nil {
+		return ""
+	}
+
+	return v
+}
+
+const prefsTemplate = `
+<html>
+	<head>
+		<title>{{t .Lang "prefs.title"}}</title>
+		<meta charset="utf-8" />
+		<meta name="viewport" content="width=device-width, initial-scale=1" />
+		<link rel="icon" href="/favicon.ico" type="image/x-icon">
+		<style type="text/css">
+			body { margin: 8px; font-family: arial; font-size: 14px; color: #333333; background: #efefef; }
+			h3 { margin: 12px 0 4px; }
+			ul { list-style: none; margin: 0 0 8px 0; padding: 0; }
+			li { padding: 3px 0; }
+		</style>
+	</head>
+	<body>
+		<h2>{{t .Lang "prefs.title"}}</h2>
+		<form method="post" action="/preferences">
+			{{range $sport := .Sports}}
+				{{if $sport.Name}}<h3><label><input type="checkbox" name="sport" value="{{$sport.Name}}"{{if $sport.Chosen}} checked{{end}} /> {{$sport.Name}}</label></h3>{{end}}
+				<ul>
+					{{range $league := $sport.Leagues}}
+						<li><label><input type="checkbox" name="league" value="{{$league.Value}}"{{if $league.Chosen}} checked{{end}} /> {{$league.Name}}</label></li>
+					{{end}}
+				</ul>
+			{{end}}
+			<h3><label for="favorites">{{t .Lang "prefs.favorites"}}</label></h3>
+			<p><input type="text" id="favorites" name="favorites" value="{{.Favorites}}" placeholder="Arsenal, Hammarby" /></p>
+			<button type="submit">{{t .Lang "prefs.save"}}</button>
+		</form>
+	</body>
+</html>
+`
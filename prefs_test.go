@@ -0,0 +1,49 @@
+package alexmatchen
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPreferencesOrigin(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+
+	for _, tc := range []struct {
+		name   string
+		header http.Header
+		saved  bool
+	}{
+		{"origin", http.Header{"Origin": {"http://{host}"}}, true},
+		{"referer", http.Header{"Referer": {"http://{host}/preferences?lang=en"}}, true},
+		{"other origin", http.Header{"Origin": {"https://evil.example"}, "Referer": {"http://{host}/preferences"}}, false},
+		{"other referer", http.Header{"Referer": {"https://evil.example/{host}"}}, false},
+		{"null origin", http.Header{"Origin": {"null"}}, false},
+		{"neither", nil, false},
+	} {
+		r, err := inst.NewRequest("POST", "/preferences", strings.NewReader("league=Premier+League&favorites=Arsenal"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for name, values := range tc.header {
+			for _, v := range values {
+				r.Header.Add(name, strings.Replace(v, "{host}", r.Host, 1))
+			}
+		}
+
+		w := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(w, r)
+		saved := w.Code == http.StatusSeeOther && strings.Contains(w.Header().Get("Set-Cookie"), "leagues=")
+		if saved != tc.saved {
+			t.Errorf("%s: POST /preferences = %d, cookies %q, want saved %v", tc.name, w.Code, w.Header()["Set-Cookie"], tc.saved)
+		}
+
+		if !tc.saved && w.Code != http.StatusForbidden {
+			t.Errorf("%s: POST /preferences = %d, want 403", tc.name, w.Code)
+		}
+	}
+}
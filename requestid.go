@@ -0,0 +1,78 @@
+package alexmatchen
+
+import (
+	"appengine"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+)
+
+// Header carrying the correlation ID of a request, taken from the client when
+// given and generated otherwise.
+const requestIDHeader = "X-Request-ID"
+
+// Client supplied request IDs are only trusted when they look like IDs.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Registers a handler wrapped in the middleware shared by every endpoint.
+func handle(pattern string, h http.HandlerFunc) {
+	http.HandleFunc(pattern, withRequestID(withTiming(withCORS(withOverrides(h)))))
+}
+
+// Registers a probe like handle, but without the middleware that may wait on
+// locks or datastore, such as withOverrides reloading the overrides.
+func handleProbe(pattern string, h http.HandlerFunc) {
+	http.HandleFunc(pattern, withRequestID(withTiming(h)))
+}
+
+// Makes sure a request has an ID, and echoes it in the response.
+func withRequestID(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+			r.Header.Set(requestIDHeader, id)
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		h(w, r)
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// An App Engine context prefixing every log line with the request ID.
+type requestContext struct {
+	appengine.Context
+	prefix string
+}
+
+// Returns the App Engine context of a request, logging with its request ID.
+func newContext(r *http.Request) appengine.Context {
+	return &requestContext{appengine.NewContext(r), "[" + r.Header.Get(requestIDHeader) + "] "}
+}
+
+func (c *requestContext) Debugf(format string, args ...interface{}) {
+	c.Context.Debugf(c.prefix+format, args...)
+}
+
+func (c *requestContext) Infof(format string, args ...interface{}) {
+	c.Context.Infof(c.prefix+format, args...)
+}
+
+func (c *requestContext) Warningf(format string, args ...interface{}) {
+	c.Context.Warningf(c.prefix+format, args...)
+}
+
+func (c *requestContext) Errorf(format string, args ...interface{}) {
+	c.Context.Errorf(c.prefix+format, args...)
+}
+
+func (c *requestContext) Criticalf(format string, args ...interface{}) {
+	c.Context.Criticalf(c.prefix+format, args...)
+}
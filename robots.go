@@ -0,0 +1,27 @@
+package alexmatchen
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Crawling policy served as /robots.txt, keeping crawlers off the admin and
+// task endpoints. /cron/ is where cron used to call.
+const defaultRobots = `User-agent: *
+Allow: /
+Disallow: /admin/
+Disallow: /tasks/
+Disallow: /refresh
+Disallow: /cron/
+`
+
+// The ROBOTS_TXT setting replaces the default policy. Lines are separated by
+// "\n" in the setting, as app.yaml values are single lines.
+var robots = strings.Replace(setting("ROBOTS_TXT", defaultRobots), `\n`, "\n", -1)
+
+func init() {
+	handle("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(robots))
+	})
+}
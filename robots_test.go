@@ -0,0 +1,26 @@
+package alexmatchen
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDefaultRobots(t *testing.T) {
+	var disallowed []string
+	for _, line := range strings.Split(defaultRobots, "\n") {
+		if strings.HasPrefix(line, "Disallow: ") {
+			disallowed = append(disallowed, strings.TrimPrefix(line, "Disallow: "))
+		}
+	}
+
+	for _, path := range []string{"/admin/config", "/tasks/refresh", "/tasks/notify", "/refresh", "/cron/refresh"} {
+		blocked := false
+		for _, prefix := range disallowed {
+			blocked = blocked || strings.HasPrefix(path, prefix)
+		}
+
+		if !blocked {
+			t.Errorf("robots.txt lets crawlers fetch %s", path)
+		}
+	}
+}
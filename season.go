@@ -0,0 +1,69 @@
+package alexmatchen
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A yearly window of dates between two month-days, inclusive. Windows whose
+// end is before their start wrap around the new year.
+type seasonWindow struct {
+	from, to int // month*100 + day
+}
+
+// Windows during which an empty schedule is expected rather than a sign of
+// trouble, from the OFF_SEASON setting, e.g. "06-01..07-31,12-20..01-05".
+var offSeasons = parseSeasons("OFF_SEASON", setting("OFF_SEASON", ""))
+
+// Parses a comma separated list of MM-DD..MM-DD windows.
+func parseSeasons(name, v string) []seasonWindow {
+	var windows []seasonWindow
+	for _, item := range splitList(v) {
+		ends := strings.SplitN(item, "..", 2)
+		if len(ends) != 2 {
+			panic(fmt.Sprintf("invalid setting %s=%q: %q is not MM-DD..MM-DD", name, v, item))
+		}
+
+		var w seasonWindow
+		for i, end := range ends {
+			d, err := time.Parse("01-02", strings.TrimSpace(end))
+			if err != nil {
+				panic(fmt.Sprintf("invalid setting %s=%q: %v", name, v, err))
+			}
+
+			if i == 0 {
+				w.from = monthDay(d)
+			} else {
+				w.to = monthDay(d)
+			}
+		}
+
+		windows = append(windows, w)
+	}
+
+	return windows
+}
+
+func monthDay(t time.Time) int {
+	return int(t.Month())*100 + t.Day()
+}
+
+// Reports whether t, in Swedish time, is within an off-season window.
+func inOffSeason(t time.Time) bool {
+	md := monthDay(t.In(stockholm))
+	for _, w := range offSeasons {
+		if w.from <= w.to && md >= w.from && md <= w.to ||
+			w.from > w.to && (md >= w.from || md <= w.to) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reports whether the schedule is empty because it's the off-season, which
+// is then shown as such rather than treated as a failure.
+func offSeasonEmpty(s map[string][]*match) bool {
+	return countMatches(s) == 0 && inOffSeason(now())
+}
@@ -0,0 +1,95 @@
+package alexmatchen
+
+import (
+	"appengine/memcache"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOffSeason(t *testing.T) {
+	prevSeasons := offSeasons
+	defer func() { offSeasons = prevSeasons }()
+	offSeasons = parseSeasons("OFF_SEASON", "06-01..07-31, 12-20..01-05")
+
+	at := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, stockholm)
+	}
+
+	for _, tc := range []struct {
+		t    time.Time
+		want bool
+	}{
+		{at(2014, 5, 31, 23, 59), false},
+		{at(2014, 6, 1, 0, 0), true},
+		{at(2014, 7, 31, 23, 59), true},
+		{at(2014, 8, 1, 0, 0), false},
+		{at(2014, 12, 19, 23, 59), false},
+		{at(2014, 12, 20, 0, 0), true},
+		{at(2015, 1, 5, 23, 59), true},
+		{at(2015, 1, 6, 0, 0), false},
+
+		// Windows are of Swedish dates, whatever the clock's zone
+		{time.Date(2014, 5, 31, 22, 30, 0, 0, time.UTC), true},
+		{time.Date(2014, 7, 31, 22, 30, 0, 0, time.UTC), false},
+	} {
+		if got := inOffSeason(tc.t); got != tc.want {
+			t.Errorf("inOffSeason(%s) = %v, want %v", tc.t.Format(time.RFC3339), got, tc.want)
+		}
+	}
+
+	for _, v := range []string{"06-01", "06-01..07-32", "June..July"} {
+		if !panics(func() { parseSeasons("OFF_SEASON", v) }) {
+			t.Errorf("OFF_SEASON=%q parsed, want a panic", v)
+		}
+	}
+}
+
+// An empty schedule in the off-season says so on the page and isn't stale.
+func TestOffSeasonEmpty(t *testing.T) {
+	prevSeasons := offSeasons
+	defer func() { offSeasons = prevSeasons }()
+	offSeasons = parseSeasons("OFF_SEASON", "06-01..07-31")
+
+	inst, done := newInstance(t)
+	defer done()
+
+	for _, tc := range []struct {
+		name     string
+		at       time.Time
+		s        map[string][]*match
+		expected bool
+	}{
+		{"off-season", time.Date(2014, 6, 15, 12, 0, 0, 0, stockholm), map[string][]*match{}, true},
+		{"in season", testNow, map[string][]*match{}, false},
+		{"off-season with matches", time.Date(2014, 6, 15, 12, 0, 0, 0, stockholm), map[string][]*match{"2014-06-15": {testMatch("2014-06-15", "19:00", "Chelsea - Everton")}}, false},
+	} {
+		// Shared snapshots of the same empty schedule would last across
+		// the jumps of the clock
+		restore := useSchedule(tc.at, tc.s)
+		r, err := inst.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		memcache.Flush(newContext(r))
+		if got := offSeasonEmpty(tc.s); got != tc.expected {
+			t.Errorf("%s: offSeasonEmpty = %v, want %v", tc.name, got, tc.expected)
+		}
+
+		page := serve(t, inst, "/", nil).Body.String()
+		if got := strings.Contains(page, `class="off-season"`); got != tc.expected {
+			t.Errorf("%s: page shows the off-season message %v, want %v", tc.name, got, tc.expected)
+		}
+
+		// An overdue refresh marks responses stale, unless none is expected
+		now = func() time.Time { return tc.at.Add(2 * refreshInterval()) }
+		w := httptest.NewRecorder()
+		setFreshnessHeaders(w)
+		if stale := w.Header().Get("X-Data-Stale") == "true"; stale == tc.expected {
+			t.Errorf("%s: an overdue refresh is flagged stale %v, want %v", tc.name, stale, !tc.expected)
+		}
+		restore()
+	}
+}
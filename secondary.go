@@ -0,0 +1,250 @@
+package alexmatchen
+
+import (
+	"appengine"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	// Optional JSON source of matches missing from tvmatchen.nu, such as
+	// streaming only ones: a list of matches in the schema of /matches.json.
+	// Unset disables it.
+	secondaryURL = setting("SECONDARY_URL", "")
+
+	// Source reported for matches from the secondary source, by default
+	// its host.
+	secondaryName = setting("SECONDARY_NAME", hostOf(secondaryURL))
+
+	// Listings fetched alongside tvmatchen.nu on every refresh, whose
+	// matches are merged into the scraped ones, see addListing.
+	listings = configuredListings()
+)
+
+// A source of matches besides tvmatchen.nu. Another site is added by
+// implementing it and listing it in configuredListings.
+type listing interface {
+	// Reported as the Source of the matches of the listing.
+	name() string
+
+	// Fetches the matches of the listing. Matches need a Name and Kickoff,
+	// while the rest is filled in like for the secondary source.
+	fetch(c appengine.Context) ([]*match, error)
+}
+
+// The secondary source at SECONDARY_URL, a list of matches in the schema of
+// /matches.json.
+type jsonListing struct {
+	url    string
+	source string
+}
+
+func configuredListings() []listing {
+	var out []listing
+	if secondaryURL != "" {
+		out = append(out, &jsonListing{url: secondaryURL, source: secondaryName})
+	}
+
+	return out
+}
+
+func hostOf(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+
+	return u.Host
+}
+
+// Adds the matches fetched from a listing to a freshly parsed schedule.
+// Matches already in the schedule, by ID or by teams and kickoff, are merged
+// with the listed ones rather than listed twice, see mergeListed. Listed
+// matches outside the days shown are dropped. Only the fields describing the
+// fixture are taken from a listing, while the rest are set here or at request
+// time, as for scraped matches. If the listing failed, the schedule is left
+// as it is.
+func addListing(c appengine.Context, fresh map[string][]*match, l listing, matches []*match, err error) {
+	if err != nil {
+		c.Warningf("Skipping listing %s: %v", l.name(), err)
+		return
+	}
+
+	today := midnight(now())
+	end := today.AddDate(0, 0, shownDays())
+	extra := make(map[string][]*match)
+	merged := 0
+	for _, listed := range matches {
+		if listed.Kickoff.IsZero() || listed.Name == "" || blockedLeague(listed.League) {
+			continue
+		}
+
+		m := &match{
+			ID:       listed.ID,
+			Name:     listed.Name,
+			Home:     listed.Home,
+			Away:     listed.Away,
+			Sport:    listed.Sport,
+			League:   listed.League,
+			Round:    listed.Round,
+			Channels: listed.Channels,
+			Kickoff:  listed.Kickoff,
+		}
+
+		day := midnight(m.Kickoff)
+		if day.Before(today) || !day.Before(end) {
+			continue
+		}
+
+		if m.ID == "" {
+			m.ID = matchID(day, m.Name)
+		}
+
+		if m.Home == "" && m.Away == "" {
+			m.Home, m.Away = splitTeams(m.Name)
+		}
+
+		m.Kickoff = m.Kickoff.In(stockholm)
+		m.Time = m.Kickoff.Format("15:04")
+		m.TimeKnown = true
+		m.RawChannel = strings.Join(m.Channels, ", ")
+		m.Channels = renameChannels(m.Channels)
+		m.Channel = strings.Join(m.Channels, ", ")
+		m.Source = l.name()
+
+		date := day.Format("2006-01-02")
+		if same := sameFixture(fresh[date], m); same != nil {
+			mergeListed(same, m)
+			merged++
+			continue
+		}
+
+		extra[date] = append(extra[date], m)
+	}
+
+	c.Infof("Got %d matches from listing %s, merged %d into scraped ones", countMatches(extra), l.name(), merged)
+	appendSchedule(fresh, extra)
+}
+
+// Returns the match among matches that is the same fixture as m: the same
+// ID, or the same teams kicking off at the same time. A match without a
+// known kickoff is the same fixture as any match of its teams that day.
+// Returns nil if there's none.
+func sameFixture(matches []*match, m *match) *match {
+	for _, other := range matches {
+		if other.ID == m.ID {
+			return other
+		}
+
+		if !other.TimeKnown || other.Kickoff.Equal(m.Kickoff) {
+			if other.Home != "" && teamKey(other.Home) == teamKey(m.Home) && teamKey(other.Away) == teamKey(m.Away) {
+				return other
+			}
+		}
+	}
+
+	return nil
+}
+
+// Merges a listed match into the same fixture found by the scraper, which
+// is changed in place. Channels missing from the scraped match are added,
+// noting the listing they came from, and fields the scraper left empty are
+// taken from the listed match, as the more complete entry wins.
+func mergeListed(scraped, listed *match) {
+	var added []string
+	for _, channel := range listed.Channels {
+		if !equalsAny(channel, scraped.Channels) {
+			added = append(added, channel)
+		}
+	}
+
+	if len(added) > 0 {
+		sources := make(map[string]string, len(scraped.ChannelSources)+len(added))
+		for channel, source := range scraped.ChannelSources {
+			sources[channel] = source
+		}
+
+		for _, channel := range added {
+			sources[channel] = listed.Source
+		}
+
+		scraped.Channels = append(append([]string{}, scraped.Channels...), added...)
+		scraped.Channel = strings.Join(scraped.Channels, ", ")
+		scraped.ChannelSources = sources
+		if scraped.ChannelInfo != nil {
+			infos := append([]*channelInfo{}, scraped.ChannelInfo...)
+			for _, channel := range added {
+				infos = append(infos, &channelInfo{Name: channel})
+			}
+
+			scraped.ChannelInfo = infos
+		}
+	}
+
+	if !scraped.TimeKnown {
+		scraped.Kickoff, scraped.Time, scraped.TimeKnown = listed.Kickoff, listed.Time, true
+	}
+
+	if scraped.League == "" {
+		scraped.League = listed.League
+	}
+
+	if scraped.Round == "" {
+		scraped.Round = listed.Round
+	}
+
+	if scraped.Sport == "" {
+		scraped.Sport = listed.Sport
+	}
+}
+
+func (l *jsonListing) name() string {
+	return l.source
+}
+
+// Fetches the list of matches of the secondary source like tvmatchen.nu
+// pages, with the same timeout and retries, following redirects within its
+// own host only.
+func (l *jsonListing) fetch(c appengine.Context) ([]*match, error) {
+	host := strings.ToLower(hostOf(l.url))
+	client := fetchClientFor(c, func(h string) bool { return h == host })
+
+	var matches []*match
+	err := retryFetch(c, l.url, func() (err error) {
+		matches, err = fetchListing(client, l.url)
+		return err
+	})
+
+	return matches, err
+}
+
+// Fetches and decodes a list of matches in the schema of /matches.json.
+// Failed fetches are errFetchFailed, so they're tried again, unlike
+// malformed lists.
+func fetchListing(client *http.Client, source string) ([]*match, error) {
+	req, err := http.NewRequest("GET", source, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &refreshError{errFetchFailed, err}
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &refreshError{errFetchFailed, fmt.Errorf("unexpected status from %s: %s", source, resp.Status)}
+	}
+
+	var matches []*match
+	if err := json.NewDecoder(resp.Body).Decode(&matches); err != nil {
+		return nil, err
+	}
+
+	return matches, nil
+}
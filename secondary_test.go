@@ -0,0 +1,236 @@
+package alexmatchen
+
+import (
+	"appengine"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Answers every request with a canned response, or fails it with err.
+type cannedTransport struct {
+	status int
+	body   string
+	err    error
+}
+
+func (ct *cannedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if ct.err != nil {
+		return nil, ct.err
+	}
+
+	return &http.Response{
+		StatusCode: ct.status,
+		Status:     http.StatusText(ct.status),
+		Body:       ioutil.NopCloser(strings.NewReader(ct.body)),
+		Request:    req,
+	}, nil
+}
+
+func TestFetchListing(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		transport *cannedTransport
+		matches   int
+		retried   bool
+	}{
+		{"listed", &cannedTransport{status: http.StatusOK, body: `[{"Name":"Arsenal - Hull","Kickoff":"2014-05-17T21:00:00+02:00"}]`}, 1, false},
+		{"empty", &cannedTransport{status: http.StatusOK, body: `[]`}, 0, false},
+		{"unavailable", &cannedTransport{status: http.StatusServiceUnavailable}, 0, true},
+		{"unreachable", &cannedTransport{err: errors.New("connection refused")}, 0, true},
+		{"malformed", &cannedTransport{status: http.StatusOK, body: `<html>`}, 0, false},
+	} {
+		matches, err := fetchListing(&http.Client{Transport: tc.transport}, "https://streaming.example/matches.json")
+		if len(matches) != tc.matches {
+			t.Errorf("%s: got %d matches, want %d", tc.name, len(matches), tc.matches)
+		}
+
+		re, ok := err.(*refreshError)
+		if retried := ok && re.Code == errFetchFailed; retried != tc.retried {
+			t.Errorf("%s: error %v tried again %v, want %v", tc.name, err, retried, tc.retried)
+		}
+	}
+}
+
+func TestRetryFetch(t *testing.T) {
+	prevDelay, prevRetries := fetchRetryDelay, fetchRetries
+	fetchRetryDelay, fetchRetries = time.Millisecond, 2
+	defer func() { fetchRetryDelay, fetchRetries = prevDelay, prevRetries }()
+
+	failed := &refreshError{errFetchFailed, errors.New("unavailable")}
+	malformed := errors.New("malformed")
+	for _, tc := range []struct {
+		name  string
+		errs  []error
+		calls int
+		err   error
+	}{
+		{"ok", []error{nil}, 1, nil},
+		{"flaky", []error{failed, failed, nil}, 3, nil},
+		{"down", []error{failed, failed, failed, nil}, 3, failed},
+		{"malformed", []error{malformed, nil}, 1, malformed},
+	} {
+		calls := 0
+		err := retryFetch(testContext{t: t}, "https://streaming.example/", func() error {
+			calls++
+			return tc.errs[calls-1]
+		})
+
+		if calls != tc.calls || err != tc.err {
+			t.Errorf("%s: fetched %d times with error %v, want %d times with %v", tc.name, calls, err, tc.calls, tc.err)
+		}
+	}
+}
+
+func TestListingRedirects(t *testing.T) {
+	client := fetchClientFor(testContext{t: t}, func(host string) bool { return host == "streaming.example" })
+	from := &http.Request{URL: &url.URL{Scheme: "https", Host: "streaming.example", Path: "/old"}}
+	for _, tc := range []struct {
+		to   string
+		hops int
+		ok   bool
+	}{
+		{"https://streaming.example/matches.json", 1, true},
+		{"https://STREAMING.example/matches.json", 1, true},
+		{"https://www.tvmatchen.nu/", 1, false},
+		{"https://elsewhere.example/matches.json", 1, false},
+		{"https://streaming.example/matches.json", maxRedirects, false},
+	} {
+		u, _ := url.Parse(tc.to)
+		via := make([]*http.Request, tc.hops)
+		for i := range via {
+			via[i] = from
+		}
+
+		if err := client.CheckRedirect(&http.Request{URL: u}, via); (err == nil) != tc.ok {
+			t.Errorf("redirect to %s after %d hops: %v, want allowed %v", tc.to, tc.hops, err, tc.ok)
+		}
+	}
+}
+
+// A listing of canned matches, for tests.
+type testListing string
+
+func (l testListing) name() string                                { return string(l) }
+func (l testListing) fetch(c appengine.Context) ([]*match, error) { return nil, nil }
+
+// Returns a match as a listing lists it: a name, kickoff and channels only.
+func listedMatch(date, clock, name string, channels ...string) *match {
+	day, _ := dayDate(date)
+	return &match{Name: name, Kickoff: parseKickoff(day, clock), Channels: channels, Sport: "Fotboll"}
+}
+
+func TestAddListing(t *testing.T) {
+	defer useSchedule(testNow, nil)()
+	fresh := func() map[string][]*match {
+		return map[string][]*match{
+			"2014-05-17": {testMatch("2014-05-17", "16:00", "Arsenal - Hull City", "TV4")},
+			"2014-05-18": {testMatch("2014-05-18", "TBD", "Liverpool - Newcastle", "Viasat Fotboll")},
+		}
+	}
+
+	listed := []*match{
+		// The same ID, and the same teams at the same kickoff
+		listedMatch("2014-05-17", "16:00", "Arsenal - Hull City", "Viaplay"),
+		listedMatch("2014-05-18", "18:00", "liverpool  -  Newcastle", "Viasat Fotboll", "Viaplay"),
+
+		// Only listed
+		listedMatch("2014-05-17", "21:00", "Malmö FF - AIK", "Viaplay"),
+
+		// Not shown: without a kickoff or name, and before or after the days
+		{Name: "Hammarby - Sirius", Channels: []string{"Viaplay"}},
+		listedMatch("2014-05-17", "18:00", ""),
+		listedMatch("2014-05-16", "19:00", "Kalmar FF - Elfsborg"),
+		listedMatch("2014-07-01", "19:00", "Häcken - Örebro"),
+	}
+
+	s := fresh()
+	addListing(testContext{t: t}, s, testListing("streams.example"), listed, nil)
+	got := map[string][]string{}
+	for date, matches := range s {
+		for _, m := range matches {
+			got[date] = append(got[date], m.Name+" @ "+m.Time+" on "+m.Channel+" from "+m.Source)
+		}
+	}
+
+	want := map[string][]string{
+		"2014-05-17": {
+			"Arsenal - Hull City @ 16:00 on TV4, Viaplay from " + sourceName,
+			"Malmö FF - AIK @ 21:00 on Viaplay from streams.example",
+		},
+		"2014-05-18": {"Liverpool - Newcastle @ 18:00 on Viasat Fotboll, Viaplay from " + sourceName},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("schedule with the listing = %q, want %q", got, want)
+	}
+
+	if sources := s["2014-05-17"][0].ChannelSources; !reflect.DeepEqual(sources, map[string]string{"Viaplay": "streams.example"}) {
+		t.Errorf("channel sources of the merged match = %v, want Viaplay from the listing", sources)
+	}
+
+	// A failed listing leaves the schedule alone
+	s = fresh()
+	addListing(testContext{t: t}, s, testListing("streams.example"), listed, errors.New("unavailable"))
+	if !reflect.DeepEqual(s, fresh()) {
+		t.Errorf("a failed listing changed the schedule to %v", s)
+	}
+}
+
+// Fields a listing sends beyond the fixture itself are dropped, so a
+// listing can't tag, highlight or flag its matches, nor pass off where their
+// channels came from.
+func TestListedFields(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, nil)()
+
+	prevListings := listings
+	defer func() { listings = prevListings }()
+	listings = []listing{&jsonListing{url: "https://streaming.example/matches.json", source: "streaming.example"}}
+
+	const listed = `[{
+		"Name": "Malmö FF - AIK", "Kickoff": "2014-05-17T21:00:00+02:00", "Channels": ["Viaplay"], "League": "Allsvenskan",
+		"Source": "tvmatchen.nu", "Time": "09:00", "RawChannel": "Injected",
+		"ChannelSources": {"Viaplay": "injected.example"}, "ChannelInfo": [{"Name": "Viaplay", "Link": "https://injected.example/"}],
+		"Tags": {"AIK": "injected"}, "Provenance": {"Name": "injected"}, "WeekdayLabels": {"en": "Injected"},
+		"Highlight": true, "Suspect": true, "Clash": true, "StartsIn": 1
+	}]`
+	defer useTransport(pageTransport(func(r *http.Request) string {
+		if r.URL.Host == "streaming.example" {
+			return listed
+		}
+
+		return selftestFixture
+	}))()
+
+	r, err := inst.NewRequest("GET", "/tasks/refresh", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := refreshSchedule(r); err != nil {
+		t.Fatal(err)
+	}
+
+	body := serve(t, inst, "/matches.json?leagues=all", nil).Body.String()
+	if !strings.Contains(body, "Malmö FF - AIK") {
+		t.Fatalf("/matches.json has no listed match: %s", body)
+	}
+
+	for _, injected := range []string{"injected", "Injected", "09:00", `"Highlight":true`, `"Suspect":true`, `"Clash":true`} {
+		if strings.Contains(body, injected) {
+			t.Errorf("/matches.json has %s sent by the listing: %s", injected, body)
+		}
+	}
+
+	for _, m := range cachedSchedule()["2014-05-17"] {
+		if m.Name == "Malmö FF - AIK" && (m.Source != "streaming.example" || m.Time != "21:00" || m.RawChannel != "Viaplay") {
+			t.Errorf("listed match from %s at %s on %s, want from streaming.example at 21:00 on Viaplay", m.Source, m.Time, m.RawChannel)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package alexmatchen
+
+import (
+	"appengine"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"net/http"
+	"strings"
+)
+
+// Expected results of parsing selftestFixture.
+const (
+	selftestDays    = 2
+	selftestMatches = 3
+)
+
+// Outcome of parsing the bundled fixture.
+type selftestResult struct {
+	Pass     bool
+	Days     int
+	Matches  int
+	Failures []string
+}
+
+func init() {
+	handle("/admin/selftest", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		result := selftest(newContext(r))
+		status := http.StatusOK
+		if !result.Pass {
+			status = http.StatusInternalServerError
+		}
+
+		writeJSONStatus(w, r, status, result)
+	}))
+}
+
+// Parses the bundled fixture and checks that the parser still finds every
+// match and field in it.
+func selftest(c appengine.Context) *selftestResult {
+	result := &selftestResult{Failures: []string{}}
+	fail := func(format string, args ...interface{}) {
+		result.Failures = append(result.Failures, fmt.Sprintf(format, args...))
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(selftestFixture))
+	if err != nil {
+		fail("parsing fixture: %v", err)
+		return result
+	}
+
+	parsed, _ := parseSchedule(c, doc)
+	days := orderedDays(parsed)
+	result.Days = len(days)
+	if result.Days != selftestDays {
+		fail("expected %d days, got %d", selftestDays, result.Days)
+	}
+
+	for _, d := range days {
+		result.Matches += len(d.Matches)
+		for _, m := range d.Matches {
+			if m.Name == "" || m.Home == "" || m.Away == "" {
+				fail("%s: missing name or teams: %q", d.Date, m.Name)
+			}
+
+			if m.League == "" {
+				fail("%s: %s has no league", d.Date, m.Name)
+			}
+
+			if len(m.Channels) == 0 {
+				fail("%s: %s has no channels", d.Date, m.Name)
+			}
+
+			if m.Kickoff.IsZero() {
+				fail("%s: %s has no kickoff, time was %q", d.Date, m.Name, m.Time)
+			}
+		}
+	}
+
+	if result.Matches != selftestMatches {
+		fail("expected %d matches, got %d", selftestMatches, result.Matches)
+	}
+
+	result.Pass = len(result.Failures) == 0
+	return result
+}
+
+// A trimmed down copy of the tvmatchen.nu markup covering the parts the
+// parser depends on: day headers, sport rows, league links, round info and
+// multiple channels.
+const selftestFixture = `
+<html>
+	<body>
+		<h2 class="day-name"><span class="day-name-inner" id="match-day-2014-05-17">Lördag 17 maj</span></h2>
+		<div class="view-content">
+			<div class="match sport-name-fotboll">
+				<div class="time"><span class="field-content">16:00</span></div>
+				<div class="match-name">Arsenal - Hull City</div>
+				<div class="league"><a href="/fotboll">Fotboll</a> FA Cup</div>
+				<div class="channel">
+					<span class="channel-item" title="TV4"></span>
+					<span class="channel-item" title="C More Sport"></span>
+				</div>
+			</div>
+			<div class="match sport-name-fotboll">
+				<div class="time"><span class="field-content">20:45</span></div>
+				<div class="match-name">Hammarby - Ljungskile</div>
+				<div class="league"><a href="/fotboll">Fotboll</a> Superettan Omgång 8</div>
+				<div class="channel"><span class="channel-item" title="TV12"></span></div>
+			</div>
+		</div>
+		<h2 class="day-name"><span class="day-name-inner" id="match-day-2014-05-18">Söndag 18 maj</span></h2>
+		<div class="view-content">
+			<div class="match sport-name-fotboll">
+				<div class="time"><span class="field-content">18:00</span></div>
+				<div class="match-name">Liverpool - Newcastle</div>
+				<div class="league"><a href="/fotboll">Fotboll</a> Premier League</div>
+				<div class="channel"><span class="channel-item" title="Viasat Fotboll"></span></div>
+			</div>
+		</div>
+	</body>
+</html>
+`
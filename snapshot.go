@@ -0,0 +1,161 @@
+package alexmatchen
+
+import (
+	"appengine/memcache"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// Snapshots are re-rendered after this long even without a refresh, so
+	// the live/finished status of matches doesn't drift too far.
+	snapshotMaxAge = time.Minute
+
+	// Most snapshots kept at once. Beyond it the oldest is dropped, so
+	// unusual parameter combinations can't grow the cache without bound.
+	snapshotLimit = 100
+
+	// Most snapshots an instance shares through memcache per refresh.
+	memcacheSnapshotLimit = 500
+)
+
+// A rendered response body.
+type snapshot struct {
+	body     []byte
+	rendered time.Time
+}
+
+var (
+	// Rendered bodies by snapshotKey.
+	snapshots  = make(map[string]*snapshot)
+	snapshotMu sync.Mutex
+
+	// Keys of the snapshots this instance put in memcache since the last
+	// refresh.
+	memcached = make(map[string]bool)
+
+	// Hash of the current schedule, so instances that scraped the same
+	// schedule share snapshots while others don't.
+	scheduleVersion string
+)
+
+// Returns the rendered body for a request, serving a snapshot rendered
+// earlier for the same path and parameters when there is one, as those
+// render the same for everyone between refreshes. Snapshots are shared with
+// other instances through memcache, and kept in memory too.
+func renderSnapshot(r *http.Request, render func() ([]byte, error)) ([]byte, error) {
+	key, ok := snapshotKey(r)
+	if !ok {
+		return render()
+	}
+
+	snapshotMu.Lock()
+	s := snapshots[key]
+	mkey := memcacheKey(key)
+	snapshotMu.Unlock()
+
+	if s != nil && now().Sub(s.rendered) < snapshotMaxAge {
+		return s.body, nil
+	}
+
+	c := newContext(r)
+	if item, err := memcache.Get(c, mkey); err == nil {
+		return item.Value, nil
+	} else if err != memcache.ErrCacheMiss {
+		c.Warningf("Getting snapshot %s from memcache failed: %v", key, err)
+	}
+
+	body, err := render()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshotMu.Lock()
+	if _, ok := snapshots[key]; !ok && len(snapshots) >= snapshotLimit {
+		evictOldestSnapshot()
+	}
+
+	snapshots[key] = &snapshot{body: body, rendered: now()}
+	share := !memcached[mkey] && len(memcached) < memcacheSnapshotLimit
+	if share {
+		memcached[mkey] = true
+	}
+	snapshotMu.Unlock()
+
+	// Shared snapshots expire like local ones, or at the next refresh if
+	// that's sooner
+	expiration := snapshotMaxAge
+	if untilRefresh := nextRefresh().Sub(now()); untilRefresh < expiration {
+		expiration = untilRefresh
+	}
+
+	if share && expiration > 0 {
+		if err := memcache.Set(c, &memcache.Item{Key: mkey, Value: body, Expiration: expiration}); err != nil {
+			c.Warningf("Putting snapshot %s in memcache failed: %v", key, err)
+		}
+	}
+
+	return body, nil
+}
+
+// Returns the memcache key of a snapshot. It includes the schedule version,
+// so snapshots of another schedule are never served. snapshotMu must be
+// held.
+func memcacheKey(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return "snapshot:" + scheduleVersion + ":" + hex.EncodeToString(sum[:])
+}
+
+// Returns the cache key of a request: its path and query parameters, sorted
+// so the order they're given in doesn't matter, with the values saved on
+// /preferences standing in for missing parameters, and the version of the
+// /admin/config overrides, which change the defaults. Requests whose body
+// depends on who asks, such as ?debug=true, aren't snapshotted.
+func snapshotKey(r *http.Request) (string, bool) {
+	q := r.URL.Query()
+	if _, ok := q["debug"]; ok {
+		return "", false
+	}
+
+	for _, param := range savedParams {
+		if v := preference(r, param); q.Get(param) == "" && v != "" {
+			q.Set(param, v)
+		}
+	}
+
+	// Without ?lang= the language depends on Accept-Language
+	if v := acceptedLang(r.Header.Get("Accept-Language")); q.Get("lang") == "" && v != "" {
+		q.Set("lang", v)
+	}
+
+	return r.URL.Path + "?" + q.Encode() + "#" + overridesVersion(), true
+}
+
+// Drops the least recently rendered snapshot. snapshotMu must be held.
+func evictOldestSnapshot() {
+	var oldest string
+	for key, s := range snapshots {
+		if oldest == "" || s.rendered.Before(snapshots[oldest].rendered) {
+			oldest = key
+		}
+	}
+
+	delete(snapshots, oldest)
+}
+
+// Drops all snapshots, for when the schedule changes. Shared snapshots are
+// left to expire, as their keys change with the schedule version.
+func invalidateSnapshots() {
+	js, _ := json.Marshal(schedule)
+	sum := sha1.Sum(js)
+
+	snapshotMu.Lock()
+	snapshots = make(map[string]*snapshot)
+	memcached = make(map[string]bool)
+	scheduleVersion = hex.EncodeToString(sum[:8])
+	snapshotMu.Unlock()
+}
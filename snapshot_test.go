@@ -0,0 +1,66 @@
+package alexmatchen
+
+import (
+	"appengine/memcache"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Serves a filtered page of the large schedule again and again, rendering it
+// for every request as before snapshots covered queries, and from the
+// snapshot rendered for the first request. Renders are counted from the
+// Server-Timing header.
+func BenchmarkSnapshot(b *testing.B) {
+	inst, done := newInstance(b)
+	defer done()
+
+	s, _ := parseSchedule(testContext{t: b}, largeDocument(b))
+	defer useSchedule(testNow, s)()
+
+	const path = "/?leagues=Premier+League,Allsvenskan&channels=TV4,C+More+Sport"
+	for _, tc := range []struct {
+		name     string
+		snapshot bool
+	}{
+		{"render", false},
+		{"snapshot", true},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			// Drops the snapshots, shared ones too, as a refresh would
+			invalidate := func() {
+				r, err := inst.NewRequest("GET", path, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				memcache.Flush(newContext(r))
+				mu.Lock()
+				invalidateSnapshots()
+				mu.Unlock()
+			}
+
+			invalidate()
+			b.ResetTimer()
+			renders := 0
+			for i := 0; i < b.N; i++ {
+				if !tc.snapshot {
+					b.StopTimer()
+					invalidate()
+					b.StartTimer()
+				}
+
+				w := serve(b, inst, path, nil)
+				if w.Code != http.StatusOK {
+					b.Fatalf("GET %s = %d %s", path, w.Code, w.Body)
+				}
+
+				if strings.Contains(w.Header().Get("Server-Timing"), "render;") {
+					renders++
+				}
+			}
+
+			b.Logf("%d renders for %d requests", renders, b.N)
+		})
+	}
+}
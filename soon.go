@@ -0,0 +1,60 @@
+package alexmatchen
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// Window of /soon.json when the request doesn't give one, and the
+	// largest it may give.
+	defaultSoonHours = 6
+	maxSoonHours     = 48
+)
+
+func init() {
+	handle("/soon.json", func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseFilters(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		d, err := parseDisplay(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		hours := defaultSoonHours
+		if v := r.FormValue("hours"); v != "" {
+			if hours, err = strconv.Atoi(v); err != nil || hours < 1 || hours > maxSoonHours {
+				jsonError(w, http.StatusBadRequest, errBadRequest, fmt.Sprintf("hours must be an integer from 1 to %d, got %q", maxSoonHours, v))
+				return
+			}
+		}
+
+		if err := refreshScheduleIfNeeded(w, r); err != nil {
+			jsonRefreshError(w, err)
+			return
+		}
+
+		t := now()
+		writeJSON(w, r, kickingOffWithin(d.flatten(f.apply(d.format(scheduleAt(cachedSchedule(), t))), false), t, time.Duration(hours)*time.Hour))
+	})
+}
+
+// Returns the matches kicking off within d from t, keeping their order.
+// Matches already started are left out.
+func kickingOffWithin(matches []*match, t time.Time, d time.Duration) []*match {
+	soon := []*match{}
+	for _, m := range matches {
+		if m.TimeKnown && !m.Kickoff.Before(t) && m.Kickoff.Before(t.Add(d)) {
+			soon = append(soon, m)
+		}
+	}
+
+	return soon
+}
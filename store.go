@@ -0,0 +1,205 @@
+package alexmatchen
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"appengine/memcache"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const (
+	// Where the last refreshed schedule is persisted: a single entity in
+	// datastore, and a copy in memcache to read it from quickly.
+	storedKind        = "Schedule"
+	storedID          = "current"
+	storedMemcacheKey = "schedule:current"
+
+	// When the stored schedule was refreshed, in RFC 3339, kept in memcache
+	// apart from the schedule so instances can check for a newer one
+	// without loading it.
+	storedVersionKey = "schedule:refreshed"
+
+	// How often an instance checks for a schedule stored by another, so
+	// instances serve the same schedule within this long of a refresh,
+	// however long cacheDuration is.
+	storePollInterval = time.Minute
+)
+
+var (
+	// When this instance last checked for a newer stored schedule, whether
+	// or not it found one, guarded by mu.
+	lastStoreCheck time.Time
+
+	// Held while restoring, so only one request at a time loads the stored
+	// schedule. Unlike refreshMu it's never held while fetching upstream.
+	restoring = make(chan bool, 1)
+)
+
+// A refreshed schedule as persisted, so instances starting up or polling for
+// a newer schedule can serve the one another instance refreshed rather than
+// scrape tvmatchen.nu themselves.
+type storedSchedule struct {
+	// The schedule as JSON, as datastore can't store the map itself.
+	Schedule []byte `datastore:",noindex"`
+
+	LastRefresh time.Time
+}
+
+// Persists a refreshed schedule, to memcache and datastore. Failures are
+// logged only, as the schedule is still served from memory.
+func storeSchedule(c appengine.Context, s map[string][]*match, refreshed time.Time) {
+	js, err := json.Marshal(s)
+	if err != nil {
+		c.Errorf("Encoding schedule to store failed: %v", err)
+		return
+	}
+
+	stored := &storedSchedule{Schedule: js, LastRefresh: refreshed}
+	if _, err := datastore.Put(c, datastore.NewKey(c, storedKind, storedID, 0, nil), stored); err != nil {
+		c.Errorf("Storing schedule in datastore failed: %v", err)
+	}
+
+	cacheStoredSchedule(c, stored)
+}
+
+// Puts a stored schedule and its version in memcache.
+func cacheStoredSchedule(c appengine.Context, stored *storedSchedule) {
+	item, err := json.Marshal(stored)
+	if err != nil {
+		return
+	}
+
+	err = memcache.SetMulti(c, []*memcache.Item{
+		{Key: storedMemcacheKey, Value: item},
+		{Key: storedVersionKey, Value: []byte(stored.LastRefresh.Format(time.RFC3339Nano))},
+	})
+	if err != nil {
+		c.Warningf("Putting schedule in memcache failed: %v", err)
+	}
+}
+
+// Returns the persisted schedule, from memcache or else datastore, or nil if
+// none has been stored yet.
+func loadStoredSchedule(c appengine.Context) (*storedSchedule, error) {
+	stored := &storedSchedule{}
+	item, err := memcache.Get(c, storedMemcacheKey)
+	if err == nil && json.Unmarshal(item.Value, stored) == nil {
+		return stored, nil
+	} else if err != nil && err != memcache.ErrCacheMiss {
+		c.Warningf("Getting schedule from memcache failed: %v", err)
+	}
+
+	switch err := datastore.Get(c, datastore.NewKey(c, storedKind, storedID, 0, nil), stored); err {
+	case nil:
+		// Other instances polling find it in memcache again
+		cacheStoredSchedule(c, stored)
+		return stored, nil
+	case datastore.ErrNoSuchEntity:
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+// Reports whether this instance is due to check for a newer stored
+// schedule: storePollInterval after the last check. That holds without a
+// schedule too, so an instance with none to restore yet doesn't look it up
+// again on every request.
+func storeCheckDue() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return now().Sub(lastStoreCheck) >= storePollInterval
+}
+
+// Returns when the stored schedule was refreshed, as kept in memcache. Not
+// ok when memcache has lost it, or nothing was stored yet.
+func storedVersion(c appengine.Context) (time.Time, bool) {
+	item, err := memcache.Get(c, storedVersionKey)
+	if err != nil {
+		if err != memcache.ErrCacheMiss {
+			c.Warningf("Getting schedule version from memcache failed: %v", err)
+		}
+
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, string(item.Value))
+	return t, err == nil
+}
+
+// Swaps in the persisted schedule if it was refreshed after the cached one,
+// e.g. by another instance or before this one started. Reports whether it
+// did. Only one request restores at a time. Others serve the cached
+// schedule meanwhile rather than wait, unless there is none yet, and those
+// that waited don't check again if it was checked meanwhile. Restoring never
+// waits on a refresh fetching upstream either.
+func restoreSchedule(r *http.Request) bool {
+	if cachedSchedule() == nil {
+		restoring <- true
+	} else {
+		select {
+		case restoring <- true:
+		default:
+			return false
+		}
+	}
+	defer func() { <-restoring }()
+
+	mu.Lock()
+	if now().Sub(lastStoreCheck) < storePollInterval {
+		mu.Unlock()
+		return false
+	}
+
+	lastStoreCheck = now()
+	cached := lastRefresh
+	mu.Unlock()
+
+	// Memcache tells whether there's anything newer without loading it
+	c := newContext(r)
+	if version, ok := storedVersion(c); ok && !version.After(cached) {
+		return false
+	}
+
+	stored, err := loadStoredSchedule(c)
+	if err != nil {
+		c.Warningf("Loading stored schedule failed: %v", err)
+		return false
+	}
+
+	if stored == nil || !stored.LastRefresh.After(cached) {
+		return false
+	}
+
+	var s map[string][]*match
+	if err := json.Unmarshal(stored.Schedule, &s); err != nil {
+		c.Errorf("Decoding stored schedule failed: %v", err)
+		return false
+	}
+
+	// Days gone by since it was stored are dropped, as a refresh would
+	s = mergeSchedules(s, nil, midnight(now()))
+
+	// A refresh may have swapped in an even newer schedule meanwhile
+	mu.Lock()
+	if !stored.LastRefresh.After(lastRefresh) {
+		mu.Unlock()
+		return false
+	}
+
+	previousSchedule = schedule
+	schedule = s
+	lastRefresh = stored.LastRefresh
+	if lastAttempt.Before(lastRefresh) {
+		lastAttempt = lastRefresh
+		lastRefreshErr = nil
+		failedRefreshes = 0
+	}
+	invalidateSnapshots()
+	mu.Unlock()
+
+	c.Infof("Restored %d matches refreshed at %s", countMatches(s), stored.LastRefresh.Format(time.RFC3339))
+	return true
+}
@@ -0,0 +1,227 @@
+package alexmatchen
+
+import (
+	"appengine/aetest"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Stores s as refreshed at refreshed, as another instance would.
+func storeTestSchedule(t *testing.T, inst aetest.Instance, s map[string][]*match, refreshed time.Time) {
+	r, err := inst.NewRequest("GET", "/tasks/refresh", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storeSchedule(newContext(r), s, refreshed)
+}
+
+// Fails the test unless f returns within a second. As f runs on another
+// goroutine, it must report failures with t.Error rather than t.Fatal.
+func within(t *testing.T, what string, f func()) {
+	done := make(chan bool)
+	go func() {
+		f()
+		done <- true
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("%s didn't return within a second", what)
+	}
+}
+
+func TestRestoreDuringRefresh(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+
+	defer useSchedule(testNow, map[string][]*match{"2014-05-18": {
+		testMatch("2014-05-18", "18:00", "Liverpool - Newcastle", "Viasat Fotboll"),
+	}})()
+
+	storeTestSchedule(t, inst, map[string][]*match{"2014-05-18": {
+		testMatch("2014-05-18", "18:00", "Liverpool - Newcastle", "TV4"),
+	}}, testNow.Add(time.Hour))
+
+	// The cached schedule is due a refresh, and one is fetching upstream
+	now = func() time.Time { return testNow.Add(cacheDuration + time.Minute) }
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	within(t, "GET /schedule.json", func() {
+		w := serve(t, inst, "/schedule.json", nil)
+		var s map[string][]*match
+		if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+			t.Errorf("GET /schedule.json = %d %s: %v", w.Code, w.Body, err)
+			return
+		}
+
+		if matches := s["2014-05-18"]; len(matches) != 1 || matches[0].Channel != "TV4" {
+			t.Errorf("GET /schedule.json served %+v, want the stored schedule", matches)
+		}
+	})
+}
+
+func TestRestoreBusy(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+
+	defer useSchedule(testNow, map[string][]*match{"2014-05-18": {
+		testMatch("2014-05-18", "18:00", "Liverpool - Newcastle", "Viasat Fotboll"),
+	}})()
+
+	storeTestSchedule(t, inst, map[string][]*match{"2014-05-18": {
+		testMatch("2014-05-18", "18:00", "Liverpool - Newcastle", "TV4"),
+	}}, testNow.Add(time.Hour))
+
+	// Another request is restoring, so this one serves the cached schedule
+	restoring <- true
+	defer func() { <-restoring }()
+
+	within(t, "restoreSchedule", func() {
+		r, err := inst.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if restoreSchedule(r) {
+			t.Error("restoreSchedule restored while another request was restoring")
+		}
+	})
+
+	if matches := cachedSchedule()["2014-05-18"]; matches[0].Channel != "Viasat Fotboll" {
+		t.Errorf("cached %+v, want the schedule cached before", matches)
+	}
+}
+
+func TestStorePolling(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+
+	defer useSchedule(testNow, map[string][]*match{"2014-05-18": {
+		testMatch("2014-05-18", "18:00", "Liverpool - Newcastle", "Viasat Fotboll"),
+	}})()
+
+	// Returns the channel of the match on /schedule.json at offset from
+	// testNow
+	channelAt := func(offset time.Duration) string {
+		now = func() time.Time { return testNow.Add(offset) }
+		var s map[string][]*match
+		if err := json.Unmarshal(serve(t, inst, "/schedule.json", nil).Body.Bytes(), &s); err != nil || len(s["2014-05-18"]) != 1 {
+			t.Fatalf("GET /schedule.json at %v served %v: %v", offset, s, err)
+		}
+
+		return s["2014-05-18"][0].Channel
+	}
+
+	if got := channelAt(0); got != "Viasat Fotboll" {
+		t.Fatalf("served channel %q before any refresh elsewhere, want Viasat Fotboll", got)
+	}
+
+	// Cron refreshes every few hours, well before cacheDuration is over
+	for _, tc := range []struct {
+		stored  time.Duration
+		channel string
+		at      time.Duration
+		want    string
+	}{
+		{4 * time.Hour, "TV4", 4*time.Hour + 2*time.Minute, "TV4"},
+
+		// Until storePollInterval has passed the last check isn't repeated
+		{5 * time.Hour, "C More Sport", 4*time.Hour + 2*time.Minute + storePollInterval/2, "TV4"},
+		{5 * time.Hour, "C More Sport", 4*time.Hour + 2*time.Minute + storePollInterval, "C More Sport"},
+	} {
+		storeTestSchedule(t, inst, map[string][]*match{"2014-05-18": {
+			testMatch("2014-05-18", "18:00", "Liverpool - Newcastle", tc.channel),
+		}}, testNow.Add(tc.stored))
+
+		if got := channelAt(tc.at); got != tc.want {
+			t.Errorf("served channel %q at %v with a schedule stored at %v, want %q", got, tc.at, tc.stored, tc.want)
+		}
+	}
+}
+
+func TestStoredVersion(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+
+	r, err := inst.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newContext(r)
+	if _, ok := storedVersion(c); ok {
+		t.Error("storedVersion ok before anything was stored")
+	}
+
+	refreshed := testNow.Add(time.Second / 3)
+	storeSchedule(c, map[string][]*match{}, refreshed)
+	if got, ok := storedVersion(c); !ok || !got.Equal(refreshed) {
+		t.Errorf("storedVersion = %v, %v, want %v", got, ok, refreshed)
+	}
+}
+
+func TestRestoreMiss(t *testing.T) {
+	inst, done := newInstance(t)
+	defer done()
+	defer useSchedule(testNow, nil)()
+
+	prevOnRequest := refreshOnRequest
+	defer func() { refreshOnRequest = prevOnRequest }()
+	refreshOnRequest = false
+
+	// Nothing is stored yet, and that is remembered until the next poll
+	if w := serve(t, inst, "/schedule.json", nil); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("GET /schedule.json with nothing stored = %d, want 503", w.Code)
+	}
+
+	storeTestSchedule(t, inst, map[string][]*match{"2014-05-18": {
+		testMatch("2014-05-18", "18:00", "Liverpool - Newcastle", "TV4"),
+	}}, testNow.Add(time.Hour))
+
+	if w := serve(t, inst, "/schedule.json", nil); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /schedule.json right after a miss = %d, want 503 without looking again", w.Code)
+	}
+
+	// Requests waiting while another restores don't look it up again
+	// once it has
+	restoring <- true
+	results := make(chan bool, 3)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			r, err := inst.NewRequest("GET", "/", nil)
+			if err != nil {
+				t.Error(err)
+				results <- false
+				return
+			}
+
+			results <- restoreSchedule(r)
+		}()
+	}
+
+	now = func() time.Time { return testNow.Add(storePollInterval) }
+	mu.Lock()
+	lastStoreCheck = now()
+	mu.Unlock()
+	<-restoring
+
+	within(t, "waiting restores", func() {
+		for i := 0; i < cap(results); i++ {
+			if <-results {
+				t.Error("a waiting request restored after another had checked")
+			}
+		}
+	})
+
+	// Due again a poll interval later
+	now = func() time.Time { return testNow.Add(2 * storePollInterval) }
+	if w := serve(t, inst, "/schedule.json", nil); w.Code != http.StatusOK {
+		t.Errorf("GET /schedule.json a poll interval later = %d, want the stored schedule", w.Code)
+	}
+}
@@ -0,0 +1,334 @@
+package alexmatchen
+
+import (
+	"hash/fnv"
+	"html/template"
+	"net/url"
+)
+
+var (
+	// Helpers available to the page templates.
+	templateFuncs = template.FuncMap{
+		"colorFor":      colorFor,
+		"logoURL":       logoURL,
+		"leagueChanged": leagueChanged,
+		"statusOf":      statusOf,
+		"taggedName":    taggedName,
+		"t":             translate,
+	}
+
+	// Page templates by the value of the view parameter. The default list
+	// view has the empty name.
+	views = map[string]*template.Template{
+		"":      template.Must(template.New("list").Funcs(templateFuncs).Parse(htmlTemplate)),
+		"min":   template.Must(template.New("min").Funcs(templateFuncs).Parse(minTemplate)),
+		"grid":  template.Must(template.New("grid").Funcs(templateFuncs).Parse(gridTemplate)),
+		"print": template.Must(template.New("print").Funcs(templateFuncs).Parse(printTemplate)),
+	}
+
+	leaguePalette = []string{"#c5752a", "#2a7ac5", "#3d9a4b", "#9a3d8c", "#b8a11f", "#c53a2a"}
+)
+
+// Returns a color for a league, the same one every time.
+func colorFor(league string) string {
+	h := fnv.New32a()
+	h.Write([]byte(league))
+	return leaguePalette[h.Sum32()%uint32(len(leaguePalette))]
+}
+
+// Returns where the page loads a channel logo from: through /img, which only
+// passes on images of IMAGE_HOSTS, or "" for logos it wouldn't pass on.
+func logoURL(logo string) string {
+	if _, err := parseImageURL(logo); err != nil {
+		return ""
+	}
+
+	return "/img?u=" + url.QueryEscape(logo)
+}
+
+// Returns the name of a match with the tag of each team in front of it, see
+// match.Tags.
+func taggedName(m *match) string {
+	if len(m.Tags) == 0 {
+		return m.Name
+	}
+
+	tagged := func(team string) string {
+		if tag, ok := m.Tags[team]; ok {
+			return tag + " " + team
+		}
+
+		return team
+	}
+
+	if m.Home == "" && m.Away == "" {
+		return tagged(m.Name)
+	}
+
+	return tagged(m.Home) + " - " + tagged(m.Away)
+}
+
+// Returns the current status of a match, see match.statusAt.
+func statusOf(m *match) string {
+	return m.statusAt(now())
+}
+
+const (
+	htmlTemplate = `
+<html>
+	<head>
+		<title>{{t .Lang "title"}}</title>
+	    <meta charset="utf-8" />
+	    <link rel="shortcut icon" href="/favicon.ico" type="image/x-icon">
+		<link rel="icon" href="/favicon.ico" type="image/x-icon">
+	    <link href='http://fonts.googleapis.com/css?family=Open+Sans' rel='stylesheet' type='text/css'>
+	    <style type="text/css">
+	    	body {
+	    		background: #efefef;
+	    		color: #333333;
+	    		font-family: 'Open Sans', arial;
+	    	}
+
+	    	ul {
+	    		list-style: none;
+	    		margin: 0;
+	    		padding: 0;
+	    	}
+
+	    	h2 {
+	    		margin: 5px 0;
+	    	}
+
+	    	li {
+	    		font-size: 14px;
+	    		padding: 3px 0 3px 6px;
+	    	}
+
+	    	.highlight {
+	    		font-weight: bold;
+	    		background: #fff3d6;
+	    	}
+
+	    	.clash {
+	    		border-bottom: 1px dotted #c5752a;
+	    	}
+
+	    	.status {
+	    		font-size: 10px;
+	    		padding: 1px 4px;
+	    		border-radius: 3px;
+	    		background: #cccccc;
+	    	}
+
+	    	.status-live {
+	    		background: #c53a2a;
+	    		color: #ffffff;
+	    	}
+
+	    	em {
+	    		font-size: 10px;
+	    	}
+
+    		.time {
+    			color: #c5752a;
+    		}
+
+    		.league-channel {
+    			color: #575e5b;
+    		}
+
+    		.league-channel a {
+    			color: inherit;
+    		}
+
+    		.channel-logo {
+    			height: 12px;
+    			margin-right: 3px;
+    			vertical-align: middle;
+    		}
+
+    		.round {
+    			color: #999999;
+    			font-size: 12px;
+    		}
+
+    		.league-heading {
+    			margin-top: 4px;
+    			color: #575e5b;
+    			font-weight: bold;
+    		}
+
+    		@media all and (max-width: 500px) {
+			  .league-channel {
+			  	display: block;
+			  }
+			}
+	    </style>
+	</head>
+	<body>
+		{{t .Lang "intro"}}
+
+		{{if .OffSeason}}<p class="off-season">{{t .Lang "offseason"}}</p>{{end}}
+		
+		{{range $day := .Schedule}}
+			<h2>{{ $day.Label }}</h2>
+			<ul>
+				{{range $i, $match := $day.Matches}}
+					{{if and $.CollapseLeagues (leagueChanged $day.Matches $i)}}<li class="league-heading">{{$match.League}}</li>{{end}}
+					<li{{if $match.Highlight}} class="highlight"{{end}} style="border-left: 3px solid {{colorFor $match.League}};">
+						<span class="time{{if $match.Clash}} clash{{end}}"{{if $match.Clash}} title="{{t $.Lang "clash"}}"{{end}}>{{if $match.TimeKnown}}{{$match.Time}}{{else}}{{t $.Lang "time.tbd"}}{{end}}</span>
+						<span class="name">{{taggedName $match}}</span>
+						{{with statusOf $match}}{{if ne . "scheduled"}}<span class="status status-{{.}}">{{t $.Lang (print "status." .)}}</span>{{end}}{{end}}
+						{{$hideLeague := or $.HideLeague $.CollapseLeagues}}
+						{{if $.CollapseLeagues}}{{if $match.Round}}<span class="round">{{$match.Round}}</span>{{end}}{{end}}
+						{{if not (and $hideLeague $.HideChannel)}}
+							<span class="league-channel">({{if not $hideLeague}}{{if and $.MixedSports $match.Sport}}{{$match.Sport}}, {{end}}{{$match.League}}{{if $match.Round}}, <span class="round">{{$match.Round}}</span>{{end}}{{end}}{{if not (or $hideLeague $.HideChannel)}}, {{end}}{{if not $.HideChannel}}{{template "channels" $match}}{{end}})</span>
+						{{end}}
+					</li>
+				{{end}}
+				{{if $day.More}}<li class="more"><a href="{{$.UnboundedURL}}">+{{$day.More}} {{t $.Lang "more"}}</a></li>{{end}}
+			</ul>
+		{{end}}
+
+		{{if .Languages}}<p class="langs">{{range $i, $lang := .Languages}}{{if $i}} · {{end}}{{if $lang.Chosen}}<strong>{{$lang.Name}}</strong>{{else}}<a href="{{$lang.URL}}">{{$lang.Name}}</a>{{end}}{{end}}</p>{{end}}
+		{{if .TimeZones}}<p class="zones">{{t .Lang "tz"}}: {{range $i, $zone := .TimeZones}}{{if $i}} · {{end}}{{if $zone.Chosen}}<strong>{{$zone.Name}}</strong>{{else}}<a href="{{$zone.URL}}">{{$zone.Name}}</a>{{end}}{{end}}</p>{{end}}
+		<em>{{t .Lang "updated"}} {{.LastRefresh}} · <a href="/preferences">{{t .Lang "prefs.title"}}</a></em>
+	</body>
+</html>
+{{define "channels"}}{{if .ChannelInfo}}{{range $i, $channel := .ChannelInfo}}{{if $i}}, {{end}}<span class="channel-item">{{with logoURL $channel.Logo}}<img class="channel-logo" src="{{.}}" alt="" />{{end}}{{if $channel.Link}}<a href="{{$channel.Link}}" rel="nofollow noopener" target="_blank">{{$channel.Name}}</a>{{else}}{{$channel.Name}}{{end}}</span>{{end}}{{else}}{{.Channel}}{{end}}{{end}}
+`
+
+	// Stripped down single column view for small screens, see ?view=min.
+	minTemplate = `
+<html>
+	<head>
+		<title>{{t .Lang "title"}}</title>
+		<meta charset="utf-8" />
+		<meta name="viewport" content="width=device-width, initial-scale=1" />
+		<link rel="icon" href="/favicon.ico" type="image/x-icon">
+		<style type="text/css">
+			body { margin: 8px; font-family: arial; font-size: 14px; color: #333333; }
+			h2 { font-size: 16px; margin: 12px 0 4px; }
+			ul { list-style: none; margin: 0; padding: 0; }
+			li { padding: 4px 0; border-bottom: 1px solid #dddddd; }
+			.time { color: #c5752a; }
+			.meta { display: block; color: #777777; font-size: 12px; }
+		</style>
+	</head>
+	<body>
+		{{if .OffSeason}}<p>{{t .Lang "offseason"}}</p>{{end}}
+
+		{{range $day := .Schedule}}
+			<h2>{{$day.Label}}</h2>
+			<ul>
+				{{range $match := $day.Matches}}
+					<li>
+						<span class="time">{{if $match.TimeKnown}}{{$match.Time}}{{else}}{{t $.Lang "time.tbd"}}{{end}}</span> {{taggedName $match}}
+						<span class="meta">{{if and $.MixedSports $match.Sport}}{{$match.Sport}}, {{end}}{{$match.League}}, {{$match.Channel}}</span>
+					</li>
+				{{end}}
+				{{if $day.More}}<li><a href="{{$.UnboundedURL}}">+{{$day.More}} {{t $.Lang "more"}}</a></li>{{end}}
+			</ul>
+		{{end}}
+
+		<em>{{t .Lang "updated"}} {{.LastRefresh}}</em>
+	</body>
+</html>
+`
+
+	// Desktop overview with a column per day and a chip per match, seven days
+	// to a row, see ?view=grid.
+	gridTemplate = `
+<html>
+	<head>
+		<title>{{t .Lang "title"}}</title>
+		<meta charset="utf-8" />
+		<link rel="icon" href="/favicon.ico" type="image/x-icon">
+		<style type="text/css">
+			body { margin: 8px; background: #efefef; color: #333333; font-family: arial; font-size: 12px; }
+			.grid { display: flex; flex-wrap: wrap; }
+			.column { box-sizing: border-box; width: 14.28%; min-height: 120px; padding: 4px; border-right: 1px solid #dddddd; }
+			h2 { font-size: 13px; margin: 0 0 6px; white-space: nowrap; overflow: hidden; text-overflow: ellipsis; }
+			.chip { margin: 0 0 4px; padding: 3px 4px; background: #ffffff; border-radius: 3px; overflow: hidden; }
+			.chip.highlight { font-weight: bold; background: #fff3d6; }
+			.time { color: #c5752a; }
+			.channel { display: block; color: #777777; font-size: 11px; }
+		</style>
+	</head>
+	<body>
+		{{if .OffSeason}}<p>{{t .Lang "offseason"}}</p>{{end}}
+
+		<div class="grid">
+			{{range $day := .Schedule}}
+				<div class="column">
+					<h2>{{$day.Label}}</h2>
+					{{range $match := $day.Matches}}
+						<div class="chip{{if $match.Highlight}} highlight{{end}}" title="{{$match.League}}" style="border-left: 3px solid {{colorFor $match.League}};">
+							<span class="time">{{if $match.TimeKnown}}{{$match.Time}}{{else}}{{t $.Lang "time.tbd"}}{{end}}</span> {{taggedName $match}}
+							<span class="channel">{{$match.Channel}}</span>
+						</div>
+					{{end}}
+					{{if $day.More}}<a href="{{$.UnboundedURL}}">+{{$day.More}} {{t $.Lang "more"}}</a>{{end}}
+				</div>
+			{{end}}
+		</div>
+
+		<em>{{t .Lang "updated"}} {{.LastRefresh}}</em>
+	</body>
+</html>
+`
+
+	// Black on white table per day for printing a week's schedule, with each
+	// day kept on one page where it fits, see ?view=print.
+	printTemplate = `
+<html>
+	<head>
+		<title>{{t .Lang "title"}}</title>
+		<meta charset="utf-8" />
+		<style type="text/css">
+			body { margin: 0; background: #ffffff; color: #000000; font-family: Georgia, "Times New Roman", serif; font-size: 12pt; }
+			.day { page-break-inside: avoid; margin: 0 0 16pt; }
+			h2 { font-size: 14pt; margin: 0 0 4pt; border-bottom: 1pt solid #000000; page-break-after: avoid; }
+			table { width: 100%; border-collapse: collapse; }
+			td { padding: 2pt 6pt 2pt 0; vertical-align: top; border-bottom: 0.5pt solid #999999; }
+			.time { width: 4em; font-weight: bold; }
+			em { font-size: 9pt; }
+			@page { margin: 15mm; }
+		</style>
+	</head>
+	<body>
+		{{if .OffSeason}}<p>{{t .Lang "offseason"}}</p>{{end}}
+
+		{{range $day := .Schedule}}
+			<div class="day">
+				<h2>{{$day.Label}}</h2>
+				<table>
+					{{range $match := $day.Matches}}
+						<tr>
+							<td class="time">{{if $match.TimeKnown}}{{$match.Time}}{{else}}{{t $.Lang "time.tbd"}}{{end}}</td>
+							<td>{{taggedName $match}}</td>
+							{{if not $.HideLeague}}<td>{{if and $.MixedSports $match.Sport}}{{$match.Sport}}, {{end}}{{$match.League}}{{if $match.Round}}, {{$match.Round}}{{end}}</td>{{end}}
+							{{if not $.HideChannel}}<td>{{$match.Channel}}</td>{{end}}
+						</tr>
+					{{end}}
+				</table>
+				{{if $day.More}}<p>+{{$day.More}} {{t $.Lang "more"}}</p>{{end}}
+			</div>
+		{{end}}
+
+		<em>{{t .Lang "updated"}} {{.LastRefresh}}</em>
+	</body>
+</html>
+`
+
+	notFoundPage = `<html>
+	<head>
+		<title>Sidan finns inte</title>
+		<meta charset="utf-8" />
+	</head>
+	<body>
+		Sidan finns inte. <a href="/">Till matcherna</a>
+	</body>
+</html>
+`
+)
@@ -0,0 +1,71 @@
+package alexmatchen
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Lists each match on a line, through every helper of templateFuncs.
+const helpersTemplate = `{{range $day := .Days}}{{range $i, $m := $day.Matches}}` +
+	`{{if leagueChanged $day.Matches $i}}[{{$m.League}}] {{end}}` +
+	`{{taggedName $m}} {{statusOf $m}} {{colorFor $m.League}}
+{{end}}{{end}}{{range .Logos}}{{logoURL .}}
+{{end}}{{t "sv" "more"}} {{t "en" "more"}} {{t "en" "no.such.key"}}`
+
+func TestTemplateFuncs(t *testing.T) {
+	prevNow, prevHosts := now, imageHosts
+	defer func() { now, imageHosts = prevNow, prevHosts }()
+	now = func() time.Time { return testNow }
+	imageHosts = []string{"tvmatchen.nu"}
+
+	cup := testMatch("2014-05-17", "16:00", "Arsenal - Hull", "TV4")
+	cup.League = "FA Cup"
+	tagged := testMatch("2014-05-17", "21:00", "Malmö FF - AIK", "TV4")
+	tagged.League, tagged.Tags = "FA Cup", map[string]string{"Malmö FF": "🔵"}
+	days := []*day{
+		{Date: "2014-05-17", Matches: []*match{
+			testMatch("2014-05-17", "13:00", "Chelsea - Everton", "TV4"),
+			cup,
+			tagged,
+		}},
+		{Date: "2014-05-18", Matches: []*match{
+			testMatch("2014-05-18", "17:00", "Fulham - Stoke", "TV4"),
+			testMatch("2014-05-18", "19:00", "Liverpool - Newcastle", "TV4"),
+		}},
+	}
+
+	tmpl := template.Must(template.New("helpers").Funcs(templateFuncs).Parse(helpersTemplate))
+	var b bytes.Buffer
+	err := tmpl.Execute(&b, map[string]interface{}{
+		"Days":  days,
+		"Logos": []string{"https://img.tvmatchen.nu/tv4.png", "https://elsewhere.example/tv4.png", "ftp://tvmatchen.nu/tv4.png"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pl, fa := colorFor("Premier League"), colorFor("FA Cup")
+	want := strings.Join([]string{
+		// Leagues change at the first match of each day too
+		"[Premier League] Chelsea - Everton " + statusFinished + " " + pl,
+		"[FA Cup] Arsenal - Hull " + statusLive + " " + fa,
+		"🔵 Malmö FF - AIK " + statusScheduled + " " + fa,
+		"[Premier League] Fulham - Stoke " + statusScheduled + " " + pl,
+		"Liverpool - Newcastle " + statusScheduled + " " + pl,
+		"/img?u=https%3A%2F%2Fimg.tvmatchen.nu%2Ftv4.png",
+		"",
+		"",
+		"till more no.such.key",
+	}, "\n")
+	if got := b.String(); got != want {
+		t.Errorf("rendered\n%s\nwant\n%s", got, want)
+	}
+
+	// Colors are stable and from the palette
+	if colorFor("FA Cup") != fa || !strings.Contains(strings.Join(leaguePalette, " "), fa) {
+		t.Errorf("colorFor(%q) = %q, want the same palette color every time", "FA Cup", fa)
+	}
+}
@@ -0,0 +1,56 @@
+package alexmatchen
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Response writer of the shared middleware collecting how long the phases
+// of handling a request took, sent in the Server-Timing header with the
+// response status. Timings use the wall clock rather than now, so they stay
+// meaningful when tests freeze it.
+type timingWriter struct {
+	http.ResponseWriter
+	start  time.Time
+	phases []string
+	sent   bool
+}
+
+func withTiming(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(&timingWriter{ResponseWriter: w, start: time.Now()}, r)
+	}
+}
+
+func (w *timingWriter) WriteHeader(status int) {
+	if !w.sent {
+		w.sent = true
+		phases := append(w.phases, timingEntry("total", time.Since(w.start)))
+		w.Header().Set("Server-Timing", strings.Join(phases, ", "))
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timingWriter) Write(b []byte) (int, error) {
+	if !w.sent {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Records that a phase of handling a request, such as "refresh", "filter"
+// or "render", took from start until now. Phases ending after the response
+// status was written, or writers not from the middleware, are ignored.
+func recordTiming(w http.ResponseWriter, phase string, start time.Time) {
+	if tw, ok := w.(*timingWriter); ok && !tw.sent {
+		tw.phases = append(tw.phases, timingEntry(phase, time.Since(start)))
+	}
+}
+
+func timingEntry(phase string, d time.Duration) string {
+	return fmt.Sprintf("%s;dur=%.1f", phase, float64(d)/float64(time.Millisecond))
+}
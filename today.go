@@ -0,0 +1,52 @@
+package alexmatchen
+
+import (
+	"net/http"
+	"time"
+)
+
+// Body of /today/remaining.json.
+type remaining struct {
+	Count   int
+	Matches []*match
+}
+
+func init() {
+	// Today's matches yet to kick off, for an "X games left today" widget
+	handle("/today/remaining.json", func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseFilters(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		d, err := parseDisplay(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		if err := refreshScheduleIfNeeded(w, r); err != nil {
+			jsonRefreshError(w, err)
+			return
+		}
+
+		t := now()
+		matches := remainingToday(d.order(f.apply(d.format(scheduleAt(cachedSchedule(), t)))), t)
+		writeJSON(w, r, &remaining{Count: len(matches), Matches: matches})
+	})
+}
+
+// Returns the matches of the day of t kicking off after t, in the order of
+// the day in the schedule, see display.order. After the day's last kickoff
+// the list is empty.
+func remainingToday(s map[string][]*match, t time.Time) []*match {
+	left := []*match{}
+	for _, m := range s[midnight(t).Format("2006-01-02")] {
+		if m.TimeKnown && m.Kickoff.After(t) {
+			left = append(left, m)
+		}
+	}
+
+	return left
+}
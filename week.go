@@ -0,0 +1,85 @@
+package alexmatchen
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	weekDays = 7
+
+	// Maximum number of notable matches listed per day.
+	notableLimit = 5
+)
+
+// Summary of one day for the weekly digest.
+type weekDay struct {
+	Date    string
+	Count   int
+	Notable []*match
+}
+
+func init() {
+	handle("/week.json", func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseFilters(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		d, err := parseDisplay(r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+
+		if err := refreshScheduleIfNeeded(w, r); err != nil {
+			jsonRefreshError(w, err)
+			return
+		}
+
+		writeJSON(w, r, weekSummary(d.format(scheduleAt(cachedSchedule(), now())), f, now()))
+	})
+}
+
+// Summarizes the seven days starting today. The league and channel filters
+// narrow the matches counted, while teams mark favorites instead of
+// filtering: a day's notable matches are its favorites if it has any, and
+// otherwise all its matches, in kickoff order and capped at notableLimit.
+func weekSummary(s map[string][]*match, f *filters, t time.Time) []*weekDay {
+	favorites := &filters{Teams: f.Teams, IncludeTBD: f.IncludeTBD}
+	narrowed := *f
+	narrowed.Teams = nil
+
+	today := midnight(t)
+	end := today.AddDate(0, 0, weekDays)
+
+	summary := []*weekDay{}
+	for _, d := range orderedDays(narrowed.apply(s)) {
+		date, ok := dayDate(d.Date)
+		if !ok || date.Before(today) || !date.Before(end) {
+			continue
+		}
+
+		notable := []*match{}
+		if len(favorites.Teams) > 0 {
+			for _, m := range d.Matches {
+				if favorites.match(m) {
+					notable = append(notable, m)
+				}
+			}
+		}
+
+		if len(notable) == 0 {
+			notable = d.Matches
+		}
+
+		if len(notable) > notableLimit {
+			notable = notable[:notableLimit]
+		}
+
+		summary = append(summary, &weekDay{Date: d.Date, Count: len(d.Matches), Notable: notable})
+	}
+
+	return summary
+}
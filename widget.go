@@ -0,0 +1,76 @@
+package alexmatchen
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+)
+
+// Embeddable list of upcoming matches, see /widget.
+var widget = template.Must(template.New("widget").Funcs(templateFuncs).Parse(widgetTemplate))
+
+func init() {
+	handle("/widget", func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseFilters(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		d, err := parseDisplay(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := refreshScheduleIfNeeded(w, r); err != nil {
+			http.Error(w, err.Error(), refreshErrorStatus(err))
+			return
+		}
+
+		// Only matches that haven't finished yet
+		upcoming := []*match{}
+		for _, m := range d.flatten(f.apply(d.format(scheduleAt(cachedSchedule(), now()))), false) {
+			if m.Status != statusFinished {
+				upcoming = append(upcoming, m)
+			}
+		}
+
+		var b bytes.Buffer
+		last, _ := refreshStatus()
+		data := &templateData{Lang: d.lang(), Schedule: []*day{{Matches: upcoming}}, LastRefresh: d.stamp(last)}
+		if err := widget.Execute(&b, data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(b.Bytes())
+	})
+}
+
+const widgetTemplate = `<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="utf-8" />
+		<style type="text/css">
+			body { margin: 0; font: 12px arial, sans-serif; color: #333333; }
+			ul { list-style: none; margin: 0; padding: 0; }
+			li { padding: 2px 4px; border-bottom: 1px solid #eeeeee; white-space: nowrap; overflow: hidden; text-overflow: ellipsis; }
+			.time { color: #c5752a; }
+			.channel { color: #777777; }
+		</style>
+	</head>
+	<body>
+		<ul>
+			{{range $day := .Schedule}}{{range $match := $day.Matches}}
+				<li title="{{$match.League}}">
+					<span class="time">{{if not $match.TimeKnown}}{{t $.Lang "time.tbd"}}{{else}}{{$match.Kickoff.Format "02/01"}} {{$match.Time}}{{end}}</span>
+					{{taggedName $match}}
+					<span class="channel">{{$match.Channel}}</span>
+				</li>
+			{{end}}{{end}}
+		</ul>
+	</body>
+</html>
+`